@@ -9,35 +9,113 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/events"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/events/memory"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/jwt"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/mail"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/oidc"
 	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
 	"github.com/ahmadmuzakkir/go-sample-api-server-structure/version"
 	"github.com/go-chi/chi"
-)
-
-const (
-	tokenExpiry = 24 * time.Hour
+	"golang.org/x/crypto/bcrypt"
 )
 
 type Handler struct {
 	logger *log.Logger
 	router chi.Router
 	store  store.Store
+
+	accessTokenLifetime  time.Duration
+	refreshTokenLifetime time.Duration
+	sessionIdleTimeout   time.Duration
+	sessionMaxLifetime   time.Duration
+	tokenHashSecret      []byte
+
+	// signer is nil in the opaque token mode.
+	signer   *jwt.Signer
+	denyList jwt.DenyList
+
+	// mailer is nil if the password reset and the email verification are disabled.
+	mailer mail.Mailer
+	// mails are the emails being sent after the response, see sendInBackground.
+	mails sync.WaitGroup
+
+	emailVerification EmailVerification
+	// baseURL is the public URL of the API in the links of the emails.
+	baseURL string
+
+	passwordHasher PasswordHasher
+
+	usernameLockout LockoutPolicy
+	ipLockout       LockoutPolicy
+
+	registrationPolicy RegistrationPolicy
+
+	// totpKey encrypts the TOTP secrets kept in the store. It is nil if the two-factor authentication is disabled.
+	totpKey    []byte
+	totpIssuer string
+
+	// oidcProvider is nil if the login with an identity provider is disabled.
+	oidcProvider      *oidc.Provider
+	oidcAutoProvision bool
+
+	// publisher is nil if the outbox relay publishes the events, see WithOutboxEvents.
+	publisher  events.Publisher
+	subscriber events.Subscriber
+	// stopEvents ends the subscription.
+	stopEvents context.CancelFunc
+
+	wsHub    *wsHub
+	eventLog *eventLog
 }
 
-func NewHandler(store store.Store, logger *log.Logger) *Handler {
+func NewHandler(store store.Store, logger *log.Logger, opts ...Option) *Handler {
 	h := &Handler{
-		store:  store,
-		logger: logger,
+		store:                store,
+		logger:               logger,
+		accessTokenLifetime:  defaultAccessTokenLifetime,
+		refreshTokenLifetime: defaultRefreshTokenLifetime,
+		sessionIdleTimeout:   defaultSessionIdleTimeout,
+		sessionMaxLifetime:   defaultSessionMaxLifetime,
+		passwordHasher:       &BcryptHasher{cost: bcrypt.DefaultCost},
+		usernameLockout:      defaultUsernameLockout,
+		ipLockout:            defaultIPLockout,
+		registrationPolicy:   DefaultRegistrationPolicy,
+		wsHub:                newWSHub(),
+		eventLog:             newEventLog(eventLogSize),
+	}
+
+	for _, opt := range opts {
+		opt(h)
 	}
 
+	if h.subscriber == nil {
+		bus := memory.New()
+		h.publisher, h.subscriber = bus, bus
+	}
+
+	var ctx context.Context
+	ctx, h.stopEvents = context.WithCancel(context.Background())
+
+	go h.pushEvents(h.subscriber.Subscribe(ctx))
+
 	r := chi.NewRouter()
 
 	// No authentication
 	r.Group(func(r chi.Router) {
 		r.Post("/login", h.login)
 		r.Post("/register", h.register)
+		r.Post("/token/refresh", h.refreshToken)
+		r.Post("/password/forgot", h.forgotPassword)
+		r.Post("/password/reset", h.resetPassword)
+		r.Get("/verify", h.verifyEmail)
+		r.Post("/verify/resend", h.resendEmailVerification)
+		r.Post("/login/2fa", h.loginTwoFactor)
+		r.Get("/auth/oidc/start", h.startOIDC)
+		r.Get("/auth/oidc/callback", h.oidcCallback)
 		r.Get("/version/", h.version())
 	})
 
@@ -45,19 +123,48 @@ func NewHandler(store store.Store, logger *log.Logger) *Handler {
 	r.Group(func(r chi.Router) {
 		r.Use(h.authenticate)
 
-		r.Get("/me", h.getMe())
+		// The API keys cannot manage the account.
+		r.Group(func(r chi.Router) {
+			r.Use(h.requireSession)
 
-		r.Get("/", h.getMessages())
-		r.Post("/", h.createMessage)
+			r.Post("/me/password", h.changePassword)
+			r.Post("/me/2fa/totp", h.enrollTOTP())
+			r.Post("/me/2fa/totp/confirm", h.confirmTOTP())
+			r.Delete("/me/2fa/totp", h.disableTOTP)
+
+			r.Get("/me/api-keys", h.getAPIKeys())
+			r.Post("/me/api-keys", h.createAPIKey)
+			r.Delete("/me/api-keys/{id}", h.deleteAPIKey)
+
+			r.Post("/logout", h.logout)
+			r.Post("/logout/all", h.logoutAll)
+			r.Get("/sessions", h.getSessions())
+		})
+
+		r.With(h.requireScope(scopeProfileRead)).Get("/me", h.getMe())
+
+		r.With(h.requireScope(scopeMessagesRead)).Get("/", h.getMessages())
+		r.With(h.requireScope(scopeMessagesWrite)).Post("/", h.createMessage)
+
+		r.With(h.requireScope(scopeMessagesRead)).Get("/sent", h.getSentMessages())
+
+		r.With(h.requireScope(scopeMessagesRead)).Get("/ws", h.serveWS)
 
 		r.Route("/{id}", func(r chi.Router) {
-			r.Use(h.authorizeMessage)
+			r.With(h.requireScope(scopeMessagesRead), h.authorizeMessageRead).Get("/", h.getMessage())
+
+			r.Group(func(r chi.Router) {
+				r.Use(h.requireScope(scopeMessagesWrite), h.authorizeMessage)
 
-			r.Post("/", h.updateFood())
-			r.Delete("/", h.deleteFood)
+				r.Post("/", h.updateFood())
+				r.Delete("/", h.deleteFood)
+			})
 		})
 	})
 
+	// The browsers cannot set the headers of an event stream, so the token can be a query parameter too.
+	r.With(h.tokenFromQuery, h.authenticate, h.requireScope(scopeMessagesRead)).Get("/events", h.streamEvents)
+
 	h.router = r
 
 	return h
@@ -91,16 +198,32 @@ func (h *Handler) createMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	userID := r.Context().Value("user_id").(int64)
+
+	if h.emailVerification >= EmailVerificationForSending {
+		user, err := h.store.User().GetByID(r.Context(), userID)
+		if err != nil {
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if !h.emailVerified(user, EmailVerificationForSending) {
+			renderError(w, http.StatusForbidden, errEmailNotVerified.Error())
+			return
+		}
+	}
+
 	now := time.Now()
 
 	msg := store.Message{
 		Content:         req.Content,
-		SenderID:        r.Context().Value("user_id").(int64),
+		SenderID:        userID,
 		SentDateTime:    now,
 		UpdatedDateTime: now,
 	}
 
-	if err := h.store.Message().Create(r.Context(), msg, req.Recipients); err != nil {
+	msgID, err := h.store.Message().Create(r.Context(), msg, req.Recipients)
+	if err != nil {
 		if err == store.ErrDuplicate {
 			renderError(w, http.StatusBadRequest, "duplicate productId")
 			return
@@ -110,31 +233,99 @@ func (h *Handler) createMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.publishMessageCreated(r.Context(), msgID, req.Recipients)
+
 	w.WriteHeader(http.StatusCreated)
 }
 
 func (h *Handler) getMessages() http.HandlerFunc {
 	type response struct {
-		Messages []*store.Message `json:"messages"`
+		Messages   []*store.Message `json:"messages"`
+		NextCursor string           `json:"next_cursor,omitempty"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID := r.Context().Value("user_id").(int64)
 
-		messages, err := h.store.Message().Get(r.Context(), userID)
+		after, limit, err := parsePage(r)
+		if err != nil {
+			renderError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// Fetch one more message to know if there is a next page.
+		messages, err := h.store.Message().GetPage(r.Context(), userID, after, limit+1)
 		if err != nil {
 			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		var nextCursor string
+		if len(messages) > limit {
+			messages = messages[:limit]
+			nextCursor = encodeCursor(messages[limit-1])
 		}
 
 		render(w, http.StatusOK, response{
-			Messages: messages,
+			Messages:   messages,
+			NextCursor: nextCursor,
 		})
 	}
 }
 
+func (h *Handler) getSentMessages() http.HandlerFunc {
+	type response struct {
+		Messages   []*store.Message `json:"messages"`
+		NextCursor string           `json:"next_cursor,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("user_id").(int64)
+
+		after, limit, err := parsePage(r)
+		if err != nil {
+			renderError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// Fetch one more message to know if there is a next page.
+		messages, err := h.store.Message().GetSent(r.Context(), userID, after, limit+1)
+		if err != nil {
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		var nextCursor string
+		if len(messages) > limit {
+			messages = messages[:limit]
+			nextCursor = encodeCursor(messages[limit-1])
+		}
+
+		render(w, http.StatusOK, response{
+			Messages:   messages,
+			NextCursor: nextCursor,
+		})
+	}
+}
+
+func (h *Handler) getMessage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		msg := r.Context().Value("msg").(*store.Message)
+
+		render(w, http.StatusOK, msg)
+	}
+}
+
 func (h *Handler) deleteFood(w http.ResponseWriter, r *http.Request) {
 	msg := r.Context().Value("msg").(*store.Message)
 
+	// The recipients are gone along with the message.
+	recipients, err := h.store.Message().GetRecipients(r.Context(), msg.ID)
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	if err := h.store.Message().Delete(r.Context(), msg.ID); err == store.ErrNotFound {
 		renderError(w, http.StatusBadRequest, "invalid message id")
 		return
@@ -143,6 +334,12 @@ func (h *Handler) deleteFood(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.publishEvent(events.Event{
+		Type:         events.MessageDeleted,
+		Message:      msg,
+		RecipientIDs: recipients,
+	})
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -177,6 +374,12 @@ func (h *Handler) updateFood() http.HandlerFunc {
 			return
 		}
 
+		oldRecipients, err := h.store.Message().GetRecipients(r.Context(), msg.ID)
+		if err != nil {
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
 		msg.Content = req.Content
 		msg.UpdatedDateTime = time.Now()
 
@@ -188,33 +391,53 @@ func (h *Handler) updateFood() http.HandlerFunc {
 			return
 		}
 
+		h.publishEvent(events.Event{
+			Type:                 events.MessageUpdated,
+			Message:              msg,
+			RecipientIDs:         req.Recipients,
+			PreviousRecipientIDs: oldRecipients,
+		})
+
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
+// authenticate accepts a session's access token, or an API key. An API key is given either as an Authorization Bearer
+// header, told apart by its prefix, or as an X-API-Key header.
 func (h *Handler) authenticate(next http.Handler) http.Handler {
 	f := func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get(apiKeyHeader); key != "" {
+			h.authenticateAPIKey(w, r, key, next)
+			return
+		}
+
 		bearer := r.Header.Get("Authorization")
-		if len(bearer) <= 7 && strings.ToUpper(bearer[0:6]) == "BEARER" {
+		if len(bearer) <= 7 || strings.ToUpper(bearer[0:6]) != "BEARER" {
 			renderError(w, http.StatusUnauthorized, "Missing Authorization Bearer header")
 			return
 		}
 
-		token, err := h.store.Token().GetUserID(r.Context(), bearer[7:])
-		if err == store.ErrNotFound {
+		if strings.HasPrefix(bearer[7:], apiKeyPrefix) {
+			h.authenticateAPIKey(w, r, bearer[7:], next)
+			return
+		}
+
+		session, err := h.verifyToken(r.Context(), bearer[7:], time.Now())
+		if err == errInvalidToken {
 			renderError(w, http.StatusUnauthorized, "Invalid token")
 			return
+		} else if err == errExpiredToken {
+			renderError(w, http.StatusUnauthorized, "Expired token")
+			return
 		} else if err != nil {
 			renderError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		if time.Now().After(token.UpdatedAt.Add(tokenExpiry)) {
-			renderError(w, http.StatusUnauthorized, "Expired token")
-			return
-		}
+		ctx := context.WithValue(r.Context(), "user_id", session.UserID)
+		ctx = context.WithValue(ctx, "token_id", session.ID)
 
-		r = r.WithContext(context.WithValue(r.Context(), "user_id", token.UserID))
+		r = r.WithContext(ctx)
 
 		next.ServeHTTP(w, r)
 	}
@@ -222,31 +445,97 @@ func (h *Handler) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(f)
 }
 
+func (h *Handler) authenticateAPIKey(w http.ResponseWriter, r *http.Request, key string, next http.Handler) {
+	k, err := h.verifyAPIKey(r.Context(), key, time.Now())
+	if err == errInvalidToken {
+		renderError(w, http.StatusUnauthorized, "Invalid API key")
+		return
+	} else if err == errExpiredToken {
+		renderError(w, http.StatusUnauthorized, "Expired API key")
+		return
+	} else if err != nil {
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), "user_id", k.UserID)
+	ctx = context.WithValue(ctx, apiKeyContextKey, k)
+
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// messageFromURL returns the message of the id URL parameter. It responds with the error and returns nil if the
+// id is invalid, or with notFoundStatus if there is no such message.
+func (h *Handler) messageFromURL(w http.ResponseWriter, r *http.Request, notFoundStatus int) *store.Message {
+	var msgID int64
+
+	if val := chi.URLParam(r, "id"); val != "" {
+		msgID, _ = strconv.ParseInt(val, 10, 64)
+	}
+
+	if msgID == 0 {
+		renderError(w, http.StatusBadRequest, "invalid id")
+		return nil
+	}
+
+	msg, err := h.store.Message().GetByID(r.Context(), msgID)
+	if err == store.ErrNotFound {
+		if notFoundStatus == http.StatusNotFound {
+			renderError(w, notFoundStatus, "message not found")
+		} else {
+			renderError(w, notFoundStatus, "invalid message id")
+		}
+		return nil
+	} else if err != nil {
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return nil
+	}
+
+	return msg
+}
+
 func (h *Handler) authorizeMessage(next http.Handler) http.Handler {
 	f := func(w http.ResponseWriter, r *http.Request) {
 		userID := r.Context().Value("user_id").(int64)
 
-		var msgID int64
-
-		if val := chi.URLParam(r, "id"); val != "" {
-			msgID, _ = strconv.ParseInt(val, 10, 64)
+		msg := h.messageFromURL(w, r, http.StatusBadRequest)
+		if msg == nil {
+			return
 		}
 
-		if msgID == 0 {
-			renderError(w, http.StatusBadRequest, "invalid id")
+		if msg.SenderID != userID {
+			renderError(w, http.StatusForbidden, "not permitted")
 			return
 		}
 
-		msg, err := h.store.Message().GetByID(r.Context(), msgID)
-		if err == store.ErrNotFound {
-			renderError(w, http.StatusBadRequest, "invalid message id")
+		ctx := context.WithValue(r.Context(), "msg", msg)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+
+	return http.HandlerFunc(f)
+}
+
+// authorizeMessageRead allows the sender and the recipients of the message.
+// The message is put into the context along with its recipients.
+func (h *Handler) authorizeMessageRead(next http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("user_id").(int64)
+
+		msg := h.messageFromURL(w, r, http.StatusNotFound)
+		if msg == nil {
 			return
-		} else if err != nil {
+		}
+
+		var err error
+
+		msg.Recipients, err = h.store.Message().GetRecipients(r.Context(), msg.ID)
+		if err != nil {
 			renderError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		if msg.SenderID != userID {
+		if msg.SenderID != userID && !containsID(msg.Recipients, userID) {
 			renderError(w, http.StatusForbidden, "not permitted")
 			return
 		}
@@ -263,6 +552,9 @@ func (h *Handler) getMe() http.HandlerFunc {
 	type response struct {
 		ID       int64  `json:"user_id"`
 		Username string `json:"username"`
+		Email    string `json:"email,omitempty"`
+		// EmailVerifiedAt is nil until the email address is verified.
+		EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -278,8 +570,10 @@ func (h *Handler) getMe() http.HandlerFunc {
 		}
 
 		render(w, http.StatusOK, response{
-			ID:       user.ID,
-			Username: user.Username,
+			ID:              user.ID,
+			Username:        user.Username,
+			Email:           user.Email,
+			EmailVerifiedAt: user.VerifiedAt,
 		})
 	}
 }
@@ -319,6 +613,16 @@ func render(w http.ResponseWriter, status int, v interface{}) {
 	w.Write(buf.Bytes())
 }
 
+func containsID(ids []int64, id int64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+
+	return false
+}
+
 func renderError(w http.ResponseWriter, status int, message string) {
 	res := struct {
 		Message string `json:"message"`
@@ -1,18 +1,35 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/base32"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	memoryevents "github.com/ahmadmuzakkir/go-sample-api-server-structure/events/memory"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/jwt"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/mail"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/oidc"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/oidc/oidctest"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/outbox"
 	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store/memory"
 	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store/mock"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/totp"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -22,24 +39,17 @@ import (
 func TestLogin(t *testing.T) {
 	user := getUser(t, "username", "password", 1)
 
-	mockStore := &mock.Store{
-		UserStore: &mock.UserStore{
-			OnGetByUsername: func(ctx context.Context, username string) (*store.User, error) {
-				if user.Username == username {
-					return user, nil
-				}
+	memoryStore := memory.New()
 
-				return nil, store.ErrNotFound
-			},
-		},
-	}
+	err := memoryStore.User().Create(context.Background(), user.Username, user.PasswordHash)
+	assert.NoError(t, err)
 
 	type req struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
 	}
 
-	handler := NewHandler(mockStore, nil)
+	handler := newTestHandler(t, memoryStore, nil)
 
 	tests := []struct {
 		name     string
@@ -98,205 +108,2452 @@ func TestLogin(t *testing.T) {
 	}
 }
 
-// Test the authentication checking on all the routes that require authentication.
-func TestRequireAuthenticateRoutes(t *testing.T) {
-	mockStore := &mock.Store{
-		TokenStore: &mock.TokenStore{
-			OnCreate: nil,
-			OnGetUserID: func(ctx context.Context, token string) (*store.Token, error) {
-				if token == "exists" {
-					return &store.Token{
-						UserID:    1,
-						UpdatedAt: time.Now(),
-					}, nil
-				}
+func TestRegister(t *testing.T) {
+	memoryStore := memory.New()
 
-				return nil, store.ErrNotFound
-			},
-		},
-	}
+	err := memoryStore.User().Create(context.Background(), "existing", "hash")
+	assert.NoError(t, err)
 
-	handler := NewHandler(mockStore, nil)
+	handler := newTestHandler(t, memoryStore, nil, WithPasswordHasher(&BcryptHasher{cost: bcrypt.MinCost}))
 
 	tests := []struct {
-		url    string
-		method string
+		name     string
+		username string
+		password string
+		wantCode int
+		// wantErrors is the messages of the field errors.
+		wantErrors []string
 	}{
 		{
-			url:    "/",
-			method: "POST",
+			name:       "empty request",
+			wantCode:   http.StatusBadRequest,
+			wantErrors: []string{"username is empty", "password is empty"},
 		},
 		{
-			url:    "/1",
-			method: "POST",
+			name:       "blank username",
+			username:   "   ",
+			password:   "correct horse battery",
+			wantCode:   http.StatusBadRequest,
+			wantErrors: []string{"username is empty"},
 		},
 		{
-			url:    "/1",
-			method: "GET",
+			name:       "short username",
+			username:   "ab",
+			password:   "correct horse battery",
+			wantCode:   http.StatusBadRequest,
+			wantErrors: []string{"username must be at least 3 characters"},
 		},
 		{
-			url:    "/",
-			method: "GET",
+			name:       "username characters",
+			username:   "user name",
+			password:   "correct horse battery",
+			wantCode:   http.StatusBadRequest,
+			wantErrors: []string{"username has characters that are not allowed"},
 		},
 		{
-			url:    "/1",
-			method: "DELETE",
+			name:       "reserved username",
+			username:   "Admin",
+			password:   "correct horse battery",
+			wantCode:   http.StatusBadRequest,
+			wantErrors: []string{"username is reserved"},
+		},
+		{
+			name:       "short password",
+			username:   "username",
+			password:   "Xy7!",
+			wantCode:   http.StatusBadRequest,
+			wantErrors: []string{"password must be at least 8 characters"},
+		},
+		{
+			name:       "long password",
+			username:   "username",
+			password:   "correct horse battery staple " + strings.Repeat("x", 44),
+			wantCode:   http.StatusBadRequest,
+			wantErrors: []string{"password must be at most 72 bytes"},
+		},
+		{
+			name:       "common password",
+			username:   "username",
+			password:   "Password123",
+			wantCode:   http.StatusBadRequest,
+			wantErrors: []string{"password is too common"},
+		},
+		{
+			name:       "weak password",
+			username:   "username",
+			password:   "aaaaaaaaaaaaaaaa",
+			wantCode:   http.StatusBadRequest,
+			wantErrors: []string{"password is too weak, use more and different characters"},
+		},
+		{
+			name:       "control characters",
+			username:   "username",
+			password:   "abcd\x01\x02\x03\x04\x05\x06",
+			wantCode:   http.StatusBadRequest,
+			wantErrors: []string{"password is too weak, use more and different characters"},
+		},
+		{
+			name:       "password is username",
+			username:   "my.username",
+			password:   "My.Username",
+			wantCode:   http.StatusBadRequest,
+			wantErrors: []string{"password is the same as the username"},
+		},
+		{
+			name:     "duplicate username",
+			username: "Existing",
+			password: "correct horse battery",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "success",
+			username: " New.User@example.com ",
+			password: "correct horse battery",
+			wantCode: http.StatusCreated,
 		},
 	}
 
 	for _, tc := range tests {
-		t.Run(tc.url, func(t *testing.T) {
-			// Test empty token
-			{
-				request := httptest.NewRequest(tc.method, tc.url, nil)
+		t.Run(tc.name, func(t *testing.T) {
+			body, err := json.Marshal(map[string]string{"username": tc.username, "password": tc.password})
+			assert.NoError(t, err)
 
-				w := httptest.NewRecorder()
+			request := httptest.NewRequest("POST", "/register", bytes.NewReader(body))
 
-				handler.ServeHTTP(w, request)
+			w := httptest.NewRecorder()
 
-				_, err := ioutil.ReadAll(w.Body)
-				assert.Nil(t, err)
+			handler.ServeHTTP(w, request)
 
-				assert.NotEqual(t, http.StatusNotFound, w.Code, "status code")
-				assert.Equal(t, http.StatusUnauthorized, w.Code, "status code")
+			assert.Equal(t, tc.wantCode, w.Code, "status code")
+
+			var res struct {
+				Errors []fieldError `json:"errors"`
 			}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
 
-			// Test token user does not exist
-			{
-				request := httptest.NewRequest(tc.method, tc.url, nil)
-				request.Header.Add("Authorization", "Bearer notexists")
+			var gotErrors []string
+			for _, e := range res.Errors {
+				gotErrors = append(gotErrors, e.Message)
+			}
 
-				w := httptest.NewRecorder()
+			assert.Equal(t, tc.wantErrors, gotErrors)
+		})
+	}
 
-				handler.ServeHTTP(w, request)
+	_, err = memoryStore.User().GetByUsername(context.Background(), "new.user@example.com")
+	assert.NoError(t, err)
+}
 
-				_, err := ioutil.ReadAll(w.Body)
-				assert.Nil(t, err)
+// setEmailFailingStore fails to set the email addresses of the users.
+type setEmailFailingStore struct {
+	store.Store
+}
 
-				assert.NotEqual(t, http.StatusNotFound, w.Code, "status code")
-				assert.Equal(t, http.StatusUnauthorized, w.Code, "status code")
-			}
+func (s setEmailFailingStore) User() store.UserStore {
+	return setEmailFailingUserStore{s.Store.User()}
+}
 
-			// Test success
-			{
-				request := httptest.NewRequest(tc.method, tc.url, nil)
-				request.Header.Add("Authorization", "Bearer exists")
+type setEmailFailingUserStore struct {
+	store.UserStore
+}
 
-				w := httptest.NewRecorder()
+func (setEmailFailingUserStore) SetEmail(ctx context.Context, id int64, email string) error {
+	return errors.New("store failed")
+}
 
-				handler.ServeHTTP(w, request)
+func TestRegisterSetEmailFailure(t *testing.T) {
+	memoryStore := memory.New()
 
-				_, err := ioutil.ReadAll(w.Body)
-				assert.Nil(t, err)
+	handler := newTestHandler(t, setEmailFailingStore{memoryStore}, log.New(ioutil.Discard, "", 0),
+		WithMailer(&testMailer{}), WithPasswordHasher(&BcryptHasher{cost: bcrypt.MinCost}))
 
-				assert.NotEqual(t, http.StatusNotFound, w.Code, "status code")
-				assert.NotEqual(t, http.StatusUnauthorized, w.Code, "status code")
-			}
-		})
+	body := `{"username":"bob","password":"correct horse battery","email":"bob@example.com"}`
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/register", strings.NewReader(body)))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// The user is not left behind without the email address.
+	_, err := memoryStore.User().GetByUsername(context.Background(), "bob")
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+func TestCommonPasswords(t *testing.T) {
+	// The default rejects the whole list.
+	lines := strings.Split(strings.TrimSpace(commonPasswordsList), "\n")
+	assert.Equal(t, len(lines), DefaultRegistrationPolicy.CommonPasswords)
+
+	assert.True(t, isCommonPassword(lines[len(lines)-1], DefaultRegistrationPolicy.CommonPasswords))
+	assert.False(t, isCommonPassword(lines[len(lines)-1], len(lines)-1))
+}
+
+func TestLoginRehashesPassword(t *testing.T) {
+	memoryStore := memory.New()
+
+	oldHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	err = memoryStore.User().Create(context.Background(), "username", string(oldHash))
+	assert.NoError(t, err)
+
+	hasher, err := NewArgon2idHasher(Argon2idParams{Memory: 64, Time: 1, Threads: 1})
+	if !assert.NoError(t, err) {
+		t.FailNow()
 	}
+
+	handler := newTestHandler(t, memoryStore, nil, WithPasswordHasher(hasher))
+
+	login := func(password string) int {
+		request := httptest.NewRequest("POST", "/login", strings.NewReader(`{"username":"username","password":"`+password+`"}`))
+
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, request)
+
+		return w.Code
+	}
+
+	// A wrong password does not change the hash.
+	assert.Equal(t, http.StatusUnauthorized, login("wrong"))
+
+	user, err := memoryStore.User().GetByUsername(context.Background(), "username")
+	if assert.NoError(t, err) {
+		assert.Equal(t, string(oldHash), user.PasswordHash)
+	}
+
+	assert.Equal(t, http.StatusOK, login("password"))
+
+	user, err = memoryStore.User().GetByUsername(context.Background(), "username")
+	if assert.NoError(t, err) {
+		assert.True(t, strings.HasPrefix(user.PasswordHash, "$argon2id$"), user.PasswordHash)
+		assert.False(t, hasher.NeedsRehash(user.PasswordHash))
+	}
+
+	// The new hash still logs in.
+	assert.Equal(t, http.StatusOK, login("password"))
 }
 
-func TestGetMessages(t *testing.T) {
-	messages := []*store.Message{{
-		ID:              1,
-		Content:         "content",
-		Sender:          "sender",
-		SentDateTime:    time.Now(),
-		UpdatedDateTime: time.Now(),
-	}}
+func TestLoginLockout(t *testing.T) {
+	user := getUser(t, "username", "password", 1)
 
-	mockStore := &mock.Store{
-		MessageStore: &mock.MessageStore{
-			OnGet: func(ctx context.Context, userID int64) ([]*store.Message, error) {
-				if userID == 2 {
-					return messages, nil
-				}
-				return nil, nil
-			},
-		},
-		TokenStore: &mock.TokenStore{
-			OnCreate: nil,
-			OnGetUserID: func(ctx context.Context, token string) (*store.Token, error) {
-				if token == "empty" {
-					return &store.Token{
-						UserID:    1,
-						UpdatedAt: time.Now(),
-					}, nil
-				}
+	memoryStore := memory.New()
 
-				if token == "not empty" {
-					return &store.Token{
-						UserID:    2,
-						UpdatedAt: time.Now(),
-					}, nil
-				}
+	err := memoryStore.User().Create(context.Background(), user.Username, user.PasswordHash)
+	assert.NoError(t, err)
 
-				return nil, store.ErrNotFound
-			},
-		},
+	var logs bytes.Buffer
+
+	handler := newTestHandler(t, memoryStore, log.New(&logs, "", 0),
+		WithUsernameLockout(LockoutPolicy{MaxFailures: 2, Lockout: time.Minute, MaxLockout: 3 * time.Minute, ResetAfter: time.Hour}),
+		WithIPLockout(LockoutPolicy{}))
+
+	login := func(password string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", "/login", strings.NewReader(`{"username":"username","password":"`+password+`"}`))
+
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, request)
+
+		return w
 	}
 
-	type res struct {
-		Messages []*store.Message `json:"messages"`
+	// unlock ends the lockout now, as if it has passed.
+	unlock := func() {
+		err := memoryStore.LoginFailure().Lock(context.Background(), "username:username", time.Now())
+		assert.NoError(t, err)
 	}
 
-	handler := NewHandler(mockStore, nil)
+	assert.Equal(t, http.StatusUnauthorized, login("wrong").Code)
+	assert.Equal(t, http.StatusUnauthorized, login("wrong").Code)
 
-	tests := []struct {
-		name     string
-		token    string
-		wantCode int
-		response interface{}
-	}{
-		{
-			name:     "empty",
-			token:    "empty",
-			wantCode: http.StatusOK,
-			response: res{
-				Messages: nil,
-			},
-		},
-		{
-			name:     "not empty",
-			token:    "not empty",
-			wantCode: http.StatusOK,
-			response: res{
-				Messages: messages,
-			},
-		},
+	// Even the right password is rejected during the lockout.
+	w := login("password")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assertRetryAfter(t, time.Minute, w)
+
+	// The lockout doubles on every failed login after that, up to the maximum.
+	for _, want := range []time.Duration{2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		unlock()
+
+		assert.Equal(t, http.StatusUnauthorized, login("wrong").Code)
+
+		w := login("wrong")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assertRetryAfter(t, want, w)
 	}
 
-	for _, tc := range tests {
-		t.Run(tc.name, func(t *testing.T) {
-			request := httptest.NewRequest("GET", "/", nil)
-			request.Header.Add("Content-Type", "application/json")
-			if tc.token != "" {
-				request.Header.Add("Authorization", "Bearer "+tc.token)
-			}
+	assert.Contains(t, logs.String(), "username:username locked out for 3m0s after 5 failed logins")
 
-			w := httptest.NewRecorder()
+	// A successful login forgets the failed logins.
+	unlock()
 
-			handler.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusOK, login("password").Code)
+	assert.Contains(t, logs.String(), "username:username unlocked by the end of its lockout")
 
-			actualJSON, err := ioutil.ReadAll(w.Body)
-			assert.Nil(t, err)
+	_, err = memoryStore.LoginFailure().Get(context.Background(), "username:username")
+	assert.Equal(t, store.ErrNotFound, err)
 
-			assert.Equal(t, tc.wantCode, w.Code, "status code")
+	assert.Equal(t, http.StatusUnauthorized, login("wrong").Code)
+	assert.Equal(t, http.StatusOK, login("password").Code)
 
-			expectedJSON, err := json.Marshal(tc.response)
-			assert.NoError(t, err)
+	// The concurrent guesses are counted one by one, so no more than allowed get through.
+	codes := make(chan int, 10)
 
-			assert.NoError(t, compareJSON(expectedJSON, actualJSON))
-		})
+	var wg sync.WaitGroup
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- login("wrong").Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := make(map[int]int)
+	for code := range codes {
+		counts[code]++
 	}
+
+	assert.Equal(t, map[int]int{http.StatusUnauthorized: 2, http.StatusTooManyRequests: 8}, counts)
 }
 
-func compareJSON(expected []byte, response []byte) error {
-	if bytes.Equal(bytes.TrimSpace(response), expected) {
-		return nil
+func TestLoginIPLockout(t *testing.T) {
+	memoryStore := memory.New()
+
+	handler := newTestHandler(t, memoryStore, log.New(ioutil.Discard, "", 0),
+		WithUsernameLockout(LockoutPolicy{}),
+		WithIPLockout(LockoutPolicy{MaxFailures: 3, Lockout: time.Minute, MaxLockout: time.Hour, ResetAfter: 24 * time.Hour}))
+
+	login := func(remoteAddr, username string) int {
+		request := httptest.NewRequest("POST", "/login", strings.NewReader(`{"username":"`+username+`","password":"password"}`))
+		request.RemoteAddr = remoteAddr
+
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, request)
+
+		return w.Code
 	}
 
-	return fmt.Errorf("expected response `%s`, found `%s`", string(expected), string(response))
+	// Trying a different username every time does not avoid the lockout.
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusUnauthorized, login("192.0.2.1:1234", fmt.Sprintf("username%d", i)))
+	}
+
+	assert.Equal(t, http.StatusTooManyRequests, login("192.0.2.1:5678", "username"))
+	assert.Equal(t, http.StatusUnauthorized, login("192.0.2.2:1234", "username"))
+}
+
+func TestTwoFactor(t *testing.T) {
+	user := getUser(t, "username", "password", 1)
+
+	memoryStore := memory.New()
+
+	err := memoryStore.User().Create(context.Background(), user.Username, user.PasswordHash)
+	assert.NoError(t, err)
+
+	handler := newTestHandler(t, memoryStore, log.New(ioutil.Discard, "", 0), WithTwoFactor([]byte("secret"), "Example"))
+
+	do := func(method, url, token, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, url, strings.NewReader(body))
+		if token != "" {
+			request.Header.Add("Authorization", "Bearer "+token)
+		}
+
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, request)
+
+		return w
+	}
+
+	type loginResponse struct {
+		Token             string `json:"token"`
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		ChallengeToken    string `json:"challenge_token"`
+	}
+
+	login := func() loginResponse {
+		w := do("POST", "/login", "", `{"username":"username","password":"password"}`)
+		if !assert.Equal(t, http.StatusOK, w.Code, "status code") {
+			t.FailNow()
+		}
+
+		var res loginResponse
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+
+		return res
+	}
+
+	token := login().Token
+
+	// Enroll
+	w := do("POST", "/me/2fa/totp", token, "")
+	if !assert.Equal(t, http.StatusCreated, w.Code, "enroll") {
+		t.FailNow()
+	}
+
+	var enrollment struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&enrollment))
+	assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/Example:username?"), enrollment.URI)
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	assert.NoError(t, err)
+
+	// The secret is encrypted at rest.
+	stored, err := memoryStore.TwoFactor().GetTOTP(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(stored.Secret), string(secret))
+
+	// Not enabled until confirmed.
+	assert.False(t, login().TwoFactorRequired)
+
+	step := totp.Step(time.Now())
+
+	w = do("POST", "/me/2fa/totp/confirm", token, `{"code":"`+totp.Code(secret, step-10)+`"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "confirm with a wrong code")
+
+	w = do("POST", "/me/2fa/totp/confirm", token, `{"code":"`+totp.Code(secret, step)+`"}`)
+	if !assert.Equal(t, http.StatusOK, w.Code, "confirm") {
+		t.FailNow()
+	}
+
+	var confirmation struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&confirmation))
+	assert.Len(t, confirmation.RecoveryCodes, 10)
+
+	w = do("POST", "/me/2fa/totp", token, "")
+	assert.Equal(t, http.StatusConflict, w.Code, "enroll again")
+
+	// The password alone does not login anymore.
+	res := login()
+	assert.True(t, res.TwoFactorRequired)
+	assert.Empty(t, res.Token)
+	assert.NotEmpty(t, res.ChallengeToken)
+
+	loginTwoFactor := func(challengeToken, field, code string) *httptest.ResponseRecorder {
+		return do("POST", "/login/2fa", "", `{"challenge_token":"`+challengeToken+`","`+field+`":"`+code+`"}`)
+	}
+
+	w = loginTwoFactor(res.ChallengeToken, "code", totp.Code(secret, step-10))
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "wrong code")
+
+	w = loginTwoFactor(res.ChallengeToken, "code", totp.Code(secret, step))
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "code used to confirm")
+
+	w = loginTwoFactor(res.ChallengeToken, "code", totp.Code(secret, totp.Step(time.Now())+1))
+	if !assert.Equal(t, http.StatusOK, w.Code, "right code") {
+		t.FailNow()
+	}
+
+	var session loginResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&session))
+	assert.Equal(t, http.StatusOK, do("GET", "/me", session.Token, "").Code)
+
+	w = loginTwoFactor(res.ChallengeToken, "code", totp.Code(secret, totp.Step(time.Now())+1))
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "challenge used twice")
+
+	// A recovery code works once, in any case and without the dash.
+	recoveryCode := strings.ToUpper(strings.ReplaceAll(confirmation.RecoveryCodes[0], "-", ""))
+
+	w = loginTwoFactor(login().ChallengeToken, "recovery_code", recoveryCode)
+	assert.Equal(t, http.StatusOK, w.Code, "recovery code")
+
+	w = loginTwoFactor(login().ChallengeToken, "recovery_code", confirmation.RecoveryCodes[0])
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "recovery code used twice")
+
+	w = loginTwoFactor(login().ChallengeToken, "recovery_code", confirmation.RecoveryCodes[1])
+	assert.Equal(t, http.StatusOK, w.Code, "another recovery code")
+
+	// Without the key, the codes of the authenticator app cannot be checked, but the recovery codes still work.
+	keyless := newTestHandler(t, memoryStore, log.New(ioutil.Discard, "", 0))
+
+	doKeyless := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		keyless.ServeHTTP(w, httptest.NewRequest("POST", "/login/2fa", strings.NewReader(body)))
+
+		return w
+	}
+
+	w = doKeyless(`{"challenge_token":"` + login().ChallengeToken + `","code":"` + totp.Code(secret, totp.Step(time.Now())) + `"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "code without the key")
+
+	w = doKeyless(`{"challenge_token":"` + login().ChallengeToken + `","recovery_code":"` + confirmation.RecoveryCodes[2] + `"}`)
+	assert.Equal(t, http.StatusOK, w.Code, "recovery code without the key")
+
+	// Disable
+	w = do("DELETE", "/me/2fa/totp", token, `{"password":"wrong"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code, "disable with a wrong password")
+
+	w = do("DELETE", "/me/2fa/totp", token, `{"password":"password"}`)
+	assert.Equal(t, http.StatusNoContent, w.Code, "disable")
+
+	assert.NotEmpty(t, login().Token)
+}
+
+func TestTwoFactorDisabled(t *testing.T) {
+	memoryStore := memory.New()
+
+	err := memoryStore.User().Create(context.Background(), "username", "hash")
+	assert.NoError(t, err)
+
+	handler := newTestHandler(t, memoryStore, nil)
+
+	addToken(t, memoryStore, 1, "token")
+
+	request := httptest.NewRequest("POST", "/me/2fa/totp", nil)
+	request.Header.Add("Authorization", "Bearer token")
+
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
+
+func TestAPIKeys(t *testing.T) {
+	memoryStore := memory.New()
+
+	for _, username := range []string{"sender", "recipient"} {
+		err := memoryStore.User().Create(context.Background(), username, "hash")
+		assert.NoError(t, err)
+	}
+
+	_, err := memoryStore.Message().Create(context.Background(), store.Message{
+		Content:      "content",
+		SenderID:     1,
+		SentDateTime: time.Now(),
+	}, []int64{2})
+	assert.NoError(t, err)
+
+	addToken(t, memoryStore, 1, "session")
+
+	handler := newTestHandler(t, memoryStore, nil)
+
+	do := func(method, url string, header http.Header, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, url, strings.NewReader(body))
+		for k, v := range header {
+			request.Header[k] = v
+		}
+
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, request)
+
+		return w
+	}
+
+	bearer := func(token string) http.Header {
+		return http.Header{"Authorization": []string{"Bearer " + token}}
+	}
+
+	type apiKey struct {
+		ID     int64    `json:"id"`
+		Name   string   `json:"name"`
+		Prefix string   `json:"prefix"`
+		Scopes []string `json:"scopes"`
+		Key    string   `json:"key"`
+	}
+
+	createAPIKey := func(body string) apiKey {
+		w := do("POST", "/me/api-keys", bearer("session"), body)
+		if !assert.Equal(t, http.StatusCreated, w.Code, w.Body.String()) {
+			t.FailNow()
+		}
+
+		var res apiKey
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+
+		return res
+	}
+
+	w := do("POST", "/me/api-keys", bearer("session"), `{"name":"","scopes":["messages:read","admin"]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, compareJSON([]byte(`{"message":"invalid API key","errors":[{"field":"name","message":"name is empty"},{"field":"scopes","message":"unknown scope \"admin\""}]}`), w.Body.Bytes()))
+
+	w = do("POST", "/me/api-keys", bearer("session"), `{"name":"bot","scopes":["messages:read"],"expire_time":"2000-01-01T00:00:00Z"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "expired")
+
+	reader := createAPIKey(`{"name":"reader","scopes":["messages:read","messages:read"]}`)
+	assert.True(t, strings.HasPrefix(reader.Key, "sk_"))
+	assert.True(t, strings.HasPrefix(reader.Key, reader.Prefix))
+	assert.Equal(t, []string{"messages:read"}, reader.Scopes)
+	assert.NotZero(t, reader.ID)
+
+	writer := createAPIKey(`{"name":"writer","scopes":["messages:write"],"expire_time":"` + time.Now().Add(time.Hour).Format(time.RFC3339) + `"}`)
+
+	// The key is kept hashed.
+	_, err = memoryStore.APIKey().Get(context.Background(), reader.Key)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	// An API key is accepted as a bearer token, or in the X-API-Key header, within its scopes.
+	assert.Equal(t, http.StatusOK, do("GET", "/", bearer(reader.Key), "").Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/1", http.Header{"X-Api-Key": []string{reader.Key}}, "").Code)
+	assert.Equal(t, http.StatusForbidden, do("DELETE", "/1", bearer(reader.Key), "").Code)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/me", bearer(reader.Key), "").Code)
+
+	assert.Equal(t, http.StatusForbidden, do("GET", "/", bearer(writer.Key), "").Code)
+	assert.Equal(t, http.StatusCreated, do("POST", "/", bearer(writer.Key), `{"content":"hello","recipients":[2]}`).Code)
+
+	// The account can only be managed with a session.
+	assert.Equal(t, http.StatusForbidden, do("GET", "/me/api-keys", bearer(reader.Key), "").Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", "/logout", bearer(reader.Key), "").Code)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/sessions", bearer(reader.Key), "").Code)
+
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/", bearer("sk_notexist"), "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/", http.Header{"X-Api-Key": []string{"notexist"}}, "").Code)
+
+	// The last used time is recorded.
+	w = do("GET", "/me/api-keys", bearer("session"), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var list struct {
+		APIKeys []struct {
+			Name       string     `json:"name"`
+			Key        string     `json:"key"`
+			LastUsedAt *time.Time `json:"last_used_at"`
+		} `json:"api_keys"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+	if assert.Len(t, list.APIKeys, 2) {
+		assert.Equal(t, "writer", list.APIKeys[0].Name)
+		assert.Equal(t, "reader", list.APIKeys[1].Name)
+		assert.NotNil(t, list.APIKeys[1].LastUsedAt)
+		assert.Empty(t, list.APIKeys[1].Key, "the key is only shown at creation")
+	}
+
+	// An expired key is rejected.
+	k, err := memoryStore.APIKey().Get(context.Background(), hashToken(writer.Key))
+	assert.NoError(t, err)
+	assert.NoError(t, memoryStore.APIKey().Delete(context.Background(), 1, k.ID))
+
+	past := time.Now().Add(-time.Minute)
+	k.ExpiresAt = &past
+	_, err = memoryStore.APIKey().Create(context.Background(), hashToken(writer.Key), *k, maxAPIKeys)
+	assert.NoError(t, err)
+
+	w = do("POST", "/", bearer(writer.Key), `{"content":"hello","recipients":[2]}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NoError(t, compareJSON([]byte(`{"message":"Expired API key"}`), w.Body.Bytes()))
+
+	// Delete
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/me/api-keys/1000", bearer("session"), "").Code)
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/me/api-keys/"+strconv.FormatInt(reader.ID, 10), bearer("session"), "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/", bearer(reader.Key), "").Code)
+
+	// The writer key is left, so the user can add maxAPIKeys-1 more keys.
+	for i := 1; i < maxAPIKeys; i++ {
+		createAPIKey(`{"name":"bot","scopes":["messages:read"]}`)
+	}
+
+	w = do("POST", "/me/api-keys", bearer("session"), `{"name":"bot","scopes":["messages:read"]}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.NoError(t, compareJSON([]byte(`{"message":"too many API keys, delete one first"}`), w.Body.Bytes()))
+}
+
+func TestOIDC(t *testing.T) {
+	server := oidctest.NewServer("client", "secret")
+	defer server.Close()
+
+	provider, err := oidc.Discover(context.Background(), server.Issuer(), oidc.Config{
+		ClientID:     server.ClientID,
+		ClientSecret: server.ClientSecret,
+		RedirectURL:  "http://api.example/auth/oidc/callback",
+		Scopes:       []string{"email"},
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	memoryStore := memory.New()
+
+	user := getUser(t, "alice@example.com", "password", 1)
+
+	err = memoryStore.User().Create(context.Background(), user.Username, user.PasswordHash)
+	assert.NoError(t, err)
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// login signs in at the provider, and returns the response of the callback.
+	login := func(handler http.Handler, tamper func(query url.Values)) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/auth/oidc/start", nil))
+		if !assert.Equal(t, http.StatusFound, w.Code) {
+			t.FailNow()
+		}
+
+		cookies := w.Result().Cookies()
+
+		resp, err := client.Get(w.Header().Get("Location"))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		resp.Body.Close()
+
+		callback, err := url.Parse(resp.Header.Get("Location"))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		query := callback.Query()
+		if tamper != nil {
+			tamper(query)
+		}
+
+		request := httptest.NewRequest("GET", callback.Path+"?"+query.Encode(), nil)
+		for _, c := range cookies {
+			request.AddCookie(c)
+		}
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+
+		return w
+	}
+
+	handler := newTestHandler(t, memoryStore, nil, WithOIDC(provider, false))
+
+	server.SetIdentity(oidctest.Identity{Subject: "alice", Email: "Alice@example.com", EmailVerified: true})
+
+	// Anyone could have registered the username, so it is not linked until the user verifies the address.
+	w := login(handler, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "unverified username")
+
+	err = memoryStore.User().SetEmail(context.Background(), 1, "alice@example.com")
+	assert.NoError(t, err)
+
+	err = memoryStore.User().VerifyEmail(context.Background(), 1, "alice@example.com", time.Now())
+	assert.NoError(t, err)
+
+	// An existing user is linked by the verified email address.
+	w = login(handler, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var res struct {
+		Token  string `json:"token"`
+		UserID int64  `json:"user_id"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(t, int64(1), res.UserID)
+	assert.NotEmpty(t, res.Token)
+
+	// From then on, the identity is found by its subject, whatever the email address.
+	server.SetIdentity(oidctest.Identity{Subject: "alice", Email: "alice@other.example"})
+	assert.Equal(t, http.StatusOK, login(handler, nil).Code)
+
+	// An unverified email address is not linked.
+	server.SetIdentity(oidctest.Identity{Subject: "mallory", Email: "alice@example.com"})
+	assert.Equal(t, http.StatusForbidden, login(handler, nil).Code)
+
+	// Without auto provisioning, an unknown user is rejected.
+	server.SetIdentity(oidctest.Identity{Subject: "bob", Email: "bob@example.com", EmailVerified: true})
+	assert.Equal(t, http.StatusForbidden, login(handler, nil).Code)
+
+	// The state must match the one of the browser.
+	w = login(handler, func(query url.Values) {
+		query.Set("state", "forged")
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// A callback without a login in progress is rejected.
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/auth/oidc/callback?code=code&state=state", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// With auto provisioning, a user without a password is created.
+	handler = newTestHandler(t, memoryStore, nil, WithOIDC(provider, true))
+
+	w = login(handler, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	bob, err := memoryStore.User().GetByIdentity(context.Background(), server.Issuer(), "bob")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "bob@example.com", bob.Username)
+		assert.Empty(t, bob.PasswordHash)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/login", strings.NewReader(`{"username":"bob@example.com","password":"password"}`)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "no password matches")
+
+	// Disabled
+	handler = newTestHandler(t, memoryStore, nil)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/auth/oidc/start", nil))
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
+
+// Test the authentication checking on all the routes that require authentication.
+func TestRequireAuthenticateRoutes(t *testing.T) {
+	memoryStore := memory.New()
+
+	sender := getUser(t, "sender", "password", 1)
+	recipient := getUser(t, "recipient", "password", 2)
+
+	for _, u := range []*store.User{sender, recipient} {
+		err := memoryStore.User().Create(context.Background(), u.Username, u.PasswordHash)
+		assert.NoError(t, err)
+	}
+
+	// The routes under /1 need an existing message that belongs to the authenticated user.
+	_, err := memoryStore.Message().Create(context.Background(), store.Message{
+		Content:      "content",
+		SenderID:     1,
+		SentDateTime: time.Now(),
+	}, []int64{2})
+	assert.NoError(t, err)
+
+	addToken(t, memoryStore, 1, "exists")
+
+	handler := newTestHandler(t, memoryStore, nil)
+
+	tests := []struct {
+		url    string
+		method string
+	}{
+		{
+			url:    "/",
+			method: "POST",
+		},
+		{
+			url:    "/1",
+			method: "POST",
+		},
+		{
+			url:    "/1",
+			method: "GET",
+		},
+		{
+			url:    "/",
+			method: "GET",
+		},
+		{
+			url:    "/1",
+			method: "DELETE",
+		},
+		{
+			url:    "/sent",
+			method: "GET",
+		},
+		{
+			url:    "/sessions",
+			method: "GET",
+		},
+		{
+			url:    "/me/2fa/totp",
+			method: "POST",
+		},
+		{
+			url:    "/me/2fa/totp/confirm",
+			method: "POST",
+		},
+		{
+			url:    "/me/2fa/totp",
+			method: "DELETE",
+		},
+		{
+			url:    "/me/api-keys",
+			method: "GET",
+		},
+		{
+			url:    "/me/api-keys",
+			method: "POST",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.url, func(t *testing.T) {
+			// Test empty token
+			{
+				request := httptest.NewRequest(tc.method, tc.url, nil)
+
+				w := httptest.NewRecorder()
+
+				handler.ServeHTTP(w, request)
+
+				_, err := ioutil.ReadAll(w.Body)
+				assert.Nil(t, err)
+
+				assert.NotEqual(t, http.StatusNotFound, w.Code, "status code")
+				assert.Equal(t, http.StatusUnauthorized, w.Code, "status code")
+			}
+
+			// Test token user does not exist
+			{
+				request := httptest.NewRequest(tc.method, tc.url, nil)
+				request.Header.Add("Authorization", "Bearer notexists")
+
+				w := httptest.NewRecorder()
+
+				handler.ServeHTTP(w, request)
+
+				_, err := ioutil.ReadAll(w.Body)
+				assert.Nil(t, err)
+
+				assert.NotEqual(t, http.StatusNotFound, w.Code, "status code")
+				assert.Equal(t, http.StatusUnauthorized, w.Code, "status code")
+			}
+
+			// Test success
+			{
+				request := httptest.NewRequest(tc.method, tc.url, nil)
+				request.Header.Add("Authorization", "Bearer exists")
+
+				w := httptest.NewRecorder()
+
+				handler.ServeHTTP(w, request)
+
+				_, err := ioutil.ReadAll(w.Body)
+				assert.Nil(t, err)
+
+				assert.NotEqual(t, http.StatusNotFound, w.Code, "status code")
+				assert.NotEqual(t, http.StatusUnauthorized, w.Code, "status code")
+			}
+		})
+	}
+}
+
+func TestSessions(t *testing.T) {
+	user := getUser(t, "username", "password", 1)
+
+	memoryStore := memory.New()
+
+	err := memoryStore.User().Create(context.Background(), user.Username, user.PasswordHash)
+	assert.NoError(t, err)
+
+	handler := newTestHandler(t, memoryStore, nil)
+
+	login := func(userAgent string) string {
+		body := `{"username":"username","password":"password"}`
+
+		request := httptest.NewRequest("POST", "/login", strings.NewReader(body))
+		request.Header.Add("User-Agent", userAgent)
+
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, request)
+
+		if !assert.Equal(t, http.StatusOK, w.Code, "status code") {
+			t.FailNow()
+		}
+
+		var res struct {
+			Token string `json:"token"`
+		}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+
+		return res.Token
+	}
+
+	do := func(method, url, token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, url, nil)
+		request.Header.Add("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, request)
+
+		return w
+	}
+
+	// Logging in on a second device keeps the first session.
+	laptop := login("laptop")
+	phone := login("phone")
+	tablet := login("tablet")
+
+	assert.Equal(t, http.StatusOK, do("GET", "/me", laptop).Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/me", phone).Code)
+
+	type session struct {
+		UserAgent string `json:"user_agent"`
+		Current   bool   `json:"current"`
+	}
+
+	var res struct {
+		Sessions []session `json:"sessions"`
+	}
+
+	w := do("GET", "/sessions", phone)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+
+	assert.Equal(t, []session{
+		{UserAgent: "tablet"},
+		{UserAgent: "phone", Current: true},
+		{UserAgent: "laptop"},
+	}, res.Sessions)
+
+	// Logout only revokes the current session.
+	assert.Equal(t, http.StatusNoContent, do("POST", "/logout", laptop).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/me", laptop).Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/me", phone).Code)
+
+	// Logout all revokes every session.
+	assert.Equal(t, http.StatusNoContent, do("POST", "/logout/all", phone).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/me", phone).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/me", tablet).Code)
+
+	// A long user agent is cut on a rune boundary, as the limit falls in the middle of an "é".
+	long := login(strings.Repeat("é", 200))
+
+	w = do("GET", "/sessions", long)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+
+	assert.Equal(t, []session{
+		{UserAgent: strings.Repeat("é", 127), Current: true},
+	}, res.Sessions)
+}
+
+func TestRefreshToken(t *testing.T) {
+	user := getUser(t, "username", "password", 1)
+
+	memoryStore := memory.New()
+
+	err := memoryStore.User().Create(context.Background(), user.Username, user.PasswordHash)
+	assert.NoError(t, err)
+
+	handler := newTestHandler(t, memoryStore, log.New(ioutil.Discard, "", 0), WithAccessTokenLifetime(time.Minute))
+
+	type tokens struct {
+		Token             string    `json:"token"`
+		ExpireTime        time.Time `json:"expire_time"`
+		RefreshToken      string    `json:"refresh_token"`
+		RefreshExpireTime time.Time `json:"refresh_expire_time"`
+	}
+
+	post := func(url, body string) (int, *tokens) {
+		request := httptest.NewRequest("POST", url, strings.NewReader(body))
+
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, request)
+
+		var res tokens
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		}
+
+		return w.Code, &res
+	}
+
+	refresh := func(refreshToken string) (int, *tokens) {
+		return post("/token/refresh", fmt.Sprintf(`{"refresh_token":%q}`, refreshToken))
+	}
+
+	me := func(token string) int {
+		request := httptest.NewRequest("GET", "/me", nil)
+		request.Header.Add("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, request)
+
+		return w.Code
+	}
+
+	start := time.Now()
+
+	code, login := post("/login", `{"username":"username","password":"password"}`)
+	if !assert.Equal(t, http.StatusOK, code) {
+		t.FailNow()
+	}
+
+	end := time.Now()
+
+	assert.NotEmpty(t, login.RefreshToken)
+	assertTimeBetween(t, start.Add(time.Minute), end.Add(time.Minute), login.ExpireTime, "expire time")
+	assertTimeBetween(t, start.Add(defaultRefreshTokenLifetime), end.Add(defaultRefreshTokenLifetime), login.RefreshExpireTime, "refresh expire time")
+
+	code, _ = refresh("")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = refresh("not exists")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// Refreshing replaces both tokens.
+
+	code, first := refresh(login.RefreshToken)
+	if !assert.Equal(t, http.StatusOK, code) {
+		t.FailNow()
+	}
+
+	assert.NotEqual(t, login.Token, first.Token)
+	assert.NotEqual(t, login.RefreshToken, first.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, me(login.Token), "old access token")
+	assert.Equal(t, http.StatusOK, me(first.Token))
+
+	code, second := refresh(first.RefreshToken)
+	if !assert.Equal(t, http.StatusOK, code) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, http.StatusOK, me(second.Token))
+
+	// Reusing a refresh token revokes the whole session, including the latest tokens.
+
+	code, _ = refresh(login.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	assert.Equal(t, http.StatusUnauthorized, me(second.Token))
+
+	code, _ = refresh(second.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// Logout also revokes the refresh token.
+
+	_, login = post("/login", `{"username":"username","password":"password"}`)
+
+	request := httptest.NewRequest("POST", "/logout", nil)
+	request.Header.Add("Authorization", "Bearer "+login.Token)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	code, _ = refresh(login.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestRefreshTokenExpired(t *testing.T) {
+	user := getUser(t, "username", "password", 1)
+
+	memoryStore := memory.New()
+
+	err := memoryStore.User().Create(context.Background(), user.Username, user.PasswordHash)
+	assert.NoError(t, err)
+
+	handler := newTestHandler(t, memoryStore, nil, WithAccessTokenLifetime(-time.Second), WithRefreshTokenLifetime(-time.Second))
+
+	request := httptest.NewRequest("POST", "/login", strings.NewReader(`{"username":"username","password":"password"}`))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+
+	var res struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+
+	request = httptest.NewRequest("GET", "/me", nil)
+	request.Header.Add("Authorization", "Bearer "+res.Token)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "expired access token")
+
+	request = httptest.NewRequest("POST", "/token/refresh", strings.NewReader(fmt.Sprintf(`{"refresh_token":%q}`, res.RefreshToken)))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "expired refresh token")
+}
+
+func TestSessionExpiry(t *testing.T) {
+	user := getUser(t, "username", "password", 1)
+
+	memoryStore := memory.New()
+
+	err := memoryStore.User().Create(context.Background(), user.Username, user.PasswordHash)
+	assert.NoError(t, err)
+
+	handler := newTestHandler(t, memoryStore, nil, WithSessionIdleTimeout(time.Hour), WithSessionMaxLifetime(2*time.Hour))
+
+	now := time.Now()
+
+	sessions := []struct {
+		token      string
+		createdAt  time.Time
+		lastUsedAt time.Time
+		wantCode   int
+		wantTouch  bool
+	}{
+		{token: "idle", createdAt: now.Add(-90 * time.Minute), lastUsedAt: now.Add(-61 * time.Minute), wantCode: http.StatusUnauthorized},
+		{token: "too old", createdAt: now.Add(-121 * time.Minute), lastUsedAt: now, wantCode: http.StatusUnauthorized},
+		{token: "active", createdAt: now.Add(-90 * time.Minute), lastUsedAt: now.Add(-30 * time.Minute), wantCode: http.StatusOK, wantTouch: true},
+		{token: "just used", createdAt: now.Add(-90 * time.Minute), lastUsedAt: now.Add(-10 * time.Second), wantCode: http.StatusOK},
+	}
+
+	for _, s := range sessions {
+		err := memoryStore.Token().Create(context.Background(), hashToken(s.token), store.Token{
+			UserID:     1,
+			CreatedAt:  s.createdAt,
+			LastUsedAt: s.lastUsedAt,
+			ExpiresAt:  now.Add(time.Minute),
+		})
+		assert.NoError(t, err)
+	}
+
+	for _, s := range sessions {
+		t.Run(s.token, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "/me", nil)
+			request.Header.Add("Authorization", "Bearer "+s.token)
+
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, request)
+
+			assert.Equal(t, s.wantCode, w.Code)
+
+			session, err := memoryStore.Token().GetUserID(context.Background(), hashToken(s.token))
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			if s.wantTouch {
+				assert.True(t, session.LastUsedAt.After(s.lastUsedAt), "last used time is updated")
+			} else {
+				assert.True(t, session.LastUsedAt.Equal(s.lastUsedAt), "last used time is not updated")
+			}
+		})
+	}
+
+	// The refresh token does not outlive the maximum lifetime of the session.
+
+	start := time.Now()
+
+	request := httptest.NewRequest("POST", "/login", strings.NewReader(`{"username":"username","password":"password"}`))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+
+	end := time.Now()
+
+	var res struct {
+		RefreshToken      string    `json:"refresh_token"`
+		RefreshExpireTime time.Time `json:"refresh_expire_time"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+
+	assertTimeBetween(t, start.Add(2*time.Hour), end.Add(2*time.Hour), res.RefreshExpireTime, "refresh expire time")
+
+	// The expired sessions are not listed.
+
+	request = httptest.NewRequest("GET", "/sessions", nil)
+	request.Header.Add("Authorization", "Bearer active")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+
+	var list struct {
+		Sessions []struct {
+			ID int64 `json:"id"`
+		} `json:"sessions"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+	assert.Len(t, list.Sessions, 3)
+
+	// An idle session cannot be refreshed.
+
+	idleHandler := newTestHandler(t, memoryStore, nil, WithSessionIdleTimeout(time.Nanosecond))
+
+	request = httptest.NewRequest("POST", "/token/refresh", strings.NewReader(fmt.Sprintf(`{"refresh_token":%q}`, res.RefreshToken)))
+	w = httptest.NewRecorder()
+	idleHandler.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestTokenHashedAtRest(t *testing.T) {
+	user := getUser(t, "username", "password", 1)
+
+	memoryStore := memory.New()
+
+	err := memoryStore.User().Create(context.Background(), user.Username, user.PasswordHash)
+	assert.NoError(t, err)
+
+	handler := newTestHandler(t, memoryStore, nil, WithTokenHashSecret([]byte("secret")))
+
+	request := httptest.NewRequest("POST", "/login", strings.NewReader(`{"username":"username","password":"password"}`))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+
+	var res struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+
+	// Only the hashes are kept in the store.
+
+	_, err = memoryStore.Token().GetUserID(context.Background(), res.Token)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	_, err = memoryStore.RefreshToken().Get(context.Background(), res.RefreshToken)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	_, err = memoryStore.Token().GetUserID(context.Background(), handler.hashToken(res.Token))
+	assert.NoError(t, err)
+
+	_, err = memoryStore.RefreshToken().Get(context.Background(), handler.hashToken(res.RefreshToken))
+	assert.NoError(t, err)
+
+	me := func(handler *Handler) int {
+		request := httptest.NewRequest("GET", "/me", nil)
+		request.Header.Add("Authorization", "Bearer "+res.Token)
+
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, request)
+
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, me(handler))
+
+	// The hashes depend on the secret.
+	assert.Equal(t, http.StatusUnauthorized, me(newTestHandler(t, memoryStore, nil, WithTokenHashSecret([]byte("other")))))
+}
+
+func TestSignedTokens(t *testing.T) {
+	user := getUser(t, "username", "password", 1)
+
+	key, err := jwt.NewHMACKey("key1", []byte(strings.Repeat("s", 32)))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	signer, err := jwt.NewSigner(key)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	tests := []struct {
+		name     string
+		denyList jwt.DenyList
+		// wantCode is the status code of a revoked session's access token that is not expired yet.
+		wantCode int
+	}{
+		{name: "without deny list", wantCode: http.StatusOK},
+		{name: "with deny list", denyList: jwt.NewMemoryDenyList(), wantCode: http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			memoryStore := memory.New()
+
+			err := memoryStore.User().Create(context.Background(), user.Username, user.PasswordHash)
+			assert.NoError(t, err)
+
+			opts := []Option{WithTokenSigner(signer)}
+			if tc.denyList != nil {
+				opts = append(opts, WithDenyList(tc.denyList))
+			}
+
+			handler := newTestHandler(t, memoryStore, nil, opts...)
+
+			do := func(method, url, token, body string) *httptest.ResponseRecorder {
+				request := httptest.NewRequest(method, url, strings.NewReader(body))
+				if token != "" {
+					request.Header.Add("Authorization", "Bearer "+token)
+				}
+
+				w := httptest.NewRecorder()
+
+				handler.ServeHTTP(w, request)
+
+				return w
+			}
+
+			var res struct {
+				Token        string `json:"token"`
+				RefreshToken string `json:"refresh_token"`
+			}
+
+			w := do("POST", "/login", "", `{"username":"username","password":"password"}`)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+
+			claims, err := signer.Verify(res.Token, time.Now())
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+
+			assert.Equal(t, int64(1), claims.UserID)
+
+			assert.Equal(t, http.StatusOK, do("GET", "/me", res.Token, "").Code)
+
+			// The access token is verified without the store.
+			assert.NoError(t, memoryStore.Token().Delete(context.Background(), claims.ID))
+			assert.Equal(t, http.StatusOK, do("GET", "/me", res.Token, "").Code)
+
+			// A tampered token is rejected.
+			assert.Equal(t, http.StatusUnauthorized, do("GET", "/me", res.Token+"a", "").Code)
+
+			// Refresh and logout work the same as the opaque token mode.
+			w = do("POST", "/login", "", `{"username":"username","password":"password"}`)
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+
+			w = do("POST", "/token/refresh", "", fmt.Sprintf(`{"refresh_token":%q}`, res.RefreshToken))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+
+			assert.Equal(t, http.StatusNoContent, do("POST", "/logout", res.Token, "").Code)
+
+			w = do("POST", "/token/refresh", "", fmt.Sprintf(`{"refresh_token":%q}`, res.RefreshToken))
+			assert.Equal(t, http.StatusUnauthorized, w.Code, "refresh token of the revoked session")
+
+			assert.Equal(t, tc.wantCode, do("GET", "/me", res.Token, "").Code)
+		})
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	user := getUser(t, "username", "password", 1)
+
+	memoryStore := memory.New()
+
+	err := memoryStore.User().Create(context.Background(), user.Username, user.PasswordHash)
+	assert.NoError(t, err)
+
+	handler := newTestHandler(t, memoryStore, nil)
+
+	do := func(method, url, token, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, url, strings.NewReader(body))
+		if token != "" {
+			request.Header.Add("Authorization", "Bearer "+token)
+		}
+
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, request)
+
+		return w
+	}
+
+	login := func(password string) string {
+		w := do("POST", "/login", "", `{"username":"username","password":"`+password+`"}`)
+		if !assert.Equal(t, http.StatusOK, w.Code, "status code") {
+			t.FailNow()
+		}
+
+		var res struct {
+			Token string `json:"token"`
+		}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+
+		return res.Token
+	}
+
+	laptop := login("password")
+	phone := login("password")
+
+	w := do("POST", "/me/password", laptop, `{"current_password":"wrong","new_password":"new password"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code, "wrong current password")
+
+	w = do("POST", "/me/password", laptop, `{"current_password":"password","new_password":""}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "empty new password")
+
+	w = do("POST", "/me/password", laptop, `{"current_password":"password","new_password":"new password"}`)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// Only the session used to change the password stays logged in.
+	assert.Equal(t, http.StatusOK, do("GET", "/me", laptop, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/me", phone, "").Code)
+
+	w = do("POST", "/login", "", `{"username":"username","password":"password"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "login with the old password")
+
+	login("new password")
+}
+
+type testMailer struct {
+	messages []mail.Message
+}
+
+func (m *testMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func TestPasswordReset(t *testing.T) {
+	user := getUser(t, "username", "password", 1)
+
+	memoryStore := memory.New()
+
+	err := memoryStore.User().Create(context.Background(), user.Username, user.PasswordHash)
+	assert.NoError(t, err)
+
+	mailer := &testMailer{}
+
+	handler := newTestHandler(t, memoryStore, nil, WithMailer(mailer))
+
+	do := func(method, url, token, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, url, strings.NewReader(body))
+		if token != "" {
+			request.Header.Add("Authorization", "Bearer "+token)
+		}
+
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, request)
+
+		return w
+	}
+
+	w := do("POST", "/login", "", `{"username":"username","password":"password"}`)
+	if !assert.Equal(t, http.StatusOK, w.Code, "status code") {
+		t.FailNow()
+	}
+
+	var res struct {
+		Token string `json:"token"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+
+	// An unknown user gets the same response, but no email.
+	w = do("POST", "/password/forgot", "", `{"username":"notexist"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	// So does a user without a verified email address, as the username is not an address.
+	w = do("POST", "/password/forgot", "", `{"username":"username"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	handler.mails.Wait()
+	assert.Empty(t, mailer.messages)
+
+	assert.NoError(t, memoryStore.User().SetEmail(context.Background(), 1, "user@example.com"))
+	assert.NoError(t, memoryStore.User().VerifyEmail(context.Background(), 1, "user@example.com", time.Now()))
+
+	w = do("POST", "/password/forgot", "", `{"username":"Username"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	handler.mails.Wait()
+	if !assert.Len(t, mailer.messages, 1) {
+		t.FailNow()
+	}
+	assert.Equal(t, "user@example.com", mailer.messages[0].To)
+
+	// The token is the only line of the body that has no space.
+	var resetToken string
+	for _, line := range strings.Split(mailer.messages[0].Body, "\n") {
+		if line != "" && !strings.Contains(line, " ") {
+			resetToken = line
+		}
+	}
+	if !assert.NotEmpty(t, resetToken) {
+		t.FailNow()
+	}
+
+	w = do("POST", "/password/reset", "", `{"token":"invalid","password":"new password"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "invalid token")
+
+	w = do("POST", "/password/reset", "", `{"token":"`+resetToken+`","password":"new password"}`)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// The token can be used only once.
+	w = do("POST", "/password/reset", "", `{"token":"`+resetToken+`","password":"another password"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "used token")
+
+	// Every session is revoked.
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/me", res.Token, "").Code)
+
+	w = do("POST", "/login", "", `{"username":"username","password":"password"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "login with the old password")
+
+	w = do("POST", "/login", "", `{"username":"username","password":"new password"}`)
+	assert.Equal(t, http.StatusOK, w.Code, "login with the new password")
+
+	// The password reset is disabled without a mailer.
+	w = httptest.NewRecorder()
+	newTestHandler(t, memoryStore, nil).ServeHTTP(w, httptest.NewRequest("POST", "/password/forgot", strings.NewReader(`{"username":"username"}`)))
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
+
+func TestPasswordResetExpired(t *testing.T) {
+	user := getUser(t, "username", "password", 1)
+
+	memoryStore := memory.New()
+
+	err := memoryStore.User().Create(context.Background(), user.Username, user.PasswordHash)
+	assert.NoError(t, err)
+
+	handler := newTestHandler(t, memoryStore, nil, WithMailer(&testMailer{}))
+
+	now := time.Now()
+
+	err = memoryStore.PasswordReset().Create(context.Background(), hashToken("reset"), store.PasswordResetToken{
+		UserID:    1,
+		CreatedAt: now.Add(-2 * time.Hour),
+		ExpiresAt: now.Add(-time.Hour),
+	})
+	assert.NoError(t, err)
+
+	request := httptest.NewRequest("POST", "/password/reset", strings.NewReader(`{"token":"reset","password":"new password"}`))
+
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, compareJSON([]byte(`{"message":"Expired reset token"}`), w.Body.Bytes()))
+}
+
+func TestEmailVerification(t *testing.T) {
+	memoryStore := memory.New()
+
+	mailer := &testMailer{}
+
+	handler := newTestHandler(t, memoryStore, nil, WithMailer(mailer), WithEmailVerification(EmailVerificationForLogin),
+		WithBaseURL("https://api.example/"))
+
+	do := func(method, url, token, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, url, strings.NewReader(body))
+		if token != "" {
+			request.Header.Add("Authorization", "Bearer "+token)
+		}
+
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, request)
+
+		return w
+	}
+
+	// The link is the only line of the body that has no space.
+	link := func(msg mail.Message) string {
+		for _, line := range strings.Split(msg.Body, "\n") {
+			if line != "" && !strings.Contains(line, " ") {
+				return strings.TrimPrefix(line, "https://api.example")
+			}
+		}
+
+		t.FailNow()
+		return ""
+	}
+
+	// The email address is required when it must be verified.
+	w := do("POST", "/register", "", `{"username":"bob","password":"correct horse battery"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, compareJSON([]byte(`{"message":"invalid email","errors":[{"field":"email","message":"email is empty"}]}`), w.Body.Bytes()))
+
+	w = do("POST", "/register", "", `{"username":"bob","password":"correct horse battery","email":"Bob <bob@example.com>"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do("POST", "/register", "", `{"username":"bob","password":"correct horse battery","email":" Bob@Example.com "}`)
+	if !assert.Equal(t, http.StatusCreated, w.Code, w.Body.String()) || !assert.Len(t, mailer.messages, 1) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, "bob@example.com", mailer.messages[0].To)
+	assert.True(t, strings.HasPrefix(link(mailer.messages[0]), "/verify?token="))
+
+	assert.Equal(t, http.StatusUnauthorized, do("POST", "/login", "", `{"username":"bob","password":"wrong"}`).Code)
+
+	w = do("POST", "/login", "", `{"username":"bob","password":"correct horse battery"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.NoError(t, compareJSON([]byte(`{"message":"email address is not verified"}`), w.Body.Bytes()))
+
+	// The login has not succeeded, so the failed logins are kept.
+	_, err := memoryStore.LoginFailure().Get(context.Background(), "username:bob")
+	assert.NoError(t, err)
+
+	// Another token can be sent, and an unknown user gets the same response but no email.
+	assert.Equal(t, http.StatusAccepted, do("POST", "/verify/resend", "", `{"username":"notexist"}`).Code)
+	assert.Equal(t, http.StatusAccepted, do("POST", "/verify/resend", "", `{"username":"Bob"}`).Code)
+
+	handler.mails.Wait()
+	if !assert.Len(t, mailer.messages, 2) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/verify?token=invalid", "", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/verify", "", "").Code)
+
+	assert.Equal(t, http.StatusNoContent, do("GET", link(mailer.messages[0]), "", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("GET", link(mailer.messages[0]), "", "").Code, "token used twice")
+
+	// Once verified, no more token is sent.
+	assert.Equal(t, http.StatusAccepted, do("POST", "/verify/resend", "", `{"username":"bob"}`).Code)
+
+	handler.mails.Wait()
+	assert.Len(t, mailer.messages, 2)
+
+	w = do("POST", "/login", "", `{"username":"bob","password":"correct horse battery"}`)
+	if !assert.Equal(t, http.StatusOK, w.Code) {
+		t.FailNow()
+	}
+
+	_, err = memoryStore.LoginFailure().Get(context.Background(), "username:bob")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	var res struct {
+		Token string `json:"token"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+
+	w = do("GET", "/me", res.Token, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var me struct {
+		Email           string     `json:"email"`
+		EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&me))
+	assert.Equal(t, "bob@example.com", me.Email)
+	assert.NotNil(t, me.EmailVerifiedAt)
+
+	// A token sent to an old address cannot verify a new one.
+	user, err := memoryStore.User().GetByUsername(context.Background(), "bob")
+	assert.NoError(t, err)
+	assert.NoError(t, memoryStore.User().SetEmail(context.Background(), user.ID, "new@example.com"))
+
+	assert.Equal(t, http.StatusBadRequest, do("GET", link(mailer.messages[1]), "", "").Code)
+
+	// Only sending messages requires a verified email address.
+	handler = newTestHandler(t, memoryStore, nil, WithMailer(mailer), WithEmailVerification(EmailVerificationForSending))
+
+	w = do("POST", "/login", "", `{"username":"bob","password":"correct horse battery"}`)
+	if !assert.Equal(t, http.StatusOK, w.Code) {
+		t.FailNow()
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+
+	w = do("POST", "/", res.Token, `{"content":"hello","recipients":[1]}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	assert.Equal(t, http.StatusOK, do("GET", "/", res.Token, "").Code)
+
+	// The email address is optional otherwise.
+	handler = newTestHandler(t, memoryStore, nil, WithMailer(mailer))
+
+	assert.Equal(t, http.StatusCreated, do("POST", "/register", "", `{"username":"carol","password":"correct horse battery"}`).Code)
+	assert.Equal(t, http.StatusCreated, do("POST", "/", res.Token, `{"content":"hello","recipients":[1]}`).Code)
+}
+
+func TestGetMessages(t *testing.T) {
+	messages := []*store.Message{{
+		ID:              1,
+		Content:         "content",
+		Sender:          "sender",
+		SentDateTime:    time.Now(),
+		UpdatedDateTime: time.Now(),
+	}}
+
+	mockStore := &mock.Store{
+		MessageStore: &mock.MessageStore{
+			OnGetPage: func(ctx context.Context, userID int64, after *store.Cursor, limit int) ([]*store.Message, error) {
+				if userID == 2 {
+					return messages, nil
+				}
+				return nil, nil
+			},
+		},
+		TokenStore: &mock.TokenStore{
+			OnCreate: nil,
+			OnGetUserID: func(ctx context.Context, token string) (*store.Token, error) {
+				if token == hashToken("empty") {
+					return &store.Token{
+						UserID:     1,
+						CreatedAt:  time.Now(),
+						LastUsedAt: time.Now(),
+						ExpiresAt:  time.Now().Add(time.Hour),
+					}, nil
+				}
+
+				if token == hashToken("not empty") {
+					return &store.Token{
+						UserID:     2,
+						CreatedAt:  time.Now(),
+						LastUsedAt: time.Now(),
+						ExpiresAt:  time.Now().Add(time.Hour),
+					}, nil
+				}
+
+				return nil, store.ErrNotFound
+			},
+			OnTouch: func(ctx context.Context, token string, lastUsedAt time.Time) error {
+				return nil
+			},
+		},
+	}
+
+	type res struct {
+		Messages []*store.Message `json:"messages"`
+	}
+
+	handler := newTestHandler(t, mockStore, nil)
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+		response interface{}
+	}{
+		{
+			name:     "empty",
+			token:    "empty",
+			wantCode: http.StatusOK,
+			response: res{
+				Messages: nil,
+			},
+		},
+		{
+			name:     "not empty",
+			token:    "not empty",
+			wantCode: http.StatusOK,
+			response: res{
+				Messages: messages,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "/", nil)
+			request.Header.Add("Content-Type", "application/json")
+			if tc.token != "" {
+				request.Header.Add("Authorization", "Bearer "+tc.token)
+			}
+
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, request)
+
+			actualJSON, err := ioutil.ReadAll(w.Body)
+			assert.Nil(t, err)
+
+			assert.Equal(t, tc.wantCode, w.Code, "status code")
+
+			expectedJSON, err := json.Marshal(tc.response)
+			assert.NoError(t, err)
+
+			assert.NoError(t, compareJSON(expectedJSON, actualJSON))
+		})
+	}
+}
+
+func TestGetMessagesPagination(t *testing.T) {
+	memoryStore := memory.New()
+
+	for _, u := range []*store.User{getUser(t, "sender", "password", 1), getUser(t, "recipient", "password", 2)} {
+		err := memoryStore.User().Create(context.Background(), u.Username, u.PasswordHash)
+		assert.NoError(t, err)
+	}
+
+	base := time.Now()
+	for i := 0; i < 5; i++ {
+		_, err := memoryStore.Message().Create(context.Background(), store.Message{
+			Content:      fmt.Sprintf("message %d", i),
+			SenderID:     1,
+			SentDateTime: base.Add(time.Duration(i) * time.Second),
+		}, []int64{2})
+		assert.NoError(t, err)
+	}
+
+	addToken(t, memoryStore, 2, "token")
+
+	handler := newTestHandler(t, memoryStore, nil)
+
+	type res struct {
+		Messages   []*store.Message `json:"messages"`
+		NextCursor string           `json:"next_cursor"`
+	}
+
+	get := func(query string) (int, res) {
+		request := httptest.NewRequest("GET", "/"+query, nil)
+		request.Header.Add("Authorization", "Bearer token")
+
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, request)
+
+		var response res
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		}
+
+		return w.Code, response
+	}
+
+	var contents []string
+
+	code, response := get("?limit=2")
+	for ; code == http.StatusOK; code, response = get("?limit=2&cursor=" + response.NextCursor) {
+		for _, msg := range response.Messages {
+			contents = append(contents, msg.Content)
+		}
+
+		if response.NextCursor == "" {
+			break
+		}
+	}
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"message 4", "message 3", "message 2", "message 1", "message 0"}, contents)
+
+	for _, query := range []string{"?limit=0", "?limit=101", "?limit=abc", "?cursor=invalid"} {
+		code, _ := get(query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+	}
+}
+
+func TestGetSentMessages(t *testing.T) {
+	memoryStore := memory.New()
+
+	for _, u := range []*store.User{getUser(t, "sender", "password", 1), getUser(t, "recipient1", "password", 2), getUser(t, "recipient2", "password", 3)} {
+		err := memoryStore.User().Create(context.Background(), u.Username, u.PasswordHash)
+		assert.NoError(t, err)
+	}
+
+	sentAt := time.Date(2020, 2, 19, 14, 18, 18, 0, time.UTC)
+
+	_, err := memoryStore.Message().Create(context.Background(), store.Message{
+		Content:      "content",
+		SenderID:     1,
+		SentDateTime: sentAt,
+	}, []int64{3, 2})
+	assert.NoError(t, err)
+
+	// Received by the sender, not part of the sent messages.
+	_, err = memoryStore.Message().Create(context.Background(), store.Message{
+		Content:      "reply",
+		SenderID:     2,
+		SentDateTime: sentAt,
+	}, []int64{1})
+	assert.NoError(t, err)
+
+	addToken(t, memoryStore, 1, "token")
+
+	handler := newTestHandler(t, memoryStore, nil)
+
+	request := httptest.NewRequest("GET", "/sent", nil)
+	request.Header.Add("Authorization", "Bearer token")
+
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, request)
+
+	actualJSON, err := ioutil.ReadAll(w.Body)
+	assert.Nil(t, err)
+
+	assert.Equal(t, http.StatusOK, w.Code, "status code")
+
+	expectedJSON := `{"messages":[{"id":1,"content":"content","sender":"sender","sent_at":"2020-02-19T14:18:18Z","updated_at":"2020-02-19T14:18:18Z","recipients":[2,3]}]}`
+
+	assert.NoError(t, compareJSON([]byte(expectedJSON), actualJSON))
+}
+
+func TestGetMessage(t *testing.T) {
+	memoryStore := memory.New()
+
+	for _, u := range []*store.User{getUser(t, "sender", "password", 1), getUser(t, "recipient", "password", 2), getUser(t, "other", "password", 3)} {
+		err := memoryStore.User().Create(context.Background(), u.Username, u.PasswordHash)
+		assert.NoError(t, err)
+	}
+
+	sentAt := time.Date(2020, 2, 19, 14, 18, 18, 0, time.UTC)
+
+	_, err := memoryStore.Message().Create(context.Background(), store.Message{
+		Content:      "content",
+		SenderID:     1,
+		SentDateTime: sentAt,
+	}, []int64{2})
+	assert.NoError(t, err)
+
+	for i, token := range []string{"sender", "recipient", "other"} {
+		addToken(t, memoryStore, int64(i+1), token)
+	}
+
+	handler := newTestHandler(t, memoryStore, nil)
+
+	expectedJSON := `{"id":1,"content":"content","sender":"sender","sent_at":"2020-02-19T14:18:18Z","updated_at":"2020-02-19T14:18:18Z","recipients":[2]}`
+
+	tests := []struct {
+		name     string
+		token    string
+		url      string
+		wantCode int
+	}{
+		{name: "sender", token: "sender", url: "/1", wantCode: http.StatusOK},
+		{name: "recipient", token: "recipient", url: "/1", wantCode: http.StatusOK},
+		{name: "not recipient", token: "other", url: "/1", wantCode: http.StatusForbidden},
+		{name: "not exists", token: "sender", url: "/2", wantCode: http.StatusNotFound},
+		{name: "invalid id", token: "sender", url: "/abc", wantCode: http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", tc.url, nil)
+			request.Header.Add("Authorization", "Bearer "+tc.token)
+
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, request)
+
+			actualJSON, err := ioutil.ReadAll(w.Body)
+			assert.Nil(t, err)
+
+			assert.Equal(t, tc.wantCode, w.Code, "status code")
+
+			if tc.wantCode == http.StatusOK {
+				assert.NoError(t, compareJSON([]byte(expectedJSON), actualJSON))
+			}
+		})
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	memoryStore := memory.New()
+
+	for _, u := range []*store.User{getUser(t, "sender", "password", 1), getUser(t, "recipient", "password", 2), getUser(t, "other", "password", 3)} {
+		err := memoryStore.User().Create(context.Background(), u.Username, u.PasswordHash)
+		assert.NoError(t, err)
+	}
+
+	for i, token := range []string{"sender", "recipient", "other"} {
+		addToken(t, memoryStore, int64(i+1), token)
+	}
+
+	handler := newTestHandler(t, memoryStore, nil)
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	dial := func(token string) *websocket.Conn {
+		header := http.Header{}
+		header.Set("Authorization", "Bearer "+token)
+
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, header)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		return conn
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if assert.Error(t, err) && assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "no token")
+	}
+
+	recipient := dial("recipient")
+	defer recipient.Close()
+
+	other := dial("other")
+	defer other.Close()
+
+	send := func(method, url, body string) {
+		request := httptest.NewRequest(method, url, strings.NewReader(body))
+		request.Header.Add("Authorization", "Bearer sender")
+
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, request)
+
+		assert.Less(t, w.Code, 300, "%s %s: %s", method, url, w.Body.String())
+	}
+
+	type event struct {
+		Type    string `json:"type"`
+		Message struct {
+			ID      int64  `json:"id"`
+			Content string `json:"content"`
+			Sender  string `json:"sender"`
+		} `json:"message"`
+	}
+
+	readEvent := func(conn *websocket.Conn) event {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		var e event
+		assert.NoError(t, conn.ReadJSON(&e))
+
+		return e
+	}
+
+	send("POST", "/", `{"content":"hello","recipients":[2]}`)
+
+	e := readEvent(recipient)
+	assert.Equal(t, "message.created", e.Type)
+	assert.Equal(t, int64(1), e.Message.ID)
+	assert.Equal(t, "hello", e.Message.Content)
+	assert.Equal(t, "sender", e.Message.Sender)
+
+	send("POST", "/1", `{"content":"hello again","recipients":[2,3]}`)
+
+	e = readEvent(recipient)
+	assert.Equal(t, "message.updated", e.Type)
+	assert.Equal(t, "hello again", e.Message.Content)
+
+	// The message is new to an added recipient, so the other user did not miss the first event.
+	e = readEvent(other)
+	assert.Equal(t, "message.created", e.Type)
+	assert.Equal(t, int64(1), e.Message.ID)
+
+	send("POST", "/1", `{"content":"hello again","recipients":[2]}`)
+
+	e = readEvent(recipient)
+	assert.Equal(t, "message.updated", e.Type)
+
+	e = readEvent(other)
+	assert.Equal(t, "message.deleted", e.Type, "removed recipient")
+
+	send("DELETE", "/1", "")
+
+	e = readEvent(recipient)
+	assert.Equal(t, "message.deleted", e.Type)
+	assert.Equal(t, int64(1), e.Message.ID)
+
+	// The server closes the connections when it shuts down.
+	handler.Close()
+
+	_ = recipient.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = recipient.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "%v", err)
+}
+
+func TestWebSocketSlowClient(t *testing.T) {
+	hub := newWSHub()
+
+	c := &wsClient{userID: 1, send: make(chan []byte, 1)}
+	hub.register(c)
+
+	hub.publish([]int64{1}, []byte("first"))
+	hub.publish([]int64{2}, []byte("not for the client"))
+
+	// The buffer is full, so the client is dropped rather than blocking the publisher.
+	hub.publish([]int64{1}, []byte("second"))
+
+	data, ok := <-c.send
+	assert.True(t, ok)
+	assert.Equal(t, "first", string(data))
+
+	_, ok = <-c.send
+	assert.False(t, ok, "send is closed")
+
+	hub.publish([]int64{1}, []byte("third"))
+	hub.unregister(c)
+}
+
+func TestEventsAcrossServers(t *testing.T) {
+	memoryStore := memory.New()
+
+	for _, u := range []*store.User{getUser(t, "sender", "password", 1), getUser(t, "recipient", "password", 2)} {
+		err := memoryStore.User().Create(context.Background(), u.Username, u.PasswordHash)
+		assert.NoError(t, err)
+	}
+
+	for i, token := range []string{"sender", "recipient"} {
+		addToken(t, memoryStore, int64(i+1), token)
+	}
+
+	// Two servers sharing the store and the events.
+	bus := memoryevents.New()
+
+	handler1 := newTestHandler(t, memoryStore, nil, WithEvents(bus, bus))
+
+	handler2 := newTestHandler(t, memoryStore, nil, WithEvents(bus, bus))
+
+	server := httptest.NewServer(handler2)
+	defer server.Close()
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer recipient")
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", header)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+
+	request := httptest.NewRequest("POST", "/", strings.NewReader(`{"content":"hello","recipients":[2]}`))
+	request.Header.Add("Authorization", "Bearer sender")
+
+	w := httptest.NewRecorder()
+
+	handler1.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var e struct {
+		Type string `json:"type"`
+	}
+
+	assert.NoError(t, conn.ReadJSON(&e))
+	assert.Equal(t, "message.created", e.Type)
+}
+
+func TestOutboxEvents(t *testing.T) {
+	memoryStore := memory.New()
+
+	for _, u := range []*store.User{getUser(t, "sender", "password", 1), getUser(t, "recipient", "password", 2)} {
+		err := memoryStore.User().Create(context.Background(), u.Username, u.PasswordHash)
+		assert.NoError(t, err)
+	}
+
+	for i, token := range []string{"sender", "recipient"} {
+		addToken(t, memoryStore, int64(i+1), token)
+	}
+
+	bus := memoryevents.New()
+
+	handler := newTestHandler(t, memoryStore, nil, WithOutboxEvents(bus))
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer recipient")
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", header)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+
+	request := httptest.NewRequest("POST", "/", strings.NewReader(`{"content":"hello","recipients":[2]}`))
+	request.Header.Add("Authorization", "Bearer sender")
+
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, request)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// The event comes from the outbox, rather than from the handler.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay := outbox.NewRelay(memoryStore.Outbox(), outbox.NewEventsSink(bus), log.New(ioutil.Discard, "", 0),
+		10*time.Millisecond, time.Second, time.Hour)
+
+	go relay.Run(ctx)
+
+	var e struct {
+		Type    string `json:"type"`
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	assert.NoError(t, conn.ReadJSON(&e))
+	assert.Equal(t, "message.created", e.Type)
+	assert.Equal(t, "hello", e.Message.Content)
+
+	// Only once.
+	_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+
+	assert.Error(t, conn.ReadJSON(&e))
+}
+
+func TestEventStream(t *testing.T) {
+	memoryStore := memory.New()
+
+	for _, u := range []*store.User{getUser(t, "sender", "password", 1), getUser(t, "recipient", "password", 2)} {
+		err := memoryStore.User().Create(context.Background(), u.Username, u.PasswordHash)
+		assert.NoError(t, err)
+	}
+
+	for i, token := range []string{"sender", "recipient"} {
+		addToken(t, memoryStore, int64(i+1), token)
+	}
+
+	handler := newTestHandler(t, memoryStore, nil)
+
+	// The stream must outlive the timeouts of the server.
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.ReadTimeout = 100 * time.Millisecond
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	type sse struct {
+		id, event, data string
+	}
+
+	connect := func(query, lastEventID string) (*http.Response, func() sse) {
+		request, err := http.NewRequest("GET", server.URL+"/events"+query, nil)
+		assert.NoError(t, err)
+
+		if lastEventID != "" {
+			request.Header.Set("Last-Event-ID", lastEventID)
+		}
+
+		resp, err := http.DefaultClient.Do(request)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		reader := bufio.NewReader(resp.Body)
+
+		next := func() sse {
+			var e sse
+
+			for {
+				line, err := reader.ReadString('\n')
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+
+				line = strings.TrimSuffix(line, "\n")
+				if line == "" {
+					return e
+				}
+
+				field := strings.SplitN(line, ": ", 2)
+				switch field[0] {
+				case "id":
+					e.id = field[1]
+				case "event":
+					e.event = field[1]
+				case "data":
+					e.data = field[1]
+				}
+			}
+		}
+
+		return resp, next
+	}
+
+	send := func(method, url, body string) {
+		request := httptest.NewRequest(method, url, strings.NewReader(body))
+		request.Header.Add("Authorization", "Bearer sender")
+
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, request)
+
+		assert.Less(t, w.Code, 300, "%s %s: %s", method, url, w.Body.String())
+	}
+
+	resp, _ := connect("", "")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "no token")
+
+	resp, next := connect("?access_token=recipient", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	time.Sleep(200 * time.Millisecond)
+
+	send("POST", "/", `{"content":"hello","recipients":[2]}`)
+
+	created := next()
+	assert.Equal(t, "message.created", created.event)
+	assert.Contains(t, created.data, `"content":"hello"`)
+
+	resp.Body.Close()
+
+	// The client has missed an update while reconnecting.
+	send("POST", "/1", `{"content":"hello again","recipients":[2]}`)
+
+	resp, next = connect("?access_token=recipient", created.id)
+
+	updated := next()
+	assert.Equal(t, "message.updated", updated.event)
+	assert.Contains(t, updated.data, `"content":"hello again"`)
+
+	resp.Body.Close()
+
+	// An ID older than the log, e.g. from before a restart.
+	resp, next = connect("?access_token=recipient", "1")
+
+	reset := next()
+	assert.Equal(t, "reset", reset.event)
+	assert.Equal(t, updated.id, reset.id)
+
+	// The server ends the streams when it shuts down.
+	handler.Close()
+
+	_, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	resp.Body.Close()
+}
+
+func TestEventLog(t *testing.T) {
+	l := newEventLog(2)
+	start := l.last()
+
+	l.append("message.created", []byte("1"), []int64{1})
+	l.append("message.created", []byte("2"), []int64{2})
+
+	events, lastID, complete, _ := l.since(1, start)
+	assert.True(t, complete)
+	assert.Equal(t, start+2, lastID)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "1", string(events[0].data))
+	}
+
+	l.append("message.created", []byte("3"), []int64{1, 2})
+
+	// The first event is gone.
+	_, lastID, complete, _ = l.since(1, start)
+	assert.False(t, complete)
+	assert.Equal(t, start+3, lastID)
+
+	events, _, complete, _ = l.since(1, start+1)
+	assert.True(t, complete)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "3", string(events[0].data))
+	}
+
+	_, _, complete, _ = l.since(1, start+4)
+	assert.False(t, complete, "ID from the future")
+}
+
+func compareJSON(expected []byte, response []byte) error {
+	if bytes.Equal(bytes.TrimSpace(response), expected) {
+		return nil
+	}
+
+	return fmt.Errorf("expected response `%s`, found `%s`", string(expected), string(response))
+}
+
+// assertRetryAfter checks the Retry-After header of a lockout of d. The lockout has started some time before the
+// response, which can be seconds on a slow machine, e.g. with the race detector.
+func assertRetryAfter(t *testing.T, d time.Duration, w *httptest.ResponseRecorder) {
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.LessOrEqual(t, retryAfter, int(d.Seconds()))
+	assert.Greater(t, retryAfter, int(d.Seconds())-10)
+}
+
+// assertTimeBetween checks a time computed by a request from its own clock, the request being made between start and end.
+func assertTimeBetween(t *testing.T, start, end, actual time.Time, msgAndArgs ...interface{}) {
+	assert.False(t, actual.Before(start), msgAndArgs...)
+	assert.False(t, actual.After(end), msgAndArgs...)
+}
+
+// hashToken returns the token as kept in the store by a Handler without a token hash secret.
+func hashToken(token string) string {
+	return (&Handler{}).hashToken(token)
+}
+
+func addToken(t *testing.T, s store.Store, userID int64, token string) {
+	now := time.Now()
+
+	err := s.Token().Create(context.Background(), hashToken(token), store.Token{
+		UserID:     userID,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(time.Hour),
+	})
+	assert.NoError(t, err)
+}
+
+// newTestHandler returns a handler that is closed at the end of the test, which ends its subscription to the events.
+func newTestHandler(t *testing.T, s store.Store, logger *log.Logger, opts ...Option) *Handler {
+	h := NewHandler(s, logger, opts...)
+	t.Cleanup(h.Close)
+
+	return h
 }
 
 func getUser(t *testing.T, username, password string, id int) *store.User {
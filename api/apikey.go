@@ -0,0 +1,278 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+)
+
+const (
+	// apiKeyPrefix tells the API keys apart from the access tokens, which are base64 without underscores, or JWTs.
+	apiKeyPrefix = "sk_"
+
+	// apiKeyDisplayLength is how much of the key is kept in clear to tell the keys apart.
+	apiKeyDisplayLength = len(apiKeyPrefix) + 6
+
+	maxAPIKeys          = 20
+	maxAPIKeyNameLength = 100
+
+	// apiKeyHeader is the header of the API key, which can also be given as an Authorization Bearer header.
+	apiKeyHeader = "X-API-Key"
+
+	// apiKeyContextKey is the context key of the API key used by the request, which is absent with a session.
+	apiKeyContextKey = "api_key"
+)
+
+const (
+	scopeMessagesRead  = "messages:read"
+	scopeMessagesWrite = "messages:write"
+	scopeProfileRead   = "profile:read"
+)
+
+// apiKeyScopes are the scopes an API key can have. The account itself, e.g. the password, the sessions and the API keys,
+// can only be managed with a session.
+var apiKeyScopes = []string{scopeMessagesRead, scopeMessagesWrite, scopeProfileRead}
+
+func newAPIKey() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b[:]), nil
+}
+
+// verifyAPIKey returns the API key, which is a credential of its own, not a session.
+func (h *Handler) verifyAPIKey(ctx context.Context, key string, now time.Time) (*store.APIKey, error) {
+	k, err := h.store.APIKey().Get(ctx, h.hashToken(key))
+	if err == store.ErrNotFound {
+		return nil, errInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	if k.ExpiresAt != nil && now.After(*k.ExpiresAt) {
+		return nil, errExpiredToken
+	}
+
+	// Same as the sessions, the last used time is not written on every request.
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= lastUsedInterval {
+		// Failing to record the last used time should not fail the request.
+		if err := h.store.APIKey().Touch(ctx, k.ID, now); err != nil {
+			h.logger.Printf("ERROR: %v", err)
+		}
+	}
+
+	return k, nil
+}
+
+// requireScope rejects the API keys without the scope. The sessions can do everything.
+func (h *Handler) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		f := func(w http.ResponseWriter, r *http.Request) {
+			if k, ok := r.Context().Value(apiKeyContextKey).(*store.APIKey); ok && !containsString(k.Scopes, scope) {
+				renderError(w, http.StatusForbidden, "API key does not have the scope "+scope)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(f)
+	}
+}
+
+// requireSession rejects the API keys. The routes behind it can use the token_id of the session.
+func (h *Handler) requireSession(next http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(apiKeyContextKey).(*store.APIKey); ok {
+			renderError(w, http.StatusForbidden, "not permitted with an API key")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(f)
+}
+
+type apiKeyResponse struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpireTime *time.Time `json:"expire_time"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func newAPIKeyResponse(k *store.APIKey) apiKeyResponse {
+	scopes := k.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	return apiKeyResponse{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     scopes,
+		CreatedAt:  k.CreatedAt,
+		ExpireTime: k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+	}
+}
+
+// createAPIKey returns the new API key. Only its hash is kept, so the key is shown only this time.
+func (h *Handler) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Name       string     `json:"name"`
+		Scopes     []string   `json:"scopes"`
+		ExpireTime *time.Time `json:"expire_time"`
+	}
+
+	type response struct {
+		apiKeyResponse
+		Key string `json:"key"`
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := time.Now()
+
+	var errs []fieldError
+
+	if req.Name == "" {
+		errs = append(errs, fieldError{Field: "name", Message: "name is empty"})
+	} else if utf8.RuneCountInString(req.Name) > maxAPIKeyNameLength {
+		errs = append(errs, fieldError{Field: "name", Message: "name must be at most " + strconv.Itoa(maxAPIKeyNameLength) + " characters"})
+	}
+
+	if len(req.Scopes) == 0 {
+		errs = append(errs, fieldError{Field: "scopes", Message: "scopes is empty"})
+	}
+
+	var scopes []string
+	for _, scope := range req.Scopes {
+		if !containsString(apiKeyScopes, scope) {
+			errs = append(errs, fieldError{Field: "scopes", Message: "unknown scope " + strconv.Quote(scope)})
+		} else if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	if req.ExpireTime != nil && !req.ExpireTime.After(now) {
+		errs = append(errs, fieldError{Field: "expire_time", Message: "expire_time is in the past"})
+	}
+
+	if len(errs) > 0 {
+		renderFieldErrors(w, http.StatusBadRequest, "invalid API key", errs)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int64)
+
+	key, err := newAPIKey()
+	if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "create api key"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	k := store.APIKey{
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    key[:apiKeyDisplayLength],
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: req.ExpireTime,
+	}
+
+	k.ID, err = h.store.APIKey().Create(r.Context(), h.hashToken(key), k, maxAPIKeys)
+	if err == store.ErrLimit {
+		renderError(w, http.StatusConflict, "too many API keys, delete one first")
+		return
+	} else if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "create api key"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	render(w, http.StatusCreated, response{
+		apiKeyResponse: newAPIKeyResponse(&k),
+		Key:            key,
+	})
+}
+
+func (h *Handler) getAPIKeys() http.HandlerFunc {
+	type response struct {
+		APIKeys []apiKeyResponse `json:"api_keys"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("user_id").(int64)
+
+		keys, err := h.store.APIKey().List(r.Context(), userID)
+		if err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "get api keys"))
+
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		res := response{APIKeys: []apiKeyResponse{}}
+		for _, k := range keys {
+			res.APIKeys = append(res.APIKeys, newAPIKeyResponse(k))
+		}
+
+		render(w, http.StatusOK, &res)
+	}
+}
+
+func (h *Handler) deleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int64)
+
+	err = h.store.APIKey().Delete(r.Context(), userID, id)
+	if err == store.ErrNotFound {
+		renderError(w, http.StatusNotFound, "API key not found")
+		return
+	} else if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "delete api key"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
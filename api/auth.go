@@ -1,20 +1,25 @@
 package api
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/json"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// maxUserAgentLength is the size of the tokens.user_agent column.
+const maxUserAgentLength = 255
+
 func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
 	type request struct {
 		Username string `json:"username"`
@@ -39,57 +44,334 @@ func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
 
 	username := strings.ToLower(strings.TrimSpace(req.Username))
 
+	now := time.Now()
+
+	lockouts, err := h.getLockouts(r, usernameLockoutKey(username), h.usernameLockout)
+	if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "login"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d := retryAfter(lockouts, now)
+	if d == 0 {
+		d, err = h.reserveLogin(r.Context(), lockouts, now)
+		if err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "login"))
+
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	if d > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+
+		renderError(w, http.StatusTooManyRequests, "too many failed logins, try again later")
+		return
+	}
+
 	user, _ := h.store.User().GetByUsername(r.Context(), username)
 	if user == nil {
+		h.addLoginFailure(lockouts)
+
 		renderError(w, http.StatusUnauthorized, "invalid username or password")
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+	ok, err := h.checkPassword(user, req.Password)
+	if err != nil {
+		h.releaseLogin(r.Context(), lockouts, now)
+
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "login"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !ok {
+		h.addLoginFailure(lockouts)
+
 		renderError(w, http.StatusUnauthorized, "invalid username or password")
 		return
 	}
 
-	// Generate token by combining a random 16 bytes and user ID. Then, the token encoded using base64.
+	// The password is right, though the failed logins are only forgotten once the login is complete.
+	h.releaseLogin(r.Context(), lockouts, now)
 
-	var token [24]byte
-	if _, err := rand.Read(token[:16]); err != nil {
+	if !h.emailVerified(user, EmailVerificationForLogin) {
+		renderError(w, http.StatusForbidden, errEmailNotVerified.Error())
+		return
+	}
+
+	// The password is only known now, so this is the time to upgrade the hash.
+	if h.passwordHasher.NeedsRehash(user.PasswordHash) {
+		h.rehashPassword(r.Context(), user.ID, req.Password)
+	}
+
+	enabled, err := h.twoFactorEnabled(r.Context(), user.ID)
+	if err != nil {
 		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "login"))
 
 		renderError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	strconv.AppendInt(token[16:], user.ID, 10)
-	hash := sha1.Sum(token[:])
-	tokenEncoded := base64.StdEncoding.EncodeToString(hash[:])
+	if enabled {
+		res, err := h.createChallenge(r.Context(), user.ID, now)
+		if err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "login"))
 
-	now := time.Now()
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// The failed logins are forgotten by loginTwoFactor, once the login is complete.
+		render(w, http.StatusOK, res)
+		return
+	}
+
+	h.unlockLogin(r.Context(), usernameLockoutKey(username), "a successful login")
 
-	if err := h.store.Token().Create(r.Context(), user.ID, tokenEncoded, now); err != nil {
+	res, err := h.startSession(r, user.ID, now)
+	if err != nil {
 		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "login"))
 
 		renderError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	res := struct {
-		Token      string    `json:"token"`
-		UserID     int64     `json:"user_id"`
-		ExpireTime time.Time `json:"expire_time"`
-	}{
-		Token:      tokenEncoded,
-		UserID:     user.ID,
-		ExpireTime: now.Add(tokenExpiry),
+	render(w, http.StatusOK, res)
+}
+
+// startSession creates a new session of the user for the device making the request, and returns its tokens.
+func (h *Handler) startSession(r *http.Request, userID int64, now time.Time) (*tokenResponse, error) {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		// Cut before the rune at the limit, cutting a rune in the middle would leave invalid UTF-8.
+		n := maxUserAgentLength
+		for n > 0 && !utf8.RuneStart(userAgent[n]) {
+			n--
+		}
+		userAgent = userAgent[:n]
+	}
+
+	token, err := newToken(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	newSession := store.Token{
+		UserID:     userID,
+		UserAgent:  userAgent,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+	newSession.ExpiresAt = h.expiry(&newSession, now, h.accessTokenLifetime)
+
+	err = h.store.Token().Create(r.Context(), h.hashToken(token), newSession)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := h.store.Token().GetUserID(r.Context(), h.hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+
+	return h.issueTokens(r.Context(), session, token, now)
+}
+
+// refreshToken exchanges a refresh token for a new access token and a new refresh token of the same session.
+// A refresh token can be used only once. Using it again means it has been stolen, so the whole session is revoked.
+func (h *Handler) refreshToken(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.RefreshToken == "" {
+		renderError(w, http.StatusBadRequest, "refresh_token is empty")
+		return
+	}
+
+	refreshHash := h.hashToken(req.RefreshToken)
+
+	refresh, err := h.store.RefreshToken().Get(r.Context(), refreshHash)
+	if err == store.ErrNotFound {
+		renderError(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	} else if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "refresh token"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if refresh.UsedAt != nil {
+		h.revokeReusedSession(r.Context(), refresh)
+
+		renderError(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+
+	now := time.Now()
+
+	if now.After(refresh.ExpiresAt) {
+		renderError(w, http.StatusUnauthorized, "Expired refresh token")
+		return
+	}
+
+	session, err := h.store.Token().GetByID(r.Context(), refresh.SessionID)
+	if err == store.ErrNotFound {
+		// The session has been logged out.
+		renderError(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	} else if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "refresh token"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if h.sessionExpired(session, now) {
+		renderError(w, http.StatusUnauthorized, "Expired session")
+		return
+	}
+
+	// Another request may have used the same refresh token since it was read.
+	err = h.store.RefreshToken().MarkUsed(r.Context(), refreshHash, now)
+	if err == store.ErrNotFound {
+		h.revokeReusedSession(r.Context(), refresh)
+
+		renderError(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	} else if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "refresh token"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	token, err := newToken(refresh.UserID)
+	if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "refresh token"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
 
-	render(w, http.StatusOK, &res)
+	tokenHash := h.hashToken(token)
+
+	err = h.store.Token().Renew(r.Context(), session.ID, tokenHash, h.expiry(session, now, h.accessTokenLifetime))
+	if err == store.ErrNotFound {
+		// The session has been logged out.
+		renderError(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	} else if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "refresh token"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Refreshing is using the session, which is the only use of a session in the signed token mode.
+	if err := h.store.Token().Touch(r.Context(), tokenHash, now); err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "refresh token"))
+	}
+
+	res, err := h.issueTokens(r.Context(), session, token, now)
+	if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "refresh token"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	render(w, http.StatusOK, res)
+}
+
+type tokenResponse struct {
+	Token             string    `json:"token"`
+	UserID            int64     `json:"user_id"`
+	ExpireTime        time.Time `json:"expire_time"`
+	RefreshToken      string    `json:"refresh_token"`
+	RefreshExpireTime time.Time `json:"refresh_expire_time"`
+}
+
+// issueTokens creates a new refresh token for the session, and returns it along with the access token
+// of the session's current token.
+func (h *Handler) issueTokens(ctx context.Context, session *store.Token, token string, now time.Time) (*tokenResponse, error) {
+	accessToken, err := h.accessToken(session, token, now)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := newToken(session.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.store.RefreshToken().Create(ctx, h.hashToken(refreshToken), store.RefreshToken{
+		SessionID: session.ID,
+		UserID:    session.UserID,
+		CreatedAt: now,
+		ExpiresAt: h.expiry(session, now, h.refreshTokenLifetime),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &tokenResponse{
+		Token:             accessToken,
+		UserID:            session.UserID,
+		ExpireTime:        h.expiry(session, now, h.accessTokenLifetime),
+		RefreshToken:      refreshToken,
+		RefreshExpireTime: h.expiry(session, now, h.refreshTokenLifetime),
+	}
+
+	return res, nil
+}
+
+// revokeReusedSession revokes the session of a refresh token that is used more than once,
+// which logs out both the attacker and the user.
+func (h *Handler) revokeReusedSession(ctx context.Context, refresh *store.RefreshToken) {
+	h.logger.Printf("WARNING: refresh token reused, revoking session %d of user %d", refresh.SessionID, refresh.UserID)
+
+	err := h.store.Token().DeleteByID(ctx, refresh.SessionID)
+	if err != nil && err != store.ErrNotFound {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "revoke session"))
+	}
+
+	if err := h.denySessions(ctx, refresh.SessionID); err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "revoke session"))
+	}
+}
+
+// newToken generates a token by combining a random 16 bytes and user ID. Then, the token encoded using base64.
+func newToken(userID int64) (string, error) {
+	var token [24]byte
+	if _, err := rand.Read(token[:16]); err != nil {
+		return "", err
+	}
+
+	strconv.AppendInt(token[16:], userID, 10)
+	hash := sha1.Sum(token[:])
+
+	return base64.StdEncoding.EncodeToString(hash[:]), nil
 }
 
 func (h *Handler) register(w http.ResponseWriter, r *http.Request) {
 	type request struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Email    string `json:"email"`
 	}
 
 	var req request
@@ -98,24 +380,34 @@ func (h *Handler) register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Username == "" {
-		renderError(w, http.StatusBadRequest, "username is empty")
+	username := strings.ToLower(strings.TrimSpace(req.Username))
+
+	errs := h.registrationPolicy.validateUsername("username", username)
+	errs = append(errs, h.registrationPolicy.validatePassword("password", req.Password, username)...)
+	if len(errs) > 0 {
+		renderFieldErrors(w, http.StatusBadRequest, "invalid username or password", errs)
 		return
 	}
 
-	if req.Password == "" {
-		renderError(w, http.StatusBadRequest, "password is empty")
-		return
+	// The email address is optional, unless it must be verified to use the account.
+	var email string
+	if req.Email != "" || h.emailVerification != EmailVerificationOptional {
+		var errs []fieldError
+
+		email, errs = normalizeEmail("email", req.Email)
+		if len(errs) > 0 {
+			renderFieldErrors(w, http.StatusBadRequest, "invalid email", errs)
+			return
+		}
 	}
 
-	username := strings.ToLower(strings.TrimSpace(req.Username))
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	passwordHash, err := h.passwordHasher.Hash(req.Password)
 	if err != nil {
 		renderError(w, http.StatusBadRequest, "bad password")
 		return
 	}
 
-	err = h.store.User().Create(r.Context(), username, string(passwordHash))
+	err = h.store.User().Create(r.Context(), username, passwordHash)
 	if err != nil {
 		if err == store.ErrDuplicate {
 			renderError(w, http.StatusBadRequest, "username already exists")
@@ -136,6 +428,25 @@ func (h *Handler) register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if email != "" {
+		if err := h.store.User().SetEmail(r.Context(), user.ID, email); err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "register"))
+
+			// Otherwise the username is taken by a user without the email address, which cannot be registered again.
+			if err := h.store.User().Delete(r.Context(), user.ID); err != nil {
+				h.logger.Printf("ERROR: %v", errors.WithMessage(err, "register: delete the user without email"))
+			}
+
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// The user can ask for another token, so failing to send this one does not fail the registration.
+		if err := h.sendEmailVerification(r.Context(), user.ID, email); err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "register"))
+		}
+	}
+
 	res := struct {
 		UserID int64 `json:"user_id"`
 	}{
@@ -144,3 +455,118 @@ func (h *Handler) register(w http.ResponseWriter, r *http.Request) {
 
 	render(w, http.StatusCreated, &res)
 }
+
+// logout revokes the session of the token used to make the request.
+func (h *Handler) logout(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.Context().Value("token_id").(int64)
+
+	err := h.store.Token().DeleteByID(r.Context(), sessionID)
+	if err != nil && err != store.ErrNotFound {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "logout"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := h.denySessions(r.Context(), sessionID); err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "logout"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// logoutAll revokes every session of the user, including the one used to make the request.
+func (h *Handler) logoutAll(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int64)
+
+	if err := h.revokeSessions(r.Context(), userID, 0); err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "logout all"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeSessions revokes every session of the user except the session keepID. Zero keeps none.
+func (h *Handler) revokeSessions(ctx context.Context, userID, keepID int64) error {
+	// The sessions are listed before they are deleted, to put them on the deny list.
+	sessions, err := h.store.Token().List(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if keepID == 0 {
+		if err := h.store.Token().DeleteByUserID(ctx, userID); err != nil {
+			return err
+		}
+	}
+
+	sessionIDs := make([]int64, 0, len(sessions))
+	for _, s := range sessions {
+		if s.ID == keepID {
+			continue
+		}
+
+		if keepID != 0 {
+			err := h.store.Token().DeleteByID(ctx, s.ID)
+			if err != nil && err != store.ErrNotFound {
+				return err
+			}
+		}
+
+		sessionIDs = append(sessionIDs, s.ID)
+	}
+
+	return h.denySessions(ctx, sessionIDs...)
+}
+
+func (h *Handler) getSessions() http.HandlerFunc {
+	type session struct {
+		ID         int64     `json:"id"`
+		UserAgent  string    `json:"user_agent"`
+		CreatedAt  time.Time `json:"created_at"`
+		LastUsedAt time.Time `json:"last_used_at"`
+		Current    bool      `json:"current"`
+	}
+
+	type response struct {
+		Sessions []session `json:"sessions"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("user_id").(int64)
+		tokenID := r.Context().Value("token_id").(int64)
+
+		tokens, err := h.store.Token().List(r.Context(), userID)
+		if err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "get sessions"))
+
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		now := time.Now()
+
+		res := response{Sessions: []session{}}
+		for _, t := range tokens {
+			if h.sessionExpired(t, now) {
+				continue
+			}
+
+			res.Sessions = append(res.Sessions, session{
+				ID:         t.ID,
+				UserAgent:  t.UserAgent,
+				CreatedAt:  t.CreatedAt,
+				LastUsedAt: t.LastUsedAt,
+				Current:    t.ID == tokenID,
+			})
+		}
+
+		render(w, http.StatusOK, &res)
+	}
+}
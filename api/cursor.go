@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// encodeCursor returns an opaque cursor that points after msg.
+func encodeCursor(msg *store.Message) string {
+	raw := fmt.Sprintf("%d:%d", msg.SentDateTime.UnixNano(), msg.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (*store.Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	var sentAt, id int64
+	if n, err := fmt.Sscanf(string(raw), "%d:%d", &sentAt, &id); err != nil || n != 2 {
+		return nil, errInvalidCursor
+	}
+
+	return &store.Cursor{
+		SentDateTime: time.Unix(0, sentAt),
+		ID:           id,
+	}, nil
+}
+
+// parsePage reads the limit and cursor query parameters.
+func parsePage(r *http.Request) (after *store.Cursor, limit int, err error) {
+	limit = defaultPageLimit
+
+	if val := r.URL.Query().Get("limit"); val != "" {
+		limit, err = strconv.Atoi(val)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return nil, 0, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+	}
+
+	if val := r.URL.Query().Get("cursor"); val != "" {
+		after, err = decodeCursor(val)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return after, limit, nil
+}
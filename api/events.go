@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/events"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+	"github.com/pkg/errors"
+)
+
+// publishTimeout is how long a write waits for its event to be published.
+const publishTimeout = 10 * time.Second
+
+// Close ends the subscription to the events, disconnects the WebSocket clients and ends the event streams,
+// which http.Server.Shutdown does not do, then waits for the emails being sent. See also
+// http.Server.RegisterOnShutdown.
+func (h *Handler) Close() {
+	h.stopEvents()
+	h.wsHub.close()
+	h.eventLog.close()
+
+	h.mails.Wait()
+}
+
+// publishEvent publishes the event of a write. The write is done, so it is not failed for the event,
+// which is only logged. The event is published even if the client has gone away meanwhile, but it is lost if the
+// server stops first, unless the outbox relay publishes the events instead.
+func (h *Handler) publishEvent(e events.Event) {
+	if h.publisher == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	if err := h.publisher.Publish(ctx, e); err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "publish "+e.Type))
+	}
+}
+
+// publishMessageCreated publishes the new message, read back from the store to have its sender name.
+func (h *Handler) publishMessageCreated(ctx context.Context, msgID int64, recipientUserIDs []int64) {
+	if h.publisher == nil {
+		return
+	}
+
+	msg, err := h.store.Message().GetByID(ctx, msgID)
+	if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "publish "+events.MessageCreated))
+		return
+	}
+
+	h.publishEvent(events.Event{
+		Type:         events.MessageCreated,
+		Message:      msg,
+		RecipientIDs: recipientUserIDs,
+	})
+}
+
+// pushEvents pushes the events of every server to the users connected to this one, until the subscription ends.
+func (h *Handler) pushEvents(ch <-chan events.Event) {
+	for e := range ch {
+		switch e.Type {
+		case events.MessageUpdated:
+			// The recipients added see the message for the first time, and the ones removed no longer see it.
+			var added, kept, removed []int64
+			for _, id := range e.RecipientIDs {
+				if containsID(e.PreviousRecipientIDs, id) {
+					kept = append(kept, id)
+				} else {
+					added = append(added, id)
+				}
+			}
+			for _, id := range e.PreviousRecipientIDs {
+				if !containsID(e.RecipientIDs, id) {
+					removed = append(removed, id)
+				}
+			}
+
+			h.pushMessageEvent(events.MessageCreated, e.Message, added)
+			h.pushMessageEvent(events.MessageUpdated, e.Message, kept)
+			h.pushMessageEvent(events.MessageDeleted, e.Message, removed)
+		default:
+			h.pushMessageEvent(e.Type, e.Message, e.RecipientIDs)
+		}
+	}
+}
+
+// pushMessageEvent pushes the event to the connected recipients of the message, and logs it for the event streams.
+func (h *Handler) pushMessageEvent(eventType string, msg *store.Message, recipientUserIDs []int64) {
+	if len(recipientUserIDs) == 0 {
+		return
+	}
+
+	msgData, err := json.Marshal(msg)
+	if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "push "+eventType))
+		return
+	}
+
+	data, err := json.Marshal(event{Type: eventType, Message: msgData})
+	if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "push "+eventType))
+		return
+	}
+
+	h.eventLog.append(eventType, msgData, recipientUserIDs)
+	h.wsHub.publish(recipientUserIDs, data)
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher hashes the passwords kept in the store.
+type PasswordHasher interface {
+	// Hash returns the hash of the password, which records the algorithm and the parameters used.
+	Hash(password string) (string, error)
+
+	// Verify reports whether the password matches the hash. The hash can be made by any of the supported algorithms,
+	// so the passwords hashed before switching the hasher still work.
+	Verify(hash, password string) (bool, error)
+
+	// NeedsRehash reports whether the hash is made by another algorithm or with other parameters than the hasher's.
+	NeedsRehash(hash string) bool
+}
+
+var errUnknownHash = errors.New("unknown password hash format")
+
+// verifyPassword reports whether the password matches a bcrypt or an Argon2id hash.
+func verifyPassword(hash, password string) (bool, error) {
+	switch {
+	case isBcryptHash(hash):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, salt, key, err := decodeArgon2idHash(hash)
+		if err != nil {
+			return false, err
+		}
+
+		other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	default:
+		return false, errUnknownHash
+	}
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+var _ PasswordHasher = (*BcryptHasher)(nil)
+
+// BcryptHasher hashes the passwords with bcrypt.
+type BcryptHasher struct {
+	cost int
+}
+
+// NewBcryptHasher returns a BcryptHasher with the cost, which is between bcrypt.MinCost and bcrypt.MaxCost.
+func NewBcryptHasher(cost int) (*BcryptHasher, error) {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost %d is out of range [%d, %d]", cost, bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	return &BcryptHasher{cost: cost}, nil
+}
+
+func (b *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+func (b *BcryptHasher) Verify(hash, password string) (bool, error) {
+	return verifyPassword(hash, password)
+}
+
+func (b *BcryptHasher) NeedsRehash(hash string) bool {
+	if !isBcryptHash(hash) {
+		return true
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+
+	return err != nil || cost != b.cost
+}
+
+// Argon2idParams are the parameters of Argon2id.
+type Argon2idParams struct {
+	// Memory is in KiB.
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+// DefaultArgon2idParams are the parameters recommended by RFC 9106 for memory constrained environments.
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:  64 * 1024,
+	Time:    3,
+	Threads: 4,
+}
+
+const (
+	argon2idSaltLength = 16
+	argon2idKeyLength  = 32
+)
+
+var _ PasswordHasher = (*Argon2idHasher)(nil)
+
+// Argon2idHasher hashes the passwords with Argon2id. The hashes are in the PHC string format,
+// e.g. $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>.
+type Argon2idHasher struct {
+	params Argon2idParams
+}
+
+func NewArgon2idHasher(params Argon2idParams) (*Argon2idHasher, error) {
+	if params.Memory < 8*uint32(params.Threads) || params.Time < 1 || params.Threads < 1 {
+		return nil, fmt.Errorf("invalid argon2id parameters m=%d,t=%d,p=%d", params.Memory, params.Time, params.Threads)
+	}
+
+	return &Argon2idHasher{params: params}, nil
+}
+
+func (a *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2idSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.params.Time, a.params.Memory, a.params.Threads, argon2idKeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.params.Memory, a.params.Time, a.params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a *Argon2idHasher) Verify(hash, password string) (bool, error) {
+	return verifyPassword(hash, password)
+}
+
+func (a *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, salt, key, err := decodeArgon2idHash(hash)
+
+	return err != nil || params != a.params || len(salt) != argon2idSaltLength || len(key) != argon2idKeyLength
+}
+
+// decodeArgon2idHash parses a hash in the PHC string format.
+func decodeArgon2idHash(hash string) (params Argon2idParams, salt, key []byte, err error) {
+	// The hash starts with $, so the first part is empty.
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil || params.Time < 1 || params.Threads < 1 {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errors.New("invalid argon2id salt")
+	}
+
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errors.New("invalid argon2id key")
+	}
+
+	return params, salt, key, nil
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2idParams keep the tests fast.
+var testArgon2idParams = Argon2idParams{Memory: 64, Time: 1, Threads: 1}
+
+func TestPasswordHasher(t *testing.T) {
+	bcryptHasher, err := NewBcryptHasher(bcrypt.MinCost)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	argon2idHasher, err := NewArgon2idHasher(testArgon2idParams)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	hashers := map[string]PasswordHasher{
+		"bcrypt":   bcryptHasher,
+		"argon2id": argon2idHasher,
+	}
+
+	for name, hasher := range hashers {
+		t.Run(name, func(t *testing.T) {
+			hash, err := hasher.Hash("password")
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+
+			assert.False(t, hasher.NeedsRehash(hash))
+
+			// Every hasher verifies the hashes of the other hashers.
+			for otherName, other := range hashers {
+				ok, err := other.Verify(hash, "password")
+				assert.NoError(t, err, otherName)
+				assert.True(t, ok, otherName)
+
+				ok, err = other.Verify(hash, "wrong")
+				assert.NoError(t, err, otherName)
+				assert.False(t, ok, otherName)
+
+				if otherName != name {
+					assert.True(t, other.NeedsRehash(hash), otherName)
+				}
+			}
+		})
+	}
+}
+
+func TestArgon2idHash(t *testing.T) {
+	hasher, err := NewArgon2idHasher(testArgon2idParams)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	hash, err := hasher.Hash("password")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$"), hash)
+
+	// The same password has another hash, because of the salt.
+	other, err := hasher.Hash("password")
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, other)
+
+	// Stronger parameters need a rehash.
+	stronger, err := NewArgon2idHasher(Argon2idParams{Memory: 128, Time: 1, Threads: 1})
+	if assert.NoError(t, err) {
+		assert.True(t, stronger.NeedsRehash(hash))
+	}
+
+	// The test vector of the reference implementation, https://github.com/P-H-C/phc-winner-argon2.
+	ok, err := hasher.Verify("$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "password")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	for _, hash := range []string{
+		"",
+		"password",
+		"$argon2i$v=19$m=64,t=1,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+		"$argon2id$v=16$m=64,t=1,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+		"$argon2id$v=19$m=64,t=0,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+		"$argon2id$v=19$m=64,t=1,p=1$!$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+		"$argon2id$v=19$m=64,t=1,p=1$c29tZXNhbHQ$",
+	} {
+		_, err := hasher.Verify(hash, "password")
+		assert.Error(t, err, hash)
+		assert.True(t, hasher.NeedsRehash(hash), hash)
+	}
+
+	_, err = NewArgon2idHasher(Argon2idParams{Memory: 64, Time: 0, Threads: 1})
+	assert.Error(t, err)
+}
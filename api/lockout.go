@@ -0,0 +1,234 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+	"github.com/pkg/errors"
+)
+
+// LockoutPolicy locks out a username or an IP address after too many failed logins.
+// Every failed login after the first lockout doubles the lockout, up to MaxLockout.
+type LockoutPolicy struct {
+	// MaxFailures is the failed logins allowed before the lockout. Zero disables the lockout.
+	MaxFailures int
+	// Lockout is how long the first lockout lasts.
+	Lockout    time.Duration
+	MaxLockout time.Duration
+	// ResetAfter forgets the failed logins when there is no failed login for this long.
+	// It should be longer than MaxLockout, otherwise the count starts over after the longest lockouts.
+	ResetAfter time.Duration
+}
+
+var (
+	defaultUsernameLockout = LockoutPolicy{
+		MaxFailures: 5,
+		Lockout:     time.Minute,
+		MaxLockout:  time.Hour,
+		ResetAfter:  24 * time.Hour,
+	}
+
+	// Many users can share an IP address, so it allows more failed logins than a username.
+	defaultIPLockout = LockoutPolicy{
+		MaxFailures: 20,
+		Lockout:     time.Minute,
+		MaxLockout:  time.Hour,
+		ResetAfter:  24 * time.Hour,
+	}
+)
+
+// maxLoginFailureKeyLength is the size of the login_failures.login_key column.
+const maxLoginFailureKeyLength = 255
+
+// lockoutDuration returns how long the key is locked out after count failed logins.
+func (p LockoutPolicy) lockoutDuration(count int) time.Duration {
+	d := p.Lockout
+	for i := p.MaxFailures; i < count && d < p.MaxLockout; i++ {
+		d *= 2
+	}
+
+	if d > p.MaxLockout {
+		d = p.MaxLockout
+	}
+
+	return d
+}
+
+// lockout is the failed logins of a username or an IP address.
+type lockout struct {
+	key    string
+	policy LockoutPolicy
+	// failures is nil if there is no failed login.
+	failures *store.LoginFailures
+	// count is the failed logins including this one, counted by reserveLogin. Zero if it is not counted.
+	count int
+	// lockedFor is the lockout set by reserveLogin, zero if none.
+	lockedFor time.Duration
+}
+
+// justUnlocked reports whether the lockout of the failed logins has ended, and no login has been tried since.
+func justUnlocked(f *store.LoginFailures, now time.Time) bool {
+	return !f.LockedUntil.IsZero() && !f.LockedUntil.After(now) && !f.LastFailedAt.After(f.LockedUntil)
+}
+
+// getLockouts returns the failed logins of the key, e.g. a username, and of the IP address the request comes from.
+// The key comes first. The disabled policies are left out.
+func (h *Handler) getLockouts(r *http.Request, key string, policy LockoutPolicy) ([]*lockout, error) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	var lockouts []*lockout
+
+	if policy.MaxFailures > 0 {
+		lockouts = append(lockouts, &lockout{key: key, policy: policy})
+	}
+
+	if h.ipLockout.MaxFailures > 0 {
+		lockouts = append(lockouts, &lockout{key: "ip:" + ip, policy: h.ipLockout})
+	}
+
+	now := time.Now()
+
+	for _, l := range lockouts {
+		f, err := h.store.LoginFailure().Get(r.Context(), l.key)
+		if err == store.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		// No login has failed since the lockout, so this is the first login after it expired.
+		if justUnlocked(f, now) {
+			h.logger.Printf("INFO: %s unlocked by the end of its lockout at %s", l.key, f.LockedUntil.Format(time.RFC3339))
+		}
+
+		l.failures = f
+	}
+
+	return lockouts, nil
+}
+
+func usernameLockoutKey(username string) string {
+	key := "username:" + username
+	if len(key) > maxLoginFailureKeyLength {
+		key = key[:maxLoginFailureKeyLength]
+	}
+
+	return key
+}
+
+// retryAfter returns how long until none of the lockouts is locked out. Zero means not locked out.
+func retryAfter(lockouts []*lockout, now time.Time) time.Duration {
+	var d time.Duration
+
+	for _, l := range lockouts {
+		if l.failures != nil && l.failures.LockedUntil.Sub(now) > d {
+			d = l.failures.LockedUntil.Sub(now)
+		}
+	}
+
+	return d
+}
+
+// reserveLogin counts the login as failed before the password is checked, and returns how long to retry after if
+// too many logins are being tried at once. The count is atomic in the store, so the concurrent guesses on every
+// server cannot all pass the check of getLockouts before the first of them is counted.
+//
+// A login is let through if the failed logins are still below the limit, or if it is the only one since the
+// lockout ended. Otherwise it is taken back and refused. The login that reaches the limit locks out the key
+// right away, so the logins after it are refused while its password is checked. The logins let through are either
+// kept failed with addLoginFailure, or taken back with releaseLogin once the password is right.
+func (h *Handler) reserveLogin(ctx context.Context, lockouts []*lockout, now time.Time) (time.Duration, error) {
+	for _, l := range lockouts {
+		f, err := h.store.LoginFailure().Add(ctx, l.key, now, now.Add(-l.policy.ResetAfter))
+		if err != nil {
+			h.releaseLogin(ctx, lockouts, now)
+			return 0, err
+		}
+
+		l.count = f.Count
+
+		if f.Count > l.policy.MaxFailures {
+			// Another login is counted since the check, or since the lockout.
+			if l.failures == nil || f.Count != l.failures.Count+1 || !justUnlocked(l.failures, now) {
+				h.releaseLogin(ctx, lockouts, now)
+				return l.policy.Lockout, nil
+			}
+		}
+
+		if f.Count >= l.policy.MaxFailures {
+			d := l.policy.lockoutDuration(f.Count)
+
+			err := h.store.LoginFailure().Lock(ctx, l.key, now.Add(d))
+			if err != nil && err != store.ErrNotFound {
+				h.releaseLogin(ctx, lockouts, now)
+				return 0, err
+			}
+
+			l.lockedFor = d
+		}
+	}
+
+	return 0, nil
+}
+
+// releaseLogin takes back the failed logins counted by reserveLogin, and ends their lockouts, for a login that has
+// not failed after all. The errors are only logged, at worst the login counts as failed.
+func (h *Handler) releaseLogin(ctx context.Context, lockouts []*lockout, now time.Time) {
+	for _, l := range lockouts {
+		if l.count == 0 {
+			continue
+		}
+
+		// The failed logins of the key may have been forgotten meanwhile.
+		if l.lockedFor > 0 {
+			if err := h.store.LoginFailure().Lock(ctx, l.key, now); err != nil && err != store.ErrNotFound {
+				h.logger.Printf("ERROR: %v", errors.WithMessage(err, "release login"))
+			}
+		}
+
+		if err := h.store.LoginFailure().Undo(ctx, l.key); err != nil && err != store.ErrNotFound {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "release login"))
+		}
+
+		l.count = 0
+		l.lockedFor = 0
+	}
+}
+
+// addLoginFailure keeps the failed login counted by reserveLogin, along with the lockouts it set.
+func (h *Handler) addLoginFailure(lockouts []*lockout) {
+	for _, l := range lockouts {
+		if l.lockedFor > 0 {
+			h.logger.Printf("WARNING: %s locked out for %v after %d failed logins", l.key, l.lockedFor, l.count)
+		}
+	}
+}
+
+// unlockLogin forgets the failed logins of the key, e.g. a username, which unlocks it if it is locked out.
+// The failed logins of the IP addresses are kept, an attacker could otherwise unlock an IP address with its own account.
+func (h *Handler) unlockLogin(ctx context.Context, key, reason string) {
+	f, err := h.store.LoginFailure().Get(ctx, key)
+	if err == store.ErrNotFound {
+		return
+	} else if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "unlock login"))
+		return
+	}
+
+	err = h.store.LoginFailure().Delete(ctx, key)
+	if err != nil && err != store.ErrNotFound {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "unlock login"))
+		return
+	}
+
+	// An expired lockout is logged by getLockouts.
+	if f.LockedUntil.After(time.Now()) {
+		h.logger.Printf("INFO: %s unlocked by %s", key, reason)
+	}
+}
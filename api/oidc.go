@@ -0,0 +1,303 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/oidc"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+	"github.com/pkg/errors"
+)
+
+const (
+	// oidcCookieName is the cookie keeping the login at the identity provider between the start and the callback.
+	oidcCookieName = "oidc_login"
+
+	// oidcLoginLifetime is how long the user has to sign in at the identity provider.
+	oidcLoginLifetime = 10 * time.Minute
+)
+
+var (
+	errOIDCDisabled = errors.New("login with an identity provider is disabled")
+	errNoOIDCUser   = errors.New("no user for this identity")
+)
+
+// oidcLogin is the state of a login at the identity provider, kept in a cookie of the browser.
+type oidcLogin struct {
+	State     string `json:"state"`
+	Nonce     string `json:"nonce"`
+	Verifier  string `json:"verifier"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// startOIDC sends the browser to the identity provider, which sends it back to oidcCallback.
+func (h *Handler) startOIDC(w http.ResponseWriter, r *http.Request) {
+	if h.oidcProvider == nil {
+		renderError(w, http.StatusNotImplemented, errOIDCDisabled.Error())
+		return
+	}
+
+	var login oidcLogin
+
+	for _, v := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		var err error
+
+		*v, err = oidc.NewVerifier()
+		if err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "start oidc"))
+
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	login.ExpiresAt = time.Now().Add(oidcLoginLifetime).Unix()
+
+	value, err := h.encodeOIDCLogin(login)
+	if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "start oidc"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	http.SetCookie(w, h.oidcCookie(value, int(oidcLoginLifetime.Seconds())))
+
+	http.Redirect(w, r, h.oidcProvider.AuthCodeURL(login.State, login.Nonce, login.Verifier), http.StatusFound)
+}
+
+// oidcCallback completes the login at the identity provider. The user is linked, or created, on the first login,
+// then gets a session the same as with a password.
+func (h *Handler) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if h.oidcProvider == nil {
+		renderError(w, http.StatusNotImplemented, errOIDCDisabled.Error())
+		return
+	}
+
+	query := r.URL.Query()
+
+	// The login is over either way.
+	http.SetCookie(w, h.oidcCookie("", -1))
+
+	if e := query.Get("error"); e != "" {
+		renderError(w, http.StatusUnauthorized, "identity provider: "+e+" "+query.Get("error_description"))
+		return
+	}
+
+	cookie, err := r.Cookie(oidcCookieName)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, "no login in progress")
+		return
+	}
+
+	now := time.Now()
+
+	login, ok := h.decodeOIDCLogin(cookie.Value)
+	if !ok || now.Unix() > login.ExpiresAt {
+		renderError(w, http.StatusBadRequest, "no login in progress")
+		return
+	}
+
+	// The state binds the callback to the browser that started the login, so an attacker cannot log the user in
+	// to the attacker's account.
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(login.State)) != 1 {
+		renderError(w, http.StatusBadRequest, "invalid state")
+		return
+	}
+
+	if query.Get("code") == "" {
+		renderError(w, http.StatusBadRequest, "code is empty")
+		return
+	}
+
+	rawIDToken, err := h.oidcProvider.Exchange(r.Context(), query.Get("code"), login.Verifier)
+	if err != nil {
+		h.logger.Printf("WARNING: %v", errors.WithMessage(err, "oidc callback"))
+
+		renderError(w, http.StatusUnauthorized, "login with the identity provider failed")
+		return
+	}
+
+	idToken, err := h.oidcProvider.Verify(r.Context(), rawIDToken, login.Nonce, now)
+	if err != nil {
+		h.logger.Printf("WARNING: %v", errors.WithMessage(err, "oidc callback"))
+
+		renderError(w, http.StatusUnauthorized, "login with the identity provider failed")
+		return
+	}
+
+	user, err := h.oidcUser(r.Context(), idToken)
+	if err == errNoOIDCUser {
+		renderError(w, http.StatusForbidden, err.Error())
+		return
+	} else if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "oidc callback"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !h.emailVerified(user, EmailVerificationForLogin) {
+		renderError(w, http.StatusForbidden, errEmailNotVerified.Error())
+		return
+	}
+
+	enabled, err := h.twoFactorEnabled(r.Context(), user.ID)
+	if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "oidc callback"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if enabled {
+		res, err := h.createChallenge(r.Context(), user.ID, now)
+		if err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "oidc callback"))
+
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		render(w, http.StatusOK, res)
+		return
+	}
+
+	res, err := h.startSession(r, user.ID, now)
+	if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "oidc callback"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	render(w, http.StatusOK, res)
+}
+
+// oidcUser returns the user linked to the identity. On the first login, the identity is linked to the user whose
+// username is the verified email address, if the user has verified the same address too. Anyone can register a
+// username that looks like an email address, so an unverified one proves nothing. If there is no such user and
+// autoProvision is on, a new user without a password is created, whose email address is verified by the provider.
+// It returns errNoOIDCUser if there is no such user.
+func (h *Handler) oidcUser(ctx context.Context, idToken *oidc.IDToken) (*store.User, error) {
+	user, err := h.store.User().GetByIdentity(ctx, idToken.Issuer, idToken.Subject)
+	if err == nil {
+		return user, nil
+	} else if err != store.ErrNotFound {
+		return nil, err
+	}
+
+	// An unverified email address could be anyone's.
+	if idToken.Email == "" || !idToken.EmailVerified {
+		return nil, errNoOIDCUser
+	}
+
+	email := strings.ToLower(strings.TrimSpace(idToken.Email))
+
+	created := false
+
+	user, err = h.store.User().GetByUsername(ctx, email)
+	if err == store.ErrNotFound {
+		if !h.oidcAutoProvision || len(h.registrationPolicy.validateUsername("username", email)) > 0 {
+			return nil, errNoOIDCUser
+		}
+
+		// A duplicate is someone else's user, registered meanwhile, so it is not created here.
+		err = h.store.User().Create(ctx, email, "")
+		if err == nil {
+			created = true
+		} else if err != store.ErrDuplicate {
+			return nil, err
+		}
+
+		user, err = h.store.User().GetByUsername(ctx, email)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !created && (user.VerifiedAt == nil || user.Email != email) {
+		return nil, errNoOIDCUser
+	}
+
+	err = h.store.User().LinkIdentity(ctx, user.ID, idToken.Issuer, idToken.Subject)
+	if err == store.ErrDuplicate {
+		// Another login of the same identity has linked it meanwhile.
+		return h.store.User().GetByIdentity(ctx, idToken.Issuer, idToken.Subject)
+	} else if err != nil {
+		return nil, err
+	}
+
+	// The provider has verified the email address of the new user, so the user does not have to.
+	if created {
+		now := time.Now()
+
+		if err := h.store.User().SetEmail(ctx, user.ID, email); err != nil {
+			return nil, err
+		}
+
+		if err := h.store.User().VerifyEmail(ctx, user.ID, email, now); err != nil {
+			return nil, err
+		}
+
+		user.Email = email
+		user.VerifiedAt = &now
+	}
+
+	return user, nil
+}
+
+func (h *Handler) oidcCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    value,
+		Path:     "/auth/oidc",
+		MaxAge:   maxAge,
+		Secure:   strings.HasPrefix(h.oidcProvider.RedirectURL(), "https://"),
+		HttpOnly: true,
+		// Lax, so the cookie is sent along with the redirect from the identity provider.
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// encodeOIDCLogin returns the login and its keyed hash, so the cookie cannot be made up.
+func (h *Handler) encodeOIDCLogin(login oidcLogin) (string, error) {
+	b, err := json.Marshal(login)
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(b)
+
+	return payload + "." + h.hashToken("oidc_login:"+payload), nil
+}
+
+func (h *Handler) decodeOIDCLogin(value string) (*oidcLogin, bool) {
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 {
+		return nil, false
+	}
+
+	payload, mac := value[:i], value[i+1:]
+
+	if subtle.ConstantTimeCompare([]byte(mac), []byte(h.hashToken("oidc_login:"+payload))) != 1 {
+		return nil, false
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, false
+	}
+
+	var login oidcLogin
+	if err := json.Unmarshal(b, &login); err != nil {
+		return nil, false
+	}
+
+	return &login, true
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"crypto/sha256"
+	"strings"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/events"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/jwt"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/mail"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/oidc"
+)
+
+const (
+	defaultAccessTokenLifetime  = 15 * time.Minute
+	defaultRefreshTokenLifetime = 30 * 24 * time.Hour
+	defaultSessionIdleTimeout   = 7 * 24 * time.Hour
+	defaultSessionMaxLifetime   = 90 * 24 * time.Hour
+)
+
+// Option configures the Handler.
+type Option func(h *Handler)
+
+// WithAccessTokenLifetime sets how long an access token is valid. The default is 15 minutes.
+func WithAccessTokenLifetime(d time.Duration) Option {
+	return func(h *Handler) {
+		h.accessTokenLifetime = d
+	}
+}
+
+// WithRefreshTokenLifetime sets how long a refresh token is valid. The default is 30 days.
+// Every refresh issues a new refresh token, so a session stays alive as long as it is refreshed within this time.
+func WithRefreshTokenLifetime(d time.Duration) Option {
+	return func(h *Handler) {
+		h.refreshTokenLifetime = d
+	}
+}
+
+// WithSessionIdleTimeout ends a session that is not used for d. Zero means no idle timeout. The default is 7 days.
+// In the signed token mode, a session is only used when it is refreshed, so d should be longer than the access token lifetime.
+func WithSessionIdleTimeout(d time.Duration) Option {
+	return func(h *Handler) {
+		h.sessionIdleTimeout = d
+	}
+}
+
+// WithSessionMaxLifetime ends a session d after the login, even if it is used. Zero means no maximum. The default is 90 days.
+func WithSessionMaxLifetime(d time.Duration) Option {
+	return func(h *Handler) {
+		h.sessionMaxLifetime = d
+	}
+}
+
+// WithTokenHashSecret sets the secret of the keyed hash of the tokens kept in the store.
+// Changing the secret invalidates every session.
+func WithTokenHashSecret(secret []byte) Option {
+	return func(h *Handler) {
+		h.tokenHashSecret = secret
+	}
+}
+
+// WithTokenSigner switches to the signed token mode. The access tokens are signed by s, and verified without the store.
+// The refresh tokens and the sessions are still kept in the store.
+func WithTokenSigner(s *jwt.Signer) Option {
+	return func(h *Handler) {
+		h.signer = s
+	}
+}
+
+// WithDenyList rejects the signed access tokens of the revoked sessions before they expire.
+// It is only used in the signed token mode.
+func WithDenyList(d jwt.DenyList) Option {
+	return func(h *Handler) {
+		h.denyList = d
+	}
+}
+
+// WithMailer enables the password reset and the email verification, whose tokens are sent by m.
+// They are disabled without a mailer.
+func WithMailer(m mail.Mailer) Option {
+	return func(h *Handler) {
+		h.mailer = m
+	}
+}
+
+// WithPasswordHasher sets how the passwords are hashed. The default is bcrypt with bcrypt.DefaultCost.
+// The passwords hashed by another algorithm or with other parameters are rehashed on the next login.
+func WithPasswordHasher(p PasswordHasher) Option {
+	return func(h *Handler) {
+		h.passwordHasher = p
+	}
+}
+
+// WithUsernameLockout sets how a username is locked out after failed logins. The default allows 5 failed logins,
+// then locks out for a minute, up to an hour.
+func WithUsernameLockout(p LockoutPolicy) Option {
+	return func(h *Handler) {
+		h.usernameLockout = p
+	}
+}
+
+// WithIPLockout sets how an IP address is locked out after failed logins. The default allows 20 failed logins,
+// then locks out for a minute, up to an hour.
+func WithIPLockout(p LockoutPolicy) Option {
+	return func(h *Handler) {
+		h.ipLockout = p
+	}
+}
+
+// WithRegistrationPolicy sets the rules of the usernames and the passwords. The default is DefaultRegistrationPolicy.
+func WithRegistrationPolicy(p RegistrationPolicy) Option {
+	return func(h *Handler) {
+		h.registrationPolicy = p
+	}
+}
+
+// WithTwoFactor enables the TOTP two-factor authentication. The TOTP secrets are encrypted in the store
+// with a key derived from secret. The secret must be kept, the codes of the users who enabled the two-factor
+// authentication cannot be verified without it.
+// The issuer is the name of the service shown by the authenticator apps.
+func WithTwoFactor(secret []byte, issuer string) Option {
+	return func(h *Handler) {
+		key := sha256.Sum256(secret)
+
+		h.totpKey = key[:]
+		h.totpIssuer = issuer
+	}
+}
+
+// WithOIDC enables the login with the OpenID Connect provider p. On the first login, an identity is linked to the user
+// whose username is the verified email address of the identity, only if the user has verified the same email address.
+// If autoProvision is true, a user without a password is created when there is no such user, otherwise the login
+// is rejected. A user whose username is the address, but who has not verified it, is rejected either way.
+func WithOIDC(p *oidc.Provider, autoProvision bool) Option {
+	return func(h *Handler) {
+		h.oidcProvider = p
+		h.oidcAutoProvision = autoProvision
+	}
+}
+
+// WithEmailVerification sets what the users cannot do until their email address is verified. The default is
+// EmailVerificationOptional. The email address is required on registration unless it is optional, and the tokens
+// are sent by the mailer, see WithMailer.
+func WithEmailVerification(v EmailVerification) Option {
+	return func(h *Handler) {
+		h.emailVerification = v
+	}
+}
+
+// WithBaseURL sets the public URL of the API, e.g. https://api.example.com, which the links in the emails start with.
+func WithBaseURL(u string) Option {
+	return func(h *Handler) {
+		h.baseURL = strings.TrimSuffix(u, "/")
+	}
+}
+
+// WithEvents sets where the events of the messages are published, and where the events pushed to the users come from.
+// They are the same medium shared by every server, so the users connected to a server get the events of the others.
+// The default is within the process, which is fine for a single server.
+func WithEvents(publisher events.Publisher, subscriber events.Subscriber) Option {
+	return func(h *Handler) {
+		h.publisher = publisher
+		h.subscriber = subscriber
+	}
+}
+
+// WithOutboxEvents sets where the events pushed to the users come from, like WithEvents, but the handler does not
+// publish the events itself. The outbox relay publishes them instead, see outbox.EventsSink, so an event is not lost
+// if the server stops between a write and its event.
+func WithOutboxEvents(subscriber events.Subscriber) Option {
+	return func(h *Handler) {
+		h.publisher = nil
+		h.subscriber = subscriber
+	}
+}
@@ -0,0 +1,281 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/mail"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+	"github.com/pkg/errors"
+)
+
+// passwordResetLifetime is how long a password reset token is valid.
+const passwordResetLifetime = time.Hour
+
+// changePassword changes the password of the user. The other sessions are revoked, since whoever knew the
+// old password may have logged in with it. The session used to make the request stays logged in.
+func (h *Handler) changePassword(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.CurrentPassword == "" {
+		renderError(w, http.StatusBadRequest, "current_password is empty")
+		return
+	}
+
+	if req.NewPassword == "" {
+		renderError(w, http.StatusBadRequest, "new_password is empty")
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int64)
+	sessionID := r.Context().Value("token_id").(int64)
+
+	user, err := h.store.User().GetByID(r.Context(), userID)
+	if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "change password"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ok, err := h.checkPassword(user, req.CurrentPassword)
+	if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "change password"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !ok {
+		renderError(w, http.StatusForbidden, "invalid current password")
+		return
+	}
+
+	if errs := h.registrationPolicy.validatePassword("new_password", req.NewPassword, user.Username); len(errs) > 0 {
+		renderFieldErrors(w, http.StatusBadRequest, "invalid new password", errs)
+		return
+	}
+
+	if err := h.setPassword(r.Context(), userID, req.NewPassword, sessionID); err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "change password"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// forgotPassword emails a password reset token to the user. It responds the same whether the user exists or not,
+// so it cannot be used to find out the usernames.
+func (h *Handler) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Username string `json:"username"`
+	}
+
+	if h.mailer == nil {
+		renderError(w, http.StatusNotImplemented, "password reset is disabled")
+		return
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Username == "" {
+		renderError(w, http.StatusBadRequest, "username is empty")
+		return
+	}
+
+	username := strings.ToLower(strings.TrimSpace(req.Username))
+
+	user, err := h.store.User().GetByUsername(r.Context(), username)
+	if err == store.ErrNotFound {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	} else if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "forgot password"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// The username is not an email address, so the users without a verified one get no email.
+	if user.Email == "" || user.VerifiedAt == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	h.sendInBackground("forgot password", func(ctx context.Context) error {
+		return h.sendPasswordReset(ctx, user.ID, user.Email)
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// sendPasswordReset sends a password reset token to the email address of the user.
+func (h *Handler) sendPasswordReset(ctx context.Context, userID int64, email string) error {
+	token, err := newToken(userID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	err = h.store.PasswordReset().Create(ctx, h.hashToken(token), store.PasswordResetToken{
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(passwordResetLifetime),
+	})
+	if err != nil {
+		return err
+	}
+
+	return h.mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Use this token to reset your password, it expires in %v:\n\n%s\n\n"+
+			"If you did not ask to reset your password, ignore this email.", passwordResetLifetime, token),
+	})
+}
+
+// resetPassword sets a new password with a password reset token, and revokes every session of the user.
+// A reset token can be used only once.
+func (h *Handler) resetPassword(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Token == "" {
+		renderError(w, http.StatusBadRequest, "token is empty")
+		return
+	}
+
+	if req.Password == "" {
+		renderError(w, http.StatusBadRequest, "password is empty")
+		return
+	}
+
+	tokenHash := h.hashToken(req.Token)
+
+	reset, err := h.store.PasswordReset().Get(r.Context(), tokenHash)
+	if err == store.ErrNotFound {
+		renderError(w, http.StatusBadRequest, "Invalid reset token")
+		return
+	} else if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "reset password"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	now := time.Now()
+
+	if reset.UsedAt != nil {
+		renderError(w, http.StatusBadRequest, "Invalid reset token")
+		return
+	}
+
+	if now.After(reset.ExpiresAt) {
+		renderError(w, http.StatusBadRequest, "Expired reset token")
+		return
+	}
+
+	user, err := h.store.User().GetByID(r.Context(), reset.UserID)
+	if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "reset password"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// The password is checked before the token is used, so the token can be used again with a better password.
+	if errs := h.registrationPolicy.validatePassword("password", req.Password, user.Username); len(errs) > 0 {
+		renderFieldErrors(w, http.StatusBadRequest, "invalid password", errs)
+		return
+	}
+
+	// Another request may have used the same reset token since it was read.
+	err = h.store.PasswordReset().MarkUsed(r.Context(), tokenHash, now)
+	if err == store.ErrNotFound {
+		renderError(w, http.StatusBadRequest, "Invalid reset token")
+		return
+	} else if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "reset password"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := h.setPassword(r.Context(), reset.UserID, req.Password, 0); err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "reset password"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Whoever knows the reset token can log in now, so there is no point in keeping the user locked out.
+	h.unlockLogin(r.Context(), usernameLockoutKey(user.Username), "a password reset")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setPassword changes the password of the user, and revokes every session except keepSessionID.
+func (h *Handler) setPassword(ctx context.Context, userID int64, password string, keepSessionID int64) error {
+	passwordHash, err := h.passwordHasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	if err := h.store.User().UpdatePasswordHash(ctx, userID, passwordHash); err != nil {
+		return err
+	}
+
+	return h.revokeSessions(ctx, userID, keepSessionID)
+}
+
+// rehashPassword replaces the hash of the user's password with one made by the current hasher.
+// Failing to do so does not fail the login, it is tried again on the next login.
+func (h *Handler) rehashPassword(ctx context.Context, userID int64, password string) {
+	passwordHash, err := h.passwordHasher.Hash(password)
+	if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "rehash password"))
+		return
+	}
+
+	if err := h.store.User().UpdatePasswordHash(ctx, userID, passwordHash); err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "rehash password"))
+	}
+}
+
+// checkPassword reports whether the password is the user's. A user without a password, e.g. signed up with an
+// identity provider, has no password to match.
+func (h *Handler) checkPassword(user *store.User, password string) (bool, error) {
+	if user.PasswordHash == "" {
+		return false, nil
+	}
+
+	return h.passwordHasher.Verify(user.PasswordHash, password)
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// RegistrationPolicy is the rules of the usernames of new users, and of every new password.
+type RegistrationPolicy struct {
+	UsernameMinLength int
+	UsernameMaxLength int
+	// UsernamePattern is the allowed characters of the usernames, which are lower case.
+	UsernamePattern *regexp.Regexp
+	// ReservedUsernames cannot be registered.
+	ReservedUsernames []string
+
+	PasswordMinLength int
+	// PasswordMaxLength is in bytes. bcrypt ignores everything after 72 bytes, so it should be at most 72 with bcrypt.
+	PasswordMaxLength int
+	// PasswordMinEntropy is the minimum estimated strength of a password in bits, see passwordEntropy.
+	PasswordMinEntropy float64
+	// CommonPasswords rejects the N most common passwords of the embedded list, which has the 223 most common ones.
+	// Zero disables.
+	CommonPasswords int
+}
+
+// DefaultRegistrationPolicy is the policy of the Handler unless WithRegistrationPolicy is given.
+var DefaultRegistrationPolicy = RegistrationPolicy{
+	UsernameMinLength: 3,
+	UsernameMaxLength: 64,
+	// The username is also the email address of the user.
+	UsernamePattern: regexp.MustCompile(`^[a-z0-9][a-z0-9_.+@-]*$`),
+	ReservedUsernames: []string{
+		"admin", "administrator", "root", "system", "support", "help", "api", "me", "null", "undefined",
+		"postmaster", "webmaster", "hostmaster", "abuse", "noreply", "no-reply",
+	},
+	PasswordMinLength:  8,
+	PasswordMaxLength:  72,
+	PasswordMinEntropy: 30,
+	CommonPasswords:    223,
+}
+
+// fieldError is a validation error of a field of the request.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func renderFieldErrors(w http.ResponseWriter, status int, message string, errs []fieldError) {
+	res := struct {
+		Message string       `json:"message"`
+		Errors  []fieldError `json:"errors"`
+	}{
+		Message: message,
+		Errors:  errs,
+	}
+
+	render(w, status, res)
+}
+
+// validateUsername returns the violations of the username, which is already lower case and trimmed.
+func (p *RegistrationPolicy) validateUsername(field, username string) []fieldError {
+	var errs []fieldError
+
+	length := utf8.RuneCountInString(username)
+
+	if length == 0 {
+		return append(errs, fieldError{Field: field, Message: "username is empty"})
+	}
+
+	if length < p.UsernameMinLength {
+		errs = append(errs, fieldError{Field: field, Message: fmt.Sprintf("username must be at least %d characters", p.UsernameMinLength)})
+	}
+
+	if p.UsernameMaxLength > 0 && length > p.UsernameMaxLength {
+		errs = append(errs, fieldError{Field: field, Message: fmt.Sprintf("username must be at most %d characters", p.UsernameMaxLength)})
+	}
+
+	if p.UsernamePattern != nil && !p.UsernamePattern.MatchString(username) {
+		errs = append(errs, fieldError{Field: field, Message: "username has characters that are not allowed"})
+	}
+
+	for _, reserved := range p.ReservedUsernames {
+		if username == strings.ToLower(reserved) {
+			errs = append(errs, fieldError{Field: field, Message: "username is reserved"})
+			break
+		}
+	}
+
+	return errs
+}
+
+// validatePassword returns the violations of the password. The password cannot be the username either.
+func (p *RegistrationPolicy) validatePassword(field, password, username string) []fieldError {
+	var errs []fieldError
+
+	if password == "" {
+		return append(errs, fieldError{Field: field, Message: "password is empty"})
+	}
+
+	if utf8.RuneCountInString(password) < p.PasswordMinLength {
+		errs = append(errs, fieldError{Field: field, Message: fmt.Sprintf("password must be at least %d characters", p.PasswordMinLength)})
+	}
+
+	if p.PasswordMaxLength > 0 && len(password) > p.PasswordMaxLength {
+		errs = append(errs, fieldError{Field: field, Message: fmt.Sprintf("password must be at most %d bytes", p.PasswordMaxLength)})
+	}
+
+	// The strength is only worth checking once the length is right.
+	if len(errs) > 0 {
+		return errs
+	}
+
+	if isCommonPassword(password, p.CommonPasswords) {
+		errs = append(errs, fieldError{Field: field, Message: "password is too common"})
+	} else if strings.EqualFold(password, username) {
+		errs = append(errs, fieldError{Field: field, Message: "password is the same as the username"})
+	} else if passwordEntropy(password) < p.PasswordMinEntropy {
+		errs = append(errs, fieldError{Field: field, Message: "password is too weak, use more and different characters"})
+	}
+
+	return errs
+}
+
+// passwordEntropy estimates the strength of a password in bits. It is the number of distinct characters
+// times the bits of a character from the character classes used. It counts every character once,
+// so repeating characters does not make a password stronger. The control characters do not count.
+func passwordEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+
+	distinct := make(map[rune]bool)
+
+	for _, r := range password {
+		if unicode.IsControl(r) {
+			continue
+		}
+
+		distinct[r] = true
+
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r <= unicode.MaxASCII:
+			// The space and the 32 punctuation characters, as the control characters are skipped.
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	var pool int
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if other {
+		pool += 100
+	}
+
+	if pool == 0 {
+		return 0
+	}
+
+	return float64(len(distinct)) * math.Log2(float64(pool))
+}
+
+// commonPasswordsList is the most common passwords in the breached password lists, the most common first.
+//
+//go:embed common_passwords.txt
+var commonPasswordsList string
+
+var (
+	commonPasswordsOnce sync.Once
+	// commonPasswordRanks is the position of the passwords in the list, starting from 0.
+	commonPasswordRanks map[string]int
+)
+
+// isCommonPassword reports whether the password, ignoring the case, is one of the n most common passwords.
+func isCommonPassword(password string, n int) bool {
+	commonPasswordsOnce.Do(func() {
+		lines := strings.Split(strings.TrimSpace(commonPasswordsList), "\n")
+
+		commonPasswordRanks = make(map[string]int, len(lines))
+		for i, line := range lines {
+			line = strings.ToLower(strings.TrimSpace(line))
+			if _, ok := commonPasswordRanks[line]; !ok {
+				commonPasswordRanks[line] = i
+			}
+		}
+	})
+
+	rank, ok := commonPasswordRanks[strings.ToLower(password)]
+
+	return ok && rank < n
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// eventLogSize is how many events a client can miss and still resume with Last-Event-ID.
+	eventLogSize = 1000
+
+	// sseWriteTimeout is how long a client has to take an event before it is disconnected.
+	sseWriteTimeout = 10 * time.Second
+
+	// sseKeepAliveInterval keeps the proxies from closing an idle stream.
+	sseKeepAliveInterval = 15 * time.Second
+
+	// eventReset tells a client that it has missed events, so it must fetch the messages again.
+	eventReset = "reset"
+)
+
+// loggedEvent is an event of the messages of some users, data being the JSON of the message.
+type loggedEvent struct {
+	id      int64
+	typ     string
+	data    []byte
+	userIDs []int64
+}
+
+// eventLog keeps the latest events, so a client can resume the stream where it has left off.
+// The IDs are consecutive, starting from the time the log is created, so the IDs given before a restart
+// are older than the log and lead to a reset rather than to events that the client has never seen.
+type eventLog struct {
+	mu      sync.Mutex
+	size    int
+	events  []loggedEvent
+	lastID  int64
+	changed chan struct{}
+	closed  chan struct{}
+}
+
+func newEventLog(size int) *eventLog {
+	return &eventLog{
+		size:    size,
+		lastID:  time.Now().UnixNano(),
+		changed: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+}
+
+// append adds the event, dropping the oldest one if the log is full, and wakes the waiting clients up.
+func (l *eventLog) append(typ string, data []byte, userIDs []int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.events) == l.size {
+		copy(l.events, l.events[1:])
+		l.events = l.events[:l.size-1]
+	}
+
+	l.lastID++
+
+	l.events = append(l.events, loggedEvent{
+		id:      l.lastID,
+		typ:     typ,
+		data:    data,
+		userIDs: userIDs,
+	})
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// since returns the events of the user after the event afterID, and the ID to continue from.
+// It returns false if the log no longer has every event after afterID. The returned channel is closed
+// on the next append.
+func (l *eventLog) since(userID, afterID int64) ([]loggedEvent, int64, bool, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	firstID := l.lastID + 1
+	if len(l.events) > 0 {
+		firstID = l.events[0].id
+	}
+
+	if afterID < firstID-1 || afterID > l.lastID {
+		return nil, l.lastID, false, l.changed
+	}
+
+	var events []loggedEvent
+	for _, e := range l.events[afterID-firstID+1:] {
+		if containsID(e.userIDs, userID) {
+			events = append(events, e)
+		}
+	}
+
+	return events, l.lastID, true, l.changed
+}
+
+func (l *eventLog) last() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.lastID
+}
+
+// close ends the streams.
+func (l *eventLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-l.closed:
+	default:
+		close(l.closed)
+	}
+}
+
+// tokenFromQuery takes the access token, or the API key, from the access_token query parameter if the request does
+// not have an Authorization header, see RFC 6750 section 2.3.
+func (h *Handler) tokenFromQuery(next http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(f)
+}
+
+// streamEvents streams the events of the messages of the user as Server-Sent Events, until the client goes away.
+// A client resumes the stream with the Last-Event-ID header, which the browsers send on reconnecting. A client
+// that has missed more events than the log keeps gets a reset event instead.
+func (h *Handler) streamEvents(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int64)
+
+	rc := http.NewResponseController(w)
+
+	// The stream outlives the timeouts of the server. Each write has its own deadline instead, so a stuck client
+	// does not hold the stream forever.
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "stream events"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	cursor := h.eventLog.last()
+	if val := r.Header.Get("Last-Event-ID"); val != "" {
+		// An invalid ID is older than the log, so it leads to a reset.
+		cursor, _ = strconv.ParseInt(val, 10, 64)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	// Keep nginx from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		events, lastID, complete, changed := h.eventLog.since(userID, cursor)
+
+		if err := rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout)); err != nil {
+			return
+		}
+
+		if !complete {
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: {}\n\n", lastID, eventReset)
+		}
+
+		for _, e := range events {
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.id, e.typ, e.data)
+		}
+
+		// The writes fail along with the flush.
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		cursor = lastID
+
+		select {
+		case <-changed:
+		case <-keepAlive.C:
+			if err := rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout)); err != nil {
+				return
+			}
+
+			// A comment, which the clients ignore.
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-h.eventLog.closed:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/jwt"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+// lastUsedInterval is how often the last used time of a session is written.
+const lastUsedInterval = time.Minute
+
+var (
+	errInvalidToken = errors.New("invalid token")
+	errExpiredToken = errors.New("expired token")
+)
+
+// verifyToken returns the session of the access token.
+//
+// In the opaque token mode, the access token is looked up in the TokenStore.
+// In the signed token mode, the access token is verified without the store, so a revoked session
+// is only rejected before its access token expires if there is a deny list.
+func (h *Handler) verifyToken(ctx context.Context, accessToken string, now time.Time) (*store.Token, error) {
+	if h.signer != nil {
+		return h.verifySignedToken(ctx, accessToken, now)
+	}
+
+	tokenHash := h.hashToken(accessToken)
+
+	session, err := h.store.Token().GetUserID(ctx, tokenHash)
+	if err == store.ErrNotFound {
+		return nil, errInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	if now.After(session.ExpiresAt) || h.sessionExpired(session, now) {
+		return nil, errExpiredToken
+	}
+
+	// The last used time is only needed for the idle timeout, so it is not written on every request.
+	if now.Sub(session.LastUsedAt) >= lastUsedInterval {
+		// Failing to record the last used time should not fail the request.
+		if err := h.store.Token().Touch(ctx, tokenHash, now); err != nil {
+			h.logger.Printf("ERROR: %v", err)
+		}
+	}
+
+	return session, nil
+}
+
+func (h *Handler) verifySignedToken(ctx context.Context, accessToken string, now time.Time) (*store.Token, error) {
+	claims, err := h.signer.Verify(accessToken, now)
+	if err == jwt.ErrExpiredToken {
+		return nil, errExpiredToken
+	} else if err != nil {
+		return nil, errInvalidToken
+	}
+
+	if h.denyList != nil {
+		revoked, err := h.denyList.IsRevoked(ctx, claims.SessionID)
+		if err != nil {
+			return nil, err
+		}
+
+		if revoked {
+			return nil, errInvalidToken
+		}
+	}
+
+	session := &store.Token{
+		ID:        claims.SessionID,
+		UserID:    claims.UserID,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}
+
+	return session, nil
+}
+
+// sessionExpired reports whether the session reached the idle timeout or the maximum lifetime.
+func (h *Handler) sessionExpired(session *store.Token, now time.Time) bool {
+	if h.sessionIdleTimeout > 0 && now.Sub(session.LastUsedAt) > h.sessionIdleTimeout {
+		return true
+	}
+
+	return h.sessionMaxLifetime > 0 && now.Sub(session.CreatedAt) > h.sessionMaxLifetime
+}
+
+// expiry returns the expiry time of a token issued now for the session, which is never after the end of the maximum lifetime.
+func (h *Handler) expiry(session *store.Token, now time.Time, lifetime time.Duration) time.Time {
+	expiresAt := now.Add(lifetime)
+
+	if h.sessionMaxLifetime > 0 {
+		if end := session.CreatedAt.Add(h.sessionMaxLifetime); end.Before(expiresAt) {
+			return end
+		}
+	}
+
+	return expiresAt
+}
+
+// accessToken returns the access token given to the client for the session's current token.
+// In the signed token mode, it is a signed token that refers to the stored hash of the token, otherwise it is the token itself.
+func (h *Handler) accessToken(session *store.Token, token string, now time.Time) (string, error) {
+	if h.signer == nil {
+		return token, nil
+	}
+
+	return h.signer.Sign(jwt.Claims{
+		ID:        h.hashToken(token),
+		UserID:    session.UserID,
+		SessionID: session.ID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: h.expiry(session, now, h.accessTokenLifetime).Unix(),
+	})
+}
+
+// denySessions puts the sessions on the deny list, so their signed access tokens are rejected right away.
+// It does nothing if there is no deny list.
+func (h *Handler) denySessions(ctx context.Context, sessionIDs ...int64) error {
+	if h.denyList == nil {
+		return nil
+	}
+
+	// An access token issued right now for the session would be the last one to expire.
+	until := time.Now().Add(h.accessTokenLifetime)
+
+	for _, id := range sessionIDs {
+		if err := h.denyList.Revoke(ctx, id, until); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hashToken returns the keyed hash of an access or refresh token, which is what the store keeps.
+// A leaked database does not reveal the tokens, and the hashes cannot be computed without the secret.
+func (h *Handler) hashToken(token string) string {
+	mac := hmac.New(sha256.New, h.tokenHashSecret)
+	mac.Write([]byte(token))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
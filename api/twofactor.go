@@ -0,0 +1,551 @@
+package api
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/totp"
+	"github.com/pkg/errors"
+)
+
+const (
+	// challengeLifetime is how long the user has to enter the second factor after the password.
+	challengeLifetime = 5 * time.Minute
+
+	// totpSkew accepts the codes of a time step before and after the current one.
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+)
+
+var errTwoFactorDisabled = errors.New("two-factor authentication is disabled")
+
+// twoFactorEnabled reports whether the user has to enter a second factor to login.
+func (h *Handler) twoFactorEnabled(ctx context.Context, userID int64) (bool, error) {
+	t, err := h.store.TwoFactor().GetTOTP(ctx, userID)
+	if err == store.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return t.ConfirmedAt != nil, nil
+}
+
+type challengeResponse struct {
+	TwoFactorRequired bool      `json:"two_factor_required"`
+	ChallengeToken    string    `json:"challenge_token"`
+	ExpireTime        time.Time `json:"expire_time"`
+}
+
+// createChallenge starts a login that is completed by the second factor.
+func (h *Handler) createChallenge(ctx context.Context, userID int64, now time.Time) (*challengeResponse, error) {
+	token, err := newToken(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.store.TwoFactor().CreateChallenge(ctx, h.hashToken(token), store.LoginChallenge{
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(challengeLifetime),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &challengeResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+		ExpireTime:        now.Add(challengeLifetime),
+	}
+
+	return res, nil
+}
+
+// loginTwoFactor completes a login with a TOTP code or a recovery code. The failed codes count towards a lockout
+// of the user, otherwise the 6 digits codes could be guessed.
+func (h *Handler) loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.ChallengeToken == "" {
+		renderError(w, http.StatusBadRequest, "challenge_token is empty")
+		return
+	}
+
+	if req.Code == "" && req.RecoveryCode == "" {
+		renderError(w, http.StatusBadRequest, "code is empty")
+		return
+	}
+
+	challengeHash := h.hashToken(req.ChallengeToken)
+
+	challenge, err := h.store.TwoFactor().GetChallenge(r.Context(), challengeHash)
+	if err == store.ErrNotFound {
+		renderError(w, http.StatusUnauthorized, "Invalid challenge token")
+		return
+	} else if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "login two-factor"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	now := time.Now()
+
+	if now.After(challenge.ExpiresAt) {
+		renderError(w, http.StatusUnauthorized, "Expired challenge token")
+		return
+	}
+
+	lockoutKey := "2fa:" + strconv.FormatInt(challenge.UserID, 10)
+
+	lockouts, err := h.getLockouts(r, lockoutKey, h.usernameLockout)
+	if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "login two-factor"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d := retryAfter(lockouts, now)
+	if d == 0 {
+		d, err = h.reserveLogin(r.Context(), lockouts, now)
+		if err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "login two-factor"))
+
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	if d > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(d.Seconds()+0.999)))
+
+		renderError(w, http.StatusTooManyRequests, "too many failed codes, try again later")
+		return
+	}
+
+	var ok bool
+	if req.RecoveryCode != "" {
+		ok, err = h.useRecoveryCode(r.Context(), challenge.UserID, req.RecoveryCode)
+	} else {
+		ok, err = h.useTOTPCode(r.Context(), challenge.UserID, req.Code, now)
+	}
+	if err != nil {
+		h.releaseLogin(r.Context(), lockouts, now)
+	}
+
+	if err == errTwoFactorDisabled {
+		// The secrets cannot be decrypted without the key, only the recovery codes work.
+		h.logger.Printf("ERROR: login two-factor: the TOTP code of user %d cannot be checked without -totp_encryption_secret", challenge.UserID)
+
+		renderError(w, http.StatusServiceUnavailable, "two-factor authentication codes are unavailable, use a recovery code")
+		return
+	} else if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "login two-factor"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !ok {
+		h.addLoginFailure(lockouts)
+
+		renderError(w, http.StatusUnauthorized, "invalid code")
+		return
+	}
+
+	h.releaseLogin(r.Context(), lockouts, now)
+
+	// Another request may have completed the same challenge since it was read.
+	err = h.store.TwoFactor().DeleteChallenge(r.Context(), challengeHash)
+	if err == store.ErrNotFound {
+		renderError(w, http.StatusUnauthorized, "Invalid challenge token")
+		return
+	} else if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "login two-factor"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.unlockLogin(r.Context(), lockoutKey, "a successful login")
+
+	// The password was right, but its failed logins are only forgotten now that the login is complete.
+	if user, err := h.store.User().GetByID(r.Context(), challenge.UserID); err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "login two-factor"))
+	} else {
+		h.unlockLogin(r.Context(), usernameLockoutKey(user.Username), "a successful login")
+	}
+
+	res, err := h.startSession(r, challenge.UserID, now)
+	if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "login two-factor"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	render(w, http.StatusOK, res)
+}
+
+// useTOTPCode reports whether the code of the user's confirmed TOTP secret is right, and has not been used before.
+func (h *Handler) useTOTPCode(ctx context.Context, userID int64, code string, now time.Time) (bool, error) {
+	t, err := h.store.TwoFactor().GetTOTP(ctx, userID)
+	if err == store.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if t.ConfirmedAt == nil {
+		return false, nil
+	}
+
+	secret, err := h.decryptTOTPSecret(userID, t.Secret)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := totp.Verify(secret, code, now, totpSkew)
+	if !ok {
+		return false, nil
+	}
+
+	err = h.store.TwoFactor().UseTOTPStep(ctx, userID, step)
+	if err == store.ErrNotFound {
+		// The code, or a later one, has been used.
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (h *Handler) useRecoveryCode(ctx context.Context, userID int64, code string) (bool, error) {
+	err := h.store.TwoFactor().UseRecoveryCode(ctx, userID, h.hashToken(normalizeRecoveryCode(code)))
+	if err == store.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// enrollTOTP creates a new TOTP secret for the user. The two-factor authentication is enabled once the secret is
+// confirmed with a code, so a mistake while setting up the authenticator app does not lock the user out.
+func (h *Handler) enrollTOTP() http.HandlerFunc {
+	type response struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.totpKey == nil {
+			renderError(w, http.StatusNotImplemented, errTwoFactorDisabled.Error())
+			return
+		}
+
+		userID := r.Context().Value("user_id").(int64)
+
+		enabled, err := h.twoFactorEnabled(r.Context(), userID)
+		if err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "enroll totp"))
+
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if enabled {
+			renderError(w, http.StatusConflict, "two-factor authentication is already enabled")
+			return
+		}
+
+		user, err := h.store.User().GetByID(r.Context(), userID)
+		if err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "enroll totp"))
+
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		secret, err := totp.NewSecret()
+		if err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "enroll totp"))
+
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		encrypted, err := h.encryptTOTPSecret(userID, secret)
+		if err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "enroll totp"))
+
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		err = h.store.TwoFactor().SetTOTP(r.Context(), store.TOTP{
+			UserID:    userID,
+			Secret:    encrypted,
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "enroll totp"))
+
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		render(w, http.StatusCreated, response{
+			Secret: totp.EncodeSecret(secret),
+			URI:    totp.URI(h.totpIssuer, user.Username, secret),
+		})
+	}
+}
+
+// confirmTOTP enables the two-factor authentication with a code of the enrolled secret,
+// and returns the recovery codes. The recovery codes are only shown this time.
+func (h *Handler) confirmTOTP() http.HandlerFunc {
+	type request struct {
+		Code string `json:"code"`
+	}
+
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			renderError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if req.Code == "" {
+			renderError(w, http.StatusBadRequest, "code is empty")
+			return
+		}
+
+		userID := r.Context().Value("user_id").(int64)
+
+		t, err := h.store.TwoFactor().GetTOTP(r.Context(), userID)
+		if err == store.ErrNotFound {
+			renderError(w, http.StatusBadRequest, "no two-factor authentication to confirm")
+			return
+		} else if err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "confirm totp"))
+
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if t.ConfirmedAt != nil {
+			renderError(w, http.StatusConflict, "two-factor authentication is already enabled")
+			return
+		}
+
+		secret, err := h.decryptTOTPSecret(userID, t.Secret)
+		if err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "confirm totp"))
+
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		now := time.Now()
+
+		step, ok := totp.Verify(secret, req.Code, now, totpSkew)
+		if !ok {
+			renderError(w, http.StatusBadRequest, "invalid code")
+			return
+		}
+
+		codes, hashes, err := h.newRecoveryCodes()
+		if err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "confirm totp"))
+
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if err := h.store.TwoFactor().SetRecoveryCodes(r.Context(), userID, hashes); err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "confirm totp"))
+
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// Another request may have confirmed, or replaced, the secret since it was read.
+		err = h.store.TwoFactor().ConfirmTOTP(r.Context(), userID, now)
+		if err == store.ErrNotFound {
+			renderError(w, http.StatusConflict, "two-factor authentication is already enabled")
+			return
+		} else if err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "confirm totp"))
+
+			renderError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// The code cannot be used again to login.
+		if err := h.store.TwoFactor().UseTOTPStep(r.Context(), userID, step); err != nil && err != store.ErrNotFound {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, "confirm totp"))
+		}
+
+		render(w, http.StatusOK, response{RecoveryCodes: codes})
+	}
+}
+
+// disableTOTP turns off the two-factor authentication, which needs the password of the user.
+func (h *Handler) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Password string `json:"password"`
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Password == "" {
+		renderError(w, http.StatusBadRequest, "password is empty")
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int64)
+
+	user, err := h.store.User().GetByID(r.Context(), userID)
+	if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "disable totp"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ok, err := h.checkPassword(user, req.Password)
+	if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "disable totp"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !ok {
+		renderError(w, http.StatusForbidden, "invalid password")
+		return
+	}
+
+	err = h.store.TwoFactor().DeleteTOTP(r.Context(), userID)
+	if err != nil && err != store.ErrNotFound {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "disable totp"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// recoveryCodeEncoding writes the recovery codes in lower case letters and the digits 2 to 7, so they are
+// typed without the shift key. The digits 0 and 1 are left out, as they look like o and l.
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns the recovery codes to show to the user, and their hashes to keep in the store.
+// A code is 10 characters, that is 50 random bits, written as xxxxx-xxxxx.
+func (h *Handler) newRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		var b [7]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return nil, nil, err
+		}
+
+		code := recoveryCodeEncoding.EncodeToString(b[:])[:10]
+
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, h.hashToken(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts a recovery code typed in upper case, or without the dash.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	code = strings.ReplaceAll(code, " ", "")
+
+	return code
+}
+
+// encryptTOTPSecret encrypts the secret with AES-GCM. The user ID is authenticated along with the secret,
+// so a secret copied to another user does not decrypt.
+func (h *Handler) encryptTOTPSecret(userID int64, secret []byte) ([]byte, error) {
+	aead, err := h.totpAEAD()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(secret)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, secret, []byte(strconv.FormatInt(userID, 10))), nil
+}
+
+func (h *Handler) decryptTOTPSecret(userID int64, encrypted []byte) ([]byte, error) {
+	aead, err := h.totpAEAD()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(encrypted) < aead.NonceSize() {
+		return nil, errors.New("invalid encrypted totp secret")
+	}
+
+	nonce, ciphertext := encrypted[:aead.NonceSize()], encrypted[aead.NonceSize():]
+
+	secret, err := aead.Open(nil, nonce, ciphertext, []byte(strconv.FormatInt(userID, 10)))
+	if err != nil {
+		return nil, errors.WithMessage(err, "decrypt totp secret")
+	}
+
+	return secret, nil
+}
+
+func (h *Handler) totpAEAD() (cipher.AEAD, error) {
+	if h.totpKey == nil {
+		return nil, errTwoFactorDisabled
+	}
+
+	block, err := aes.NewCipher(h.totpKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/mail"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+	"github.com/pkg/errors"
+)
+
+const (
+	emailVerificationLifetime = 24 * time.Hour
+
+	// emailMaxLength is the longest address that can be delivered, see RFC 5321.
+	emailMaxLength = 254
+)
+
+// EmailVerification is what a user cannot do until the email address is verified.
+type EmailVerification int
+
+const (
+	// EmailVerificationOptional lets the users do everything without a verified email address.
+	EmailVerificationOptional EmailVerification = iota
+	// EmailVerificationForSending does not let the users send messages until the email address is verified.
+	EmailVerificationForSending
+	// EmailVerificationForLogin does not let the users login until the email address is verified.
+	EmailVerificationForLogin
+)
+
+var errEmailNotVerified = errors.New("email address is not verified")
+
+// emailVerified reports whether the user can do something that the policy v, or a stricter one, restricts to
+// the verified email addresses.
+func (h *Handler) emailVerified(user *store.User, v EmailVerification) bool {
+	return h.emailVerification < v || user.VerifiedAt != nil
+}
+
+// normalizeEmail returns the email address lower case and trimmed, along with its violations.
+func normalizeEmail(field, email string) (string, []fieldError) {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	if email == "" {
+		return "", []fieldError{{Field: field, Message: "email is empty"}}
+	}
+
+	// Only a bare address is accepted, not e.g. "Name <user@example.com>".
+	addr, err := netmail.ParseAddress(email)
+	if err != nil || addr.Address != email || len(email) > emailMaxLength {
+		return "", []fieldError{{Field: field, Message: "email is not a valid address"}}
+	}
+
+	return email, nil
+}
+
+// mailTimeout limits the emails sent after the response.
+const mailTimeout = time.Minute
+
+// sendInBackground runs send after the response, so the time of the response does not tell whether an email is sent,
+// and so whether the user exists. The errors are only logged, as the client has its response already.
+func (h *Handler) sendInBackground(what string, send func(ctx context.Context) error) {
+	h.mails.Add(1)
+
+	go func() {
+		defer h.mails.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+
+		if err := send(ctx); err != nil {
+			h.logger.Printf("ERROR: %v", errors.WithMessage(err, what))
+		}
+	}()
+}
+
+// sendEmailVerification sends a verification token to the email address of the user. It does nothing without a mailer.
+func (h *Handler) sendEmailVerification(ctx context.Context, userID int64, email string) error {
+	if h.mailer == nil {
+		return nil
+	}
+
+	token, err := newToken(userID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	err = h.store.EmailVerification().Create(ctx, h.hashToken(token), store.EmailVerificationToken{
+		UserID:    userID,
+		Email:     email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(emailVerificationLifetime),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := h.baseURL + "/verify?token=" + url.QueryEscape(token)
+
+	return h.mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Open this link to verify your email address, it expires in %v:\n\n%s\n\n"+
+			"If you did not register, ignore this email.", emailVerificationLifetime, link),
+	})
+}
+
+// verifyEmail marks the email address a verification token is sent to as verified. A token can be used only once,
+// and only while the user still has the same address.
+func (h *Handler) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		renderError(w, http.StatusBadRequest, "token is empty")
+		return
+	}
+
+	tokenHash := h.hashToken(token)
+
+	verification, err := h.store.EmailVerification().Get(r.Context(), tokenHash)
+	if err == store.ErrNotFound {
+		renderError(w, http.StatusBadRequest, "Invalid verification token")
+		return
+	} else if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "verify email"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	now := time.Now()
+
+	if verification.UsedAt != nil {
+		renderError(w, http.StatusBadRequest, "Invalid verification token")
+		return
+	}
+
+	if now.After(verification.ExpiresAt) {
+		renderError(w, http.StatusBadRequest, "Expired verification token")
+		return
+	}
+
+	// Another request may have used the same token since it was read.
+	err = h.store.EmailVerification().MarkUsed(r.Context(), tokenHash, now)
+	if err == store.ErrNotFound {
+		renderError(w, http.StatusBadRequest, "Invalid verification token")
+		return
+	} else if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "verify email"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	err = h.store.User().VerifyEmail(r.Context(), verification.UserID, verification.Email, now)
+	if err == store.ErrNotFound {
+		// The user has changed the address since the token was sent.
+		renderError(w, http.StatusBadRequest, "Invalid verification token")
+		return
+	} else if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "verify email"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resendEmailVerification sends a new verification token to the unverified email address of the user.
+// The response is the same whether the user exists or not, so it does not tell which usernames exist.
+func (h *Handler) resendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Username string `json:"username"`
+	}
+
+	if h.mailer == nil {
+		renderError(w, http.StatusNotImplemented, "email verification is disabled")
+		return
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Username == "" {
+		renderError(w, http.StatusBadRequest, "username is empty")
+		return
+	}
+
+	username := strings.ToLower(strings.TrimSpace(req.Username))
+
+	user, err := h.store.User().GetByUsername(r.Context(), username)
+	if err == store.ErrNotFound {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	} else if err != nil {
+		h.logger.Printf("ERROR: %v", errors.WithMessage(err, "resend email verification"))
+
+		renderError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if user.Email == "" || user.VerifiedAt != nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	h.sendInBackground("resend email verification", func(ctx context.Context) error {
+		return h.sendEmailVerification(ctx, user.ID, user.Email)
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+}
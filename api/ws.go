@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsWriteTimeout is how long a client has to take a message before it is disconnected.
+	wsWriteTimeout = 10 * time.Second
+
+	// wsPongTimeout is how long a client has to answer a ping before it is disconnected.
+	wsPongTimeout = 60 * time.Second
+
+	// wsPingInterval must be shorter than wsPongTimeout, so a pong is expected before the read deadline.
+	wsPingInterval = wsPongTimeout * 9 / 10
+
+	// wsSendBuffer is how many events can wait for a client. A client that falls further behind is disconnected.
+	wsSendBuffer = 64
+
+	// wsMaxMessageSize limits what a client can send, as the clients only have to answer the pings.
+	wsMaxMessageSize = 512
+)
+
+// event is pushed to the recipients of a message when it is sent, updated or deleted.
+type event struct {
+	Type    string          `json:"type"`
+	Message json.RawMessage `json:"message"`
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// wsClient is a connection of a user. Only the hub closes send.
+type wsClient struct {
+	userID int64
+	conn   *websocket.Conn
+	send   chan []byte
+}
+
+// wsHub keeps the connected clients by user.
+type wsHub struct {
+	mu      sync.Mutex
+	clients map[int64]map[*wsClient]struct{}
+}
+
+func newWSHub() *wsHub {
+	return &wsHub{
+		clients: make(map[int64]map[*wsClient]struct{}),
+	}
+}
+
+func (hub *wsHub) register(c *wsClient) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if hub.clients[c.userID] == nil {
+		hub.clients[c.userID] = make(map[*wsClient]struct{})
+	}
+
+	hub.clients[c.userID][c] = struct{}{}
+}
+
+func (hub *wsHub) unregister(c *wsClient) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	hub.remove(c)
+}
+
+// remove closes the send channel of the client, which makes its writer close the connection.
+// It must be called with mu held.
+func (hub *wsHub) remove(c *wsClient) {
+	clients, ok := hub.clients[c.userID]
+	if !ok {
+		return
+	}
+
+	if _, ok := clients[c]; !ok {
+		return
+	}
+
+	delete(clients, c)
+	if len(clients) == 0 {
+		delete(hub.clients, c.userID)
+	}
+
+	close(c.send)
+}
+
+// publish sends the data to every client of the users. It never blocks, a client whose buffer is full is disconnected.
+func (hub *wsHub) publish(userIDs []int64, data []byte) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	for _, userID := range userIDs {
+		for c := range hub.clients[userID] {
+			select {
+			case c.send <- data:
+			default:
+				hub.remove(c)
+			}
+		}
+	}
+}
+
+// close disconnects every client.
+func (hub *wsHub) close() {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	for _, clients := range hub.clients {
+		for c := range clients {
+			hub.remove(c)
+		}
+	}
+}
+
+// serveWS upgrades the request to a WebSocket connection, which receives the events of the messages of the user.
+// The client is not expected to send anything but the pongs.
+func (h *Handler) serveWS(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int64)
+
+	// Upgrade has replied to the client on failure.
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	c := &wsClient{
+		userID: userID,
+		conn:   conn,
+		send:   make(chan []byte, wsSendBuffer),
+	}
+
+	h.wsHub.register(c)
+
+	go c.writeEvents()
+
+	c.readPongs()
+
+	h.wsHub.unregister(c)
+}
+
+// readPongs reads until the connection fails, or the client goes away or stops answering the pings.
+func (c *wsClient) readPongs() {
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	_ = c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	for {
+		if _, _, err := c.conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// writeEvents writes the events and the pings until the hub closes send, or the connection fails.
+// It is the only writer of the connection, and closes it.
+func (c *wsClient) writeEvents() {
+	ticker := time.NewTicker(wsPingInterval)
+
+	defer func() {
+		ticker.Stop()
+		_ = c.conn.Close()
+	}()
+
+	for {
+		select {
+		case data, ok := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+
+			if !ok {
+				// The client is too slow, it has gone away, or the server is shutting down.
+				_ = c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+				return
+			}
+
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
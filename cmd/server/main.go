@@ -12,8 +12,18 @@ import (
 	"time"
 
 	"github.com/ahmadmuzakkir/go-sample-api-server-structure/api"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/events"
+	memoryevents "github.com/ahmadmuzakkir/go-sample-api-server-structure/events/memory"
+	mysqlevents "github.com/ahmadmuzakkir/go-sample-api-server-structure/events/mysql"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/jwt"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/mail"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/oidc"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/outbox"
 	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store/memory"
 	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store/mysql"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store/postgres"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store/sqlite"
 	"github.com/ahmadmuzakkir/go-sample-api-server-structure/version"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -26,44 +36,358 @@ var (
 	logger = log.New(os.Stdout, "", log.LstdFlags|log.LUTC)
 )
 
+// minTokenHashSecretLength is the shortest token_hash_secret accepted with a persistent store.
+const minTokenHashSecretLength = 32
+
 func main() {
 	fmt.Println("version.BuildTime:\t", version.BuildTime)
 	fmt.Println("version.Commit:\t", version.Commit)
 	fmt.Println("version.Release:\t", version.Release)
 
 	portFlag := flag.Int("port", 8001, "port, default is 8001")
+	dbDriverFlag := flag.String("db_driver", "mysql", "Database driver, either mysql, postgres, sqlite or memory, default is mysql")
 	dbHostFlag := flag.String("db_host", "127.0.0.1", "Database host, default is 127.0.0.1")
 	dbPortFlag := flag.Int("db_port", 3306, "Database port, default is 3306")
 	dbUserFlag := flag.String("db_user", "root", "Database user, default is root")
 	dbPasswordFlag := flag.String("db_password", "12345", "Database password, default is 123456")
 	dbNameFlag := flag.String("db_name", "go_sample_api_server_structure", "Database name, default is go_sample_api_server_structure")
+	dbSSLModeFlag := flag.String("db_sslmode", "disable", "Postgres sslmode, default is disable")
+	dbPathFlag := flag.String("db_path", "go_sample_api_server_structure.db", "SQLite database file, default is go_sample_api_server_structure.db")
+	autoMigrateFlag := flag.Bool("auto_migrate", false, "Apply the pending database migrations on startup, default is false")
+	accessTokenLifetimeFlag := flag.Duration("access_token_lifetime", 15*time.Minute, "Lifetime of an access token, default is 15m")
+	refreshTokenLifetimeFlag := flag.Duration("refresh_token_lifetime", 30*24*time.Hour, "Lifetime of a refresh token, default is 720h")
+	sessionIdleTimeoutFlag := flag.Duration("session_idle_timeout", 7*24*time.Hour, "End a session that is not used for this long, 0 means no idle timeout, default is 168h")
+	sessionMaxLifetimeFlag := flag.Duration("session_max_lifetime", 90*24*time.Hour, "End a session this long after the login, 0 means no maximum, default is 2160h")
+	tokenHashSecretFlag := flag.String("token_hash_secret", "", "Secret of the keyed hash of the tokens kept in the database, at least 32 bytes, may only be empty with the memory db_driver")
+	tokenModeFlag := flag.String("token_mode", "opaque", "Access token mode, either opaque or signed, default is opaque")
+	tokenSigningKeyFlag := flag.String("token_signing_key", "", "Key file to sign the access tokens in the signed token mode, either an Ed25519 PEM key or a HMAC secret")
+	tokenSigningKeyIDFlag := flag.String("token_signing_key_id", "1", "Key ID of token_signing_key, default is 1")
+	tokenVerifyKeysFlag := flag.String("token_verify_keys", "", "Comma separated kid=path of the old keys that still verify the access tokens")
+	tokenDenyListFlag := flag.Bool("token_deny_list", false, "Reject the signed access tokens of the revoked sessions before they expire, default is false")
+	mailerFlag := flag.String("mailer", "log", "How the emails are delivered, either log, file, smtp or none, default is log")
+	mailDirFlag := flag.String("mail_dir", "mail", "Directory of the emails of the file mailer, default is mail")
+	smtpAddrFlag := flag.String("smtp_addr", "localhost:587", "host:port of the SMTP server of the smtp mailer, default is localhost:587")
+	smtpUsernameFlag := flag.String("smtp_username", "", "Username of the SMTP server, empty if it does not require authentication")
+	smtpPasswordFlag := flag.String("smtp_password", "", "Password of the SMTP server")
+	smtpFromFlag := flag.String("smtp_from", "", "Sender address of the emails of the smtp mailer")
+	emailVerificationFlag := flag.String("email_verification", "optional", "What requires a verified email address, either optional, send or login, default is optional")
+	baseURLFlag := flag.String("base_url", "http://localhost:8001", "Public URL of the API in the links of the emails, default is http://localhost:8001")
+	passwordHasherFlag := flag.String("password_hasher", "bcrypt", "Password hashing algorithm, either bcrypt or argon2id, default is bcrypt")
+	bcryptCostFlag := flag.Int("bcrypt_cost", bcrypt.DefaultCost, "Cost of bcrypt, default is 10")
+	argon2MemoryFlag := flag.Int("argon2_memory", int(api.DefaultArgon2idParams.Memory), "Memory of Argon2id in KiB, default is 65536")
+	argon2TimeFlag := flag.Int("argon2_time", int(api.DefaultArgon2idParams.Time), "Iterations of Argon2id, default is 3")
+	argon2ThreadsFlag := flag.Int("argon2_threads", int(api.DefaultArgon2idParams.Threads), "Parallelism of Argon2id, default is 4")
+	lockoutMaxFailuresFlag := flag.Int("lockout_max_failures", 5, "Failed logins of a username before it is locked out, 0 disables, default is 5")
+	lockoutIPMaxFailuresFlag := flag.Int("lockout_ip_max_failures", 20, "Failed logins from an IP address before it is locked out, 0 disables, default is 20")
+	lockoutDurationFlag := flag.Duration("lockout_duration", time.Minute, "First lockout, doubled on every failed login after that, default is 1m")
+	lockoutMaxDurationFlag := flag.Duration("lockout_max_duration", time.Hour, "Maximum lockout, default is 1h")
+	passwordMinLengthFlag := flag.Int("password_min_length", api.DefaultRegistrationPolicy.PasswordMinLength, "Minimum length of a password, default is 8")
+	passwordMinEntropyFlag := flag.Float64("password_min_entropy", api.DefaultRegistrationPolicy.PasswordMinEntropy, "Minimum estimated strength of a password in bits, default is 30")
+	totpEncryptionSecretFlag := flag.String("totp_encryption_secret", "", "Secret of the encryption of the TOTP secrets kept in the database, empty disables the two-factor authentication")
+	totpIssuerFlag := flag.String("totp_issuer", "go-sample-api-server-structure", "Name of the service shown by the authenticator apps, default is go-sample-api-server-structure")
+	oidcIssuerFlag := flag.String("oidc_issuer", "", "Issuer URL of the OpenID Connect provider, empty disables the login with the provider")
+	oidcClientIDFlag := flag.String("oidc_client_id", "", "Client ID registered at the OpenID Connect provider")
+	oidcClientSecretFlag := flag.String("oidc_client_secret", "", "Client secret registered at the OpenID Connect provider, empty for a public client")
+	oidcRedirectURLFlag := flag.String("oidc_redirect_url", "", "Public URL of /auth/oidc/callback registered at the OpenID Connect provider")
+	oidcAutoProvisionFlag := flag.Bool("oidc_auto_provision", false, "Create a user on the first login with the OpenID Connect provider, default is false")
+	eventsFlag := flag.String("events", "memory", "How the events of the messages are shared, either memory for a single server, or mysql for many servers of the mysql db_driver, default is memory")
+	eventsPollIntervalFlag := flag.Duration("events_poll_interval", 500*time.Millisecond, "How often the mysql events are polled, default is 500ms")
+	eventsSettleTimeFlag := flag.Duration("events_settle_time", time.Second, "How long a mysql event waits for the events with a lower ID still committing, default is 1s")
+	eventsRetentionFlag := flag.Duration("events_retention", time.Hour, "How long the mysql events are kept, default is 1h")
+	eventsFromOutboxFlag := flag.Bool("events_from_outbox", false, "Take the events from the outbox relay of the server with the events outbox_sink, rather than publishing them after the writes, default is false")
+	outboxSinkFlag := flag.String("outbox_sink", "none", "Where the outbox events of the messages are relayed, either log, file, webhook, events or none, default is none. Run a single relay for a database, the others with none")
+	outboxFileFlag := flag.String("outbox_file", "outbox.jsonl", "File the file sink appends the outbox events to, default is outbox.jsonl")
+	outboxWebhookURLFlag := flag.String("outbox_webhook_url", "", "URL the webhook sink POSTs the outbox events to")
+	outboxPollIntervalFlag := flag.Duration("outbox_poll_interval", time.Second, "How often the outbox is polled, default is 1s")
+	outboxSettleTimeFlag := flag.Duration("outbox_settle_time", time.Second, "How long an outbox event waits for the events with a lower ID that are still committing, default is 1s")
+	outboxRetentionFlag := flag.Duration("outbox_retention", 24*time.Hour, "How long the delivered outbox events are kept, default is 24h")
 	flag.Parse()
 
 	port := *portFlag
+	dbDriver := *dbDriverFlag
 	dbHost := *dbHostFlag
 	dbPort := *dbPortFlag
 	dbUser := *dbUserFlag
 	dbPassword := *dbPasswordFlag
 	dbName := *dbNameFlag
+	dbSSLMode := *dbSSLModeFlag
+	dbPath := *dbPathFlag
+	autoMigrate := *autoMigrateFlag
+	accessTokenLifetime := *accessTokenLifetimeFlag
+	refreshTokenLifetime := *refreshTokenLifetimeFlag
+	sessionIdleTimeout := *sessionIdleTimeoutFlag
+	sessionMaxLifetime := *sessionMaxLifetimeFlag
+	tokenHashSecret := *tokenHashSecretFlag
+	tokenMode := *tokenModeFlag
+	tokenSigningKey := *tokenSigningKeyFlag
+	tokenSigningKeyID := *tokenSigningKeyIDFlag
+	tokenVerifyKeys := *tokenVerifyKeysFlag
+	tokenDenyList := *tokenDenyListFlag
+	mailer := *mailerFlag
+	mailDir := *mailDirFlag
+	smtpAddr := *smtpAddrFlag
+	smtpUsername := *smtpUsernameFlag
+	smtpPassword := *smtpPasswordFlag
+	smtpFrom := *smtpFromFlag
+	emailVerificationName := *emailVerificationFlag
+	baseURL := *baseURLFlag
+	lockoutMaxFailures := *lockoutMaxFailuresFlag
+	lockoutIPMaxFailures := *lockoutIPMaxFailuresFlag
+	lockoutDuration := *lockoutDurationFlag
+	lockoutMaxDuration := *lockoutMaxDurationFlag
+	registrationPolicy := api.DefaultRegistrationPolicy
+	registrationPolicy.PasswordMinLength = *passwordMinLengthFlag
+	registrationPolicy.PasswordMinEntropy = *passwordMinEntropyFlag
+	totpEncryptionSecret := *totpEncryptionSecretFlag
+	totpIssuer := *totpIssuerFlag
+	oidcIssuer := *oidcIssuerFlag
+	oidcClientID := *oidcClientIDFlag
+	oidcClientSecret := *oidcClientSecretFlag
+	oidcRedirectURL := *oidcRedirectURLFlag
+	oidcAutoProvision := *oidcAutoProvisionFlag
+	eventsName := *eventsFlag
+	eventsPollInterval := *eventsPollIntervalFlag
+	eventsSettleTime := *eventsSettleTimeFlag
+	eventsRetention := *eventsRetentionFlag
+	eventsFromOutbox := *eventsFromOutboxFlag
+	outboxSinkName := *outboxSinkFlag
+	outboxFile := *outboxFileFlag
+	outboxWebhookURL := *outboxWebhookURLFlag
+	outboxPollInterval := *outboxPollIntervalFlag
+	outboxSettleTime := *outboxSettleTimeFlag
+	outboxRetention := *outboxRetentionFlag
+	passwordHasherName := *passwordHasherFlag
+	bcryptCost := *bcryptCostFlag
+	argon2Params := api.Argon2idParams{
+		Memory:  uint32(*argon2MemoryFlag),
+		Time:    uint32(*argon2TimeFlag),
+		Threads: uint8(*argon2ThreadsFlag),
+	}
+
+	var db store.Store
+	var err error
+
+	switch dbDriver {
+	case "mysql":
+		db, err = mysql.Connect(dbHost, dbPort, dbUser, dbPassword, dbName)
+		if err != nil {
+			panic(err)
+		}
+	case "postgres":
+		db, err = postgres.Connect(dbHost, dbPort, dbUser, dbPassword, dbName, dbSSLMode)
+		if err != nil {
+			panic(err)
+		}
+	case "sqlite":
+		db, err = sqlite.Connect(dbPath)
+		if err != nil {
+			panic(err)
+		}
+	case "memory":
+		// Everything is lost when the server stops, useful for local development.
+		db = memory.New()
+	default:
+		panic(fmt.Sprintf("unknown db_driver %q", dbDriver))
+	}
+
+	// The migrate subcommand, e.g. `server migrate up`, runs the migrations then exits.
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(db, flag.Args()[1:]); err != nil {
+			fmt.Printf("error running migration: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Without the secret, a leaked database lets anyone use its tokens, so only the memory store may do without.
+	if dbDriver != "memory" && len(tokenHashSecret) < minTokenHashSecretLength {
+		panic(fmt.Sprintf("token_hash_secret must be at least %d bytes with the %s db_driver", minTokenHashSecretLength, dbDriver))
+	}
+
+	if autoMigrate {
+		if err := migrateUp(db); err != nil {
+			panic(fmt.Sprintf("error applying migrations: %v", err))
+		}
+	}
+
+	var passwordHasher api.PasswordHasher
 
-	db, err := mysql.Connect(dbHost, dbPort, dbUser, dbPassword, dbName)
+	switch passwordHasherName {
+	case "bcrypt":
+		passwordHasher, err = api.NewBcryptHasher(bcryptCost)
+	case "argon2id":
+		passwordHasher, err = api.NewArgon2idHasher(argon2Params)
+	default:
+		err = fmt.Errorf("unknown password_hasher %q", passwordHasherName)
+	}
 	if err != nil {
 		panic(err)
 	}
 
 	// For testing purpose add users
-	err = addUser(db.User(), "username1", "password1")
-	err = addUser(db.User(), "username2", "password2")
+	err = addUser(db.User(), passwordHasher, "username1", "password1")
+	err = addUser(db.User(), passwordHasher, "username2", "password2")
 	if err != nil {
 		panic(fmt.Sprintf("error adding user %v", err))
 	}
 
-	apiHandler := api.NewHandler(db, logger)
+	apiOptions := []api.Option{
+		api.WithAccessTokenLifetime(accessTokenLifetime),
+		api.WithRefreshTokenLifetime(refreshTokenLifetime),
+		api.WithSessionIdleTimeout(sessionIdleTimeout),
+		api.WithSessionMaxLifetime(sessionMaxLifetime),
+		api.WithTokenHashSecret([]byte(tokenHashSecret)),
+		api.WithBaseURL(baseURL),
+		api.WithPasswordHasher(passwordHasher),
+		api.WithRegistrationPolicy(registrationPolicy),
+		api.WithUsernameLockout(api.LockoutPolicy{
+			MaxFailures: lockoutMaxFailures,
+			Lockout:     lockoutDuration,
+			MaxLockout:  lockoutMaxDuration,
+			ResetAfter:  24 * time.Hour,
+		}),
+		api.WithIPLockout(api.LockoutPolicy{
+			MaxFailures: lockoutIPMaxFailures,
+			Lockout:     lockoutDuration,
+			MaxLockout:  lockoutMaxDuration,
+			ResetAfter:  24 * time.Hour,
+		}),
+	}
+
+	switch tokenMode {
+	case "opaque":
+	case "signed":
+		signer, err := newTokenSigner(tokenSigningKeyID, tokenSigningKey, tokenVerifyKeys)
+		if err != nil {
+			panic(fmt.Sprintf("error loading token keys: %v", err))
+		}
+
+		apiOptions = append(apiOptions, api.WithTokenSigner(signer))
+
+		if tokenDenyList {
+			apiOptions = append(apiOptions, api.WithDenyList(jwt.NewMemoryDenyList()))
+		}
+	default:
+		panic(fmt.Sprintf("unknown token_mode %q", tokenMode))
+	}
+
+	switch mailer {
+	case "none":
+	case "log":
+		apiOptions = append(apiOptions, api.WithMailer(mail.NewLogMailer(logger)))
+	case "file":
+		fileMailer, err := mail.NewFileMailer(mailDir)
+		if err != nil {
+			panic(fmt.Sprintf("error creating mail_dir: %v", err))
+		}
+
+		apiOptions = append(apiOptions, api.WithMailer(fileMailer))
+	case "smtp":
+		smtpMailer, err := mail.NewSMTPMailer(smtpAddr, smtpUsername, smtpPassword, smtpFrom)
+		if err != nil {
+			panic(fmt.Sprintf("error creating smtp mailer: %v", err))
+		}
+
+		apiOptions = append(apiOptions, api.WithMailer(smtpMailer))
+	default:
+		panic(fmt.Sprintf("unknown mailer %q", mailer))
+	}
+
+	switch emailVerificationName {
+	case "optional":
+		apiOptions = append(apiOptions, api.WithEmailVerification(api.EmailVerificationOptional))
+	case "send":
+		apiOptions = append(apiOptions, api.WithEmailVerification(api.EmailVerificationForSending))
+	case "login":
+		apiOptions = append(apiOptions, api.WithEmailVerification(api.EmailVerificationForLogin))
+	default:
+		panic(fmt.Sprintf("unknown email_verification %q", emailVerificationName))
+	}
+
+	if emailVerificationName != "optional" && mailer == "none" {
+		panic("email_verification requires a mailer, the users could never verify their email address")
+	}
+
+	if totpEncryptionSecret != "" {
+		apiOptions = append(apiOptions, api.WithTwoFactor([]byte(totpEncryptionSecret), totpIssuer))
+	}
+
+	if oidcIssuer != "" {
+		provider, err := oidc.Discover(context.Background(), oidcIssuer, oidc.Config{
+			ClientID:     oidcClientID,
+			ClientSecret: oidcClientSecret,
+			RedirectURL:  oidcRedirectURL,
+			Scopes:       []string{"email"},
+		})
+		if err != nil {
+			panic(fmt.Sprintf("error discovering the OpenID Connect provider: %v", err))
+		}
+
+		apiOptions = append(apiOptions, api.WithOIDC(provider, oidcAutoProvision))
+	}
+
+	var bus interface {
+		events.Publisher
+		events.Subscriber
+	}
+
+	switch eventsName {
+	case "memory":
+		bus = memoryevents.New()
+	case "mysql":
+		mysqlStore, ok := db.(*mysql.Store)
+		if !ok {
+			panic("events mysql requires the mysql db_driver")
+		}
+
+		bus = mysqlevents.New(mysqlStore.DB(), logger, eventsPollInterval, eventsSettleTime, eventsRetention)
+	default:
+		panic(fmt.Sprintf("unknown events %q", eventsName))
+	}
+
+	if eventsFromOutbox {
+		apiOptions = append(apiOptions, api.WithOutboxEvents(bus))
+	} else {
+		apiOptions = append(apiOptions, api.WithEvents(bus, bus))
+	}
+
+	var outboxSink outbox.Sink
+
+	switch outboxSinkName {
+	case "none":
+	case "log":
+		outboxSink = outbox.NewLogSink(logger)
+	case "file":
+		fileSink, err := outbox.NewFileSink(outboxFile)
+		if err != nil {
+			panic(fmt.Sprintf("error opening outbox_file: %v", err))
+		}
+		defer fileSink.Close()
+
+		outboxSink = fileSink
+	case "events":
+		if !eventsFromOutbox {
+			panic("outbox_sink events requires events_from_outbox, or the events are published twice")
+		}
+
+		outboxSink = outbox.NewEventsSink(bus)
+	case "webhook":
+		if outboxWebhookURL == "" {
+			panic("outbox_sink webhook requires outbox_webhook_url")
+		}
+
+		outboxSink = outbox.NewWebhookSink(outboxWebhookURL, nil)
+	default:
+		panic(fmt.Sprintf("unknown outbox_sink %q", outboxSinkName))
+	}
+
+	apiHandler := api.NewHandler(db, logger, apiOptions...)
 
 	router := chi.NewRouter()
 	router.Use(middleware.Recoverer)
 	router.Mount("/", apiHandler)
 
+	// The event streams and the WebSocket connections lift these timeouts for themselves.
 	srv := &http.Server{
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
@@ -71,6 +395,24 @@ func main() {
 		Handler:      router,
 	}
 
+	// The server does not close the WebSocket connections, which are hijacked, and waits for the event streams,
+	// which never end.
+	srv.RegisterOnShutdown(apiHandler.Close)
+
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	relayDone := make(chan struct{})
+
+	if outboxSink != nil {
+		relay := outbox.NewRelay(db.Outbox(), outboxSink, logger, outboxPollInterval, outboxSettleTime, outboxRetention)
+
+		go func() {
+			relay.Run(relayCtx)
+			close(relayDone)
+		}()
+	} else {
+		close(relayDone)
+	}
+
 	fmt.Printf("starting server on port %d\n", port)
 
 	go func() {
@@ -94,17 +436,24 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		fmt.Printf("error shutting down server: %v\n", err)
 	}
+
+	// Wait for the emails being sent, which Shutdown does not.
+	apiHandler.Close()
+
+	// The events written during the shutdown are relayed on the next start.
+	stopRelay()
+	<-relayDone
 }
 
-func addUser(us store.UserStore, username, password string) error {
+func addUser(us store.UserStore, hasher api.PasswordHasher, username, password string) error {
 	fmt.Printf("adding username %q with password %q\n", username, password)
 
-	defaultPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	defaultPassword, err := hasher.Hash(password)
 	if err != nil {
 		return err
 	}
 
-	err = us.Create(context.Background(), username, string(defaultPassword))
+	err = us.Create(context.Background(), username, defaultPassword)
 	if err != nil && err != store.ErrDuplicate {
 		return err
 	}
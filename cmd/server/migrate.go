@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+	"github.com/golang-migrate/migrate/v4"
+)
+
+// migrator is implemented by the stores that have embedded migrations.
+type migrator interface {
+	Migrate() (*migrate.Migrate, error)
+}
+
+// runMigrate runs the migrate subcommand, e.g. `server migrate up`.
+func runMigrate(db store.Store, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down|status|goto N")
+	}
+
+	m, err := newMigrate(db)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	switch args[0] {
+	case "up":
+		err = m.Up()
+	case "down":
+		err = m.Down()
+	case "goto":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: migrate goto N")
+		}
+
+		version, parseErr := strconv.ParseUint(args[1], 10, 64)
+		if parseErr != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+
+		err = m.Migrate(uint(version))
+	case "status":
+		version, dirty, err := m.Version()
+		if err == migrate.ErrNilVersion {
+			fmt.Println("no migration has been applied")
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		fmt.Printf("version: %d, dirty: %t\n", version, dirty)
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+
+	if err == migrate.ErrNoChange {
+		fmt.Println("no change")
+		return nil
+	}
+
+	return err
+}
+
+// migrateUp applies all the pending migrations.
+func migrateUp(db store.Store) error {
+	m, err := newMigrate(db)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		return err
+	}
+
+	return nil
+}
+
+func newMigrate(db store.Store) (*migrate.Migrate, error) {
+	mg, ok := db.(migrator)
+	if !ok {
+		return nil, fmt.Errorf("the store does not support migrations")
+	}
+
+	return mg.Migrate()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/jwt"
+)
+
+// newTokenSigner loads the signing key, and the verify keys which are a comma separated list of kid=path,
+// e.g. `2020-01=/keys/old.pem,2019-12=/keys/older.pem`.
+func newTokenSigner(keyID, keyPath, verifyKeys string) (*jwt.Signer, error) {
+	if keyPath == "" {
+		return nil, fmt.Errorf("token_signing_key is required in the signed token mode")
+	}
+
+	signingKey, err := jwt.LoadKey(keyID, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*jwt.Key
+
+	for _, v := range strings.Split(verifyKeys, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid verify key %q, the format is kid=path", v)
+		}
+
+		key, err := jwt.LoadKey(parts[0], parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return jwt.NewSigner(signingKey, keys...)
+}
@@ -0,0 +1,39 @@
+// Package events publishes the changes of the messages, for the features that push them to the users.
+// See the memory and the mysql packages for the implementations.
+package events
+
+import (
+	"context"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+// The types are those of the outbox events.
+const (
+	MessageCreated = store.EventMessageCreated
+	MessageUpdated = store.EventMessageUpdated
+	MessageDeleted = store.EventMessageDeleted
+)
+
+// Event is a change of a message.
+type Event struct {
+	Type    string         `json:"type"`
+	Message *store.Message `json:"message"`
+	// RecipientIDs are the recipients of the message. For MessageDeleted, they are the recipients before the deletion.
+	RecipientIDs []int64 `json:"recipient_ids"`
+	// PreviousRecipientIDs are the recipients before the update, for MessageUpdated only.
+	PreviousRecipientIDs []int64 `json:"previous_recipient_ids,omitempty"`
+}
+
+// Publisher publishes the events to the subscribers.
+type Publisher interface {
+	// Publish returns once the event is published, which does not mean that it is delivered.
+	Publish(ctx context.Context, e Event) error
+}
+
+// Subscriber delivers the events to the subscribers.
+type Subscriber interface {
+	// Subscribe returns the events published from now on by every publisher of the same medium, in the order they are
+	// published. The channel is closed when ctx is done. The subscriber must keep receiving, or it holds the publishers up.
+	Subscribe(ctx context.Context) <-chan Event
+}
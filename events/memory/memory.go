@@ -0,0 +1,75 @@
+// Package memory delivers the events within the process, so the subscribers only see the events of the same server.
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/events"
+)
+
+// subscriptionBuffer is how many events can wait for a subscriber before the publishers wait too.
+const subscriptionBuffer = 64
+
+var (
+	_ events.Publisher  = (*Bus)(nil)
+	_ events.Subscriber = (*Bus)(nil)
+)
+
+type subscription struct {
+	ch   chan events.Event
+	done <-chan struct{}
+}
+
+// Bus is both the publisher and the subscriber. The zero value is not usable, see New.
+type Bus struct {
+	// mu is held while publishing, so every subscriber gets the events in the same order.
+	mu            sync.Mutex
+	subscriptions map[*subscription]struct{}
+}
+
+func New() *Bus {
+	return &Bus{
+		subscriptions: make(map[*subscription]struct{}),
+	}
+}
+
+// Publish waits until every subscriber has room for the event, or ctx is done.
+func (b *Bus) Publish(ctx context.Context, e events.Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subscriptions {
+		select {
+		case s.ch <- e:
+		case <-s.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+func (b *Bus) Subscribe(ctx context.Context) <-chan events.Event {
+	s := &subscription{
+		ch:   make(chan events.Event, subscriptionBuffer),
+		done: ctx.Done(),
+	}
+
+	b.mu.Lock()
+	b.subscriptions[s] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.subscriptions, s)
+		close(s.ch)
+	}()
+
+	return s.ch
+}
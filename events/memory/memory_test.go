@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus(t *testing.T) {
+	bus := New()
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	sub1 := bus.Subscribe(ctx1)
+	sub2 := bus.Subscribe(ctx2)
+
+	for _, typ := range []string{events.MessageCreated, events.MessageUpdated, events.MessageDeleted} {
+		assert.NoError(t, bus.Publish(context.Background(), events.Event{Type: typ}))
+	}
+
+	// Every subscriber gets every event in order.
+	for _, sub := range []<-chan events.Event{sub1, sub2} {
+		for _, typ := range []string{events.MessageCreated, events.MessageUpdated, events.MessageDeleted} {
+			e := <-sub
+			assert.Equal(t, typ, e.Type)
+		}
+	}
+
+	cancel2()
+
+	_, ok := <-sub2
+	assert.False(t, ok, "closed when the context is done")
+
+	assert.NoError(t, bus.Publish(context.Background(), events.Event{Type: events.MessageCreated}))
+
+	e := <-sub1
+	assert.Equal(t, events.MessageCreated, e.Type)
+}
+
+func TestBusSlowSubscriber(t *testing.T) {
+	bus := New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sub := bus.Subscribe(ctx)
+
+	for i := 0; i < subscriptionBuffer; i++ {
+		assert.NoError(t, bus.Publish(context.Background(), events.Event{Type: events.MessageCreated}))
+	}
+
+	// The buffer is full, so the publisher waits.
+	publishCtx, publishCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer publishCancel()
+
+	assert.Equal(t, context.DeadlineExceeded, bus.Publish(publishCtx, events.Event{Type: events.MessageCreated}))
+
+	<-sub
+
+	assert.NoError(t, bus.Publish(context.Background(), events.Event{Type: events.MessageCreated}))
+}
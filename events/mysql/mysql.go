@@ -0,0 +1,189 @@
+// Package mysql shares the events between the servers through the events table of the MySQL store,
+// which every server polls.
+//
+// The IDs are given out on insert, so an event can commit after an event with a higher ID. An event after a missing
+// ID waits for it until the event is older than the settle time, as the IDs of the failed inserts are skipped for
+// good. An event that commits later than that is never sent, and the skipped IDs are logged. With MySQL's
+// auto_increment_increment above 1, every event waits for the settle time.
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/events"
+	"github.com/pkg/errors"
+)
+
+const (
+	// pollLimit is the most events fetched by a poll.
+	pollLimit = 1000
+
+	// cleanupInterval is how often a server deletes the events older than the retention.
+	cleanupInterval = time.Minute
+)
+
+var (
+	_ events.Publisher  = (*Bus)(nil)
+	_ events.Subscriber = (*Bus)(nil)
+)
+
+// payload is an event as kept in the table. The sender ID is not in the JSON of the message.
+type payload struct {
+	events.Event
+	SenderID int64 `json:"sender_id"`
+}
+
+// Bus is both the publisher and the subscriber.
+type Bus struct {
+	db     *sql.DB
+	logger *log.Logger
+
+	pollInterval time.Duration
+	settleTime   time.Duration
+	retention    time.Duration
+}
+
+// New returns a bus that polls db every pollInterval, waits up to settleTime for the missing IDs,
+// and keeps the events for the retention. db must have the events table, see the migrations of the MySQL store.
+func New(db *sql.DB, logger *log.Logger, pollInterval, settleTime, retention time.Duration) *Bus {
+	return &Bus{
+		db:           db,
+		logger:       logger,
+		pollInterval: pollInterval,
+		settleTime:   settleTime,
+		retention:    retention,
+	}
+}
+
+func (b *Bus) Publish(ctx context.Context, e events.Event) error {
+	p := payload{Event: e}
+	if e.Message != nil {
+		p.SenderID = e.Message.SenderID
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.db.ExecContext(ctx, "INSERT INTO events(type, payload) VALUES (?, ?)", e.Type, data)
+
+	return err
+}
+
+// Subscribe polls the table for the events inserted after the subscription. A failed poll is logged and retried.
+func (b *Bus) Subscribe(ctx context.Context) <-chan events.Event {
+	ch := make(chan events.Event)
+
+	go b.poll(ctx, ch)
+
+	return ch
+}
+
+func (b *Bus) poll(ctx context.Context, ch chan<- events.Event) {
+	defer close(ch)
+
+	ticker := time.NewTicker(b.pollInterval)
+	defer ticker.Stop()
+
+	lastID := int64(-1)
+
+	var lastCleanup time.Time
+
+	for {
+		var err error
+
+		if lastID < 0 {
+			err = b.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM events").Scan(&lastID)
+			if err != nil {
+				lastID = -1
+			}
+		} else {
+			lastID, err = b.fetch(ctx, lastID, ch)
+		}
+
+		if err != nil && ctx.Err() == nil {
+			b.logger.Printf("WARNING: %v", errors.WithMessage(err, "poll events"))
+		}
+
+		if time.Since(lastCleanup) > cleanupInterval {
+			lastCleanup = time.Now()
+
+			if err := b.cleanup(ctx); err != nil && ctx.Err() == nil {
+				b.logger.Printf("WARNING: %v", errors.WithMessage(err, "clean up events"))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetch sends the events after lastID, and returns the ID of the last event sent.
+func (b *Bus) fetch(ctx context.Context, lastID int64, ch chan<- events.Event) (int64, error) {
+	rows, err := b.db.QueryContext(ctx,
+		"SELECT id, payload, created_at < NOW(6) - INTERVAL ? MICROSECOND FROM events WHERE id > ? ORDER BY id LIMIT ?",
+		b.settleTime.Microseconds(), lastID, pollLimit)
+	if err != nil {
+		return lastID, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id      int64
+			data    []byte
+			settled bool
+		)
+
+		if err := rows.Scan(&id, &data, &settled); err != nil {
+			return lastID, err
+		}
+
+		// The missing event may be still committing, unless it would have done so by now.
+		if id != lastID+1 && !settled {
+			return lastID, nil
+		}
+
+		// An event that commits after this is never sent.
+		if id == lastID+2 {
+			b.logger.Printf("WARNING: event %d skipped, it is rolled back or not committed within %v", lastID+1, b.settleTime)
+		} else if id > lastID+2 {
+			b.logger.Printf("WARNING: events %d to %d skipped, they are rolled back or not committed within %v", lastID+1, id-1, b.settleTime)
+		}
+
+		lastID = id
+
+		var p payload
+		if err := json.Unmarshal(data, &p); err != nil {
+			b.logger.Printf("WARNING: %v", errors.WithMessagef(err, "invalid event %d", id))
+			continue
+		}
+
+		if p.Message != nil {
+			p.Message.SenderID = p.SenderID
+		}
+
+		select {
+		case ch <- p.Event:
+		case <-ctx.Done():
+			return lastID, ctx.Err()
+		}
+	}
+
+	return lastID, rows.Err()
+}
+
+func (b *Bus) cleanup(ctx context.Context) error {
+	_, err := b.db.ExecContext(ctx, "DELETE FROM events WHERE created_at < NOW(6) - INTERVAL ? MICROSECOND",
+		b.retention.Microseconds())
+
+	return err
+}
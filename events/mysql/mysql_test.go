@@ -0,0 +1,102 @@
+package mysql
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/events"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+	mysqlstore "github.com/ahmadmuzakkir/go-sample-api-server-structure/store/mysql"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testDbHost   = "127.0.0.1"
+	testDbPort   = 3307
+	testUsername = "root"
+	testPassword = "test_password"
+	testDatabase = "test_database"
+)
+
+func getTestStore(t *testing.T) *mysqlstore.Store {
+	s, err := mysqlstore.Connect(testDbHost, testDbPort, testUsername, testPassword, testDatabase)
+	if err != nil {
+		t.Fatalf(
+			"error connecting to the test mysql database: address=%q, port=%d username=%q, password=%q, database=%q: %s",
+			testDbHost, testDbPort, testUsername, testPassword, testDatabase, err,
+		)
+	}
+
+	m, err := s.Migrate()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer m.Close()
+
+	if err := m.Down(); err != nil && err != migrate.ErrNoChange {
+		assert.FailNow(t, err.Error())
+	}
+
+	if err := m.Up(); !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return s
+}
+
+func TestBus(t *testing.T) {
+	s := getTestStore(t)
+	defer s.DB().Close()
+
+	// Two servers sharing the database.
+	bus1 := New(s.DB(), log.New(ioutil.Discard, "", 0), 10*time.Millisecond, time.Second, time.Hour)
+	bus2 := New(s.DB(), log.New(ioutil.Discard, "", 0), 10*time.Millisecond, time.Second, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// An event published before the subscription is not delivered.
+	assert.NoError(t, bus1.Publish(context.Background(), events.Event{Type: events.MessageDeleted}))
+
+	sub := bus2.Subscribe(ctx)
+
+	// Wait for the subscription to start.
+	time.Sleep(100 * time.Millisecond)
+
+	err := bus1.Publish(context.Background(), events.Event{
+		Type:         events.MessageCreated,
+		Message:      &store.Message{ID: 1, Content: "content", SenderID: 2, Sender: "sender"},
+		RecipientIDs: []int64{3},
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, bus2.Publish(context.Background(), events.Event{Type: events.MessageUpdated}))
+
+	select {
+	case e := <-sub:
+		assert.Equal(t, events.MessageCreated, e.Type)
+		if assert.NotNil(t, e.Message) {
+			assert.Equal(t, "content", e.Message.Content)
+			assert.Equal(t, int64(2), e.Message.SenderID)
+		}
+		assert.Equal(t, []int64{3}, e.RecipientIDs)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+
+	select {
+	case e := <-sub:
+		assert.Equal(t, events.MessageUpdated, e.Type)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+
+	cancel()
+
+	for range sub {
+	}
+}
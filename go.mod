@@ -1,13 +1,25 @@
 module github.com/ahmadmuzakkir/go-sample-api-server-structure
 
-go 1.13
+go 1.20
 
 require (
 	github.com/go-chi/chi v4.0.3+incompatible
 	github.com/go-sql-driver/mysql v1.5.0
 	github.com/golang-migrate/migrate/v4 v4.8.0
+	github.com/gorilla/websocket v1.5.3
+	github.com/lib/pq v1.3.0
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/namsral/flag v1.7.4-pre
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.4.0
 	golang.org/x/crypto v0.0.0-20200128174031-69ecbb4d6d5d
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/hashicorp/go-multierror v1.0.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.0.0-20190426135247-a129542de9ae // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
+)
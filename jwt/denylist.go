@@ -0,0 +1,64 @@
+package jwt
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DenyList is a list of revoked sessions, whose tokens are rejected even though they are not expired yet.
+// A session only needs to stay on the list until the last token issued for it expires.
+type DenyList interface {
+	Revoke(ctx context.Context, sessionID int64, until time.Time) error
+	IsRevoked(ctx context.Context, sessionID int64) (bool, error)
+}
+
+var _ DenyList = (*MemoryDenyList)(nil)
+
+// MemoryDenyList is a DenyList kept in memory. It is not shared between servers, so it only
+// fits a single server deployment. It is safe for concurrent use.
+type MemoryDenyList struct {
+	mu       sync.Mutex
+	sessions map[int64]time.Time
+	now      func() time.Time
+}
+
+func NewMemoryDenyList() *MemoryDenyList {
+	return &MemoryDenyList{
+		sessions: make(map[int64]time.Time),
+		now:      time.Now,
+	}
+}
+
+func (d *MemoryDenyList) Revoke(ctx context.Context, sessionID int64, until time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.removeExpired()
+
+	if existing, ok := d.sessions[sessionID]; !ok || until.After(existing) {
+		d.sessions[sessionID] = until
+	}
+
+	return nil
+}
+
+func (d *MemoryDenyList) IsRevoked(ctx context.Context, sessionID int64) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	until, ok := d.sessions[sessionID]
+
+	return ok && d.now().Before(until), nil
+}
+
+// removeExpired must be called with the lock held.
+func (d *MemoryDenyList) removeExpired() {
+	now := d.now()
+
+	for id, until := range d.sessions {
+		if !now.Before(until) {
+			delete(d.sessions, id)
+		}
+	}
+}
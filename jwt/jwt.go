@@ -0,0 +1,149 @@
+// Package jwt issues and verifies self-contained access tokens in the JSON Web Token format.
+// Only the HS256 and EdDSA algorithms are supported.
+package jwt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("jwt: invalid token")
+	ErrExpiredToken = errors.New("jwt: expired token")
+)
+
+// Claims is the payload of an access token.
+type Claims struct {
+	// ID is the current access token of the session, as stored by the TokenStore.
+	ID        string `json:"jti"`
+	UserID    int64  `json:"sub,string"`
+	SessionID int64  `json:"sid"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// Signer signs tokens with one key, and verifies tokens signed by any of its keys.
+// To rotate the keys, sign with the new key and keep the old key for verification until its tokens expire.
+type Signer struct {
+	signingKey *Key
+	keys       map[string]*Key
+}
+
+// NewSigner returns a Signer that signs with signingKey. The verifyKeys are only used to verify tokens.
+func NewSigner(signingKey *Key, verifyKeys ...*Key) (*Signer, error) {
+	if !signingKey.canSign() {
+		return nil, fmt.Errorf("jwt: key %q cannot sign", signingKey.ID)
+	}
+
+	s := &Signer{
+		signingKey: signingKey,
+		keys:       make(map[string]*Key),
+	}
+
+	for _, k := range append([]*Key{signingKey}, verifyKeys...) {
+		if _, ok := s.keys[k.ID]; ok {
+			return nil, fmt.Errorf("jwt: duplicate key ID %q", k.ID)
+		}
+		s.keys[k.ID] = k
+	}
+
+	return s, nil
+}
+
+func (s *Signer) Sign(c Claims) (string, error) {
+	h := header{
+		Algorithm: s.signingKey.Algorithm,
+		Type:      "JWT",
+		KeyID:     s.signingKey.ID,
+	}
+
+	headerJSON, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+
+	claimsJSON, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encode(headerJSON) + "." + encode(claimsJSON)
+
+	signature, err := s.signingKey.sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + encode(signature), nil
+}
+
+// Verify checks the signature and the expiry time of the token, and returns its claims.
+// It returns ErrExpiredToken if the token is valid but expired, or ErrInvalidToken otherwise.
+func (s *Signer) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	if err := decode(parts[0], &h); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, ok := s.keys[h.KeyID]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	// The algorithm comes from the key, never from the token, so a token cannot choose a weaker algorithm.
+	if h.Algorithm != key.Algorithm {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	var c Claims
+	if err := decode(parts[1], &c); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if now.Unix() >= c.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	return &c, nil
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	return dec.Decode(v)
+}
@@ -0,0 +1,230 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignVerify(t *testing.T) {
+	hmacKey, err := NewHMACKey("hmac", []byte(strings.Repeat("s", 32)))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	now := time.Now()
+
+	claims := Claims{
+		ID:        "token",
+		UserID:    1,
+		SessionID: 2,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Minute).Unix(),
+	}
+
+	for _, key := range []*Key{hmacKey, NewEd25519Key("ed25519", privateKey)} {
+		t.Run(key.Algorithm, func(t *testing.T) {
+			s, err := NewSigner(key)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+
+			token, err := s.Sign(claims)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+
+			actual, err := s.Verify(token, now)
+			if assert.NoError(t, err) {
+				assert.Equal(t, claims, *actual)
+			}
+
+			_, err = s.Verify(token, now.Add(time.Minute))
+			assert.Equal(t, ErrExpiredToken, err)
+
+			// Change the payload without signing it again.
+			parts := strings.Split(token, ".")
+			tampered := claims
+			tampered.UserID = 3
+			other, err := s.Sign(tampered)
+			assert.NoError(t, err)
+
+			_, err = s.Verify(parts[0]+"."+strings.Split(other, ".")[1]+"."+parts[2], now)
+			assert.Equal(t, ErrInvalidToken, err)
+
+			for _, invalid := range []string{"", "a.b", "a.b.c", parts[0] + "." + parts[1] + ".", token + "a"} {
+				_, err = s.Verify(invalid, now)
+				assert.Equal(t, ErrInvalidToken, err, invalid)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, err := NewHMACKey("old", []byte(strings.Repeat("o", 32)))
+	assert.NoError(t, err)
+
+	newKey, err := NewHMACKey("new", []byte(strings.Repeat("n", 32)))
+	assert.NoError(t, err)
+
+	now := time.Now()
+	claims := Claims{ID: "token", UserID: 1, ExpiresAt: now.Add(time.Minute).Unix()}
+
+	oldSigner, err := NewSigner(oldKey)
+	assert.NoError(t, err)
+
+	oldToken, err := oldSigner.Sign(claims)
+	assert.NoError(t, err)
+
+	// After the rotation, the tokens signed by the old key are still accepted.
+	s, err := NewSigner(newKey, oldKey)
+	assert.NoError(t, err)
+
+	_, err = s.Verify(oldToken, now)
+	assert.NoError(t, err)
+
+	newToken, err := s.Sign(claims)
+	assert.NoError(t, err)
+
+	_, err = s.Verify(newToken, now)
+	assert.NoError(t, err)
+
+	// Once the old key is removed, its tokens are rejected.
+	s, err = NewSigner(newKey)
+	assert.NoError(t, err)
+
+	_, err = s.Verify(oldToken, now)
+	assert.Equal(t, ErrInvalidToken, err)
+
+	_, err = NewSigner(newKey, newKey)
+	assert.Error(t, err, "duplicate key ID")
+}
+
+// A token cannot switch to HS256 to be verified with the public key as the HMAC secret.
+func TestAlgorithmMismatch(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	s, err := NewSigner(NewEd25519Key("key", privateKey))
+	assert.NoError(t, err)
+
+	hmacKey, err := NewHMACKey("key", append(publicKey, publicKey...))
+	assert.NoError(t, err)
+
+	forger, err := NewSigner(hmacKey)
+	assert.NoError(t, err)
+
+	token, err := forger.Sign(Claims{UserID: 1, ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	assert.NoError(t, err)
+
+	_, err = s.Verify(token, time.Now())
+	assert.Equal(t, ErrInvalidToken, err)
+}
+
+func TestLoadKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwt")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	assert.NoError(t, err)
+
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	assert.NoError(t, err)
+
+	files := map[string][]byte{
+		"private.pem": pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}),
+		"public.pem":  pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}),
+		"secret":      []byte(strings.Repeat("s", 32)),
+		"short":       []byte("short"),
+	}
+
+	for name, b := range files {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), b, 0600))
+	}
+
+	private, err := LoadKey("private", filepath.Join(dir, "private.pem"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, AlgorithmEdDSA, private.Algorithm)
+	}
+
+	public, err := LoadKey("public", filepath.Join(dir, "public.pem"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, AlgorithmEdDSA, public.Algorithm)
+
+		_, err = NewSigner(public)
+		assert.Error(t, err, "a public key cannot sign")
+	}
+
+	secret, err := LoadKey("secret", filepath.Join(dir, "secret"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, AlgorithmHS256, secret.Algorithm)
+	}
+
+	_, err = LoadKey("short", filepath.Join(dir, "short"))
+	assert.Error(t, err)
+
+	_, err = LoadKey("not exists", filepath.Join(dir, "not exists"))
+	assert.Error(t, err)
+
+	// Tokens signed by the private key are verified by the public key.
+	s, err := NewSigner(private)
+	assert.NoError(t, err)
+
+	token, err := s.Sign(Claims{UserID: 1, ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	assert.NoError(t, err)
+
+	public.ID = private.ID
+	verifier, err := NewSigner(secret, public)
+	assert.NoError(t, err)
+
+	_, err = verifier.Verify(token, time.Now())
+	assert.NoError(t, err)
+}
+
+func TestMemoryDenyList(t *testing.T) {
+	now := time.Now()
+
+	d := NewMemoryDenyList()
+	d.now = func() time.Time { return now }
+
+	assert.NoError(t, d.Revoke(context.Background(), 1, now.Add(time.Minute)))
+
+	revoked, err := d.IsRevoked(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = d.IsRevoked(context.Background(), 2)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	// The session is removed once its tokens expired.
+	now = now.Add(time.Minute)
+
+	revoked, err = d.IsRevoked(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	assert.NoError(t, d.Revoke(context.Background(), 2, now.Add(time.Minute)))
+	assert.Len(t, d.sessions, 1)
+}
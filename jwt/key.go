@@ -0,0 +1,129 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+
+	// minSecretLength is the size of the SHA-256 output, as recommended by RFC 7518.
+	minSecretLength = 32
+)
+
+// Key is a signing or verification key, identified by the kid header of the tokens.
+type Key struct {
+	ID        string
+	Algorithm string
+
+	secret     []byte
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+}
+
+// NewHMACKey returns a HS256 key. The secret must be at least 32 bytes.
+func NewHMACKey(id string, secret []byte) (*Key, error) {
+	if len(secret) < minSecretLength {
+		return nil, fmt.Errorf("jwt: secret of key %q is shorter than %d bytes", id, minSecretLength)
+	}
+
+	return &Key{ID: id, Algorithm: AlgorithmHS256, secret: secret}, nil
+}
+
+// NewEd25519Key returns an EdDSA key that signs and verifies.
+func NewEd25519Key(id string, privateKey ed25519.PrivateKey) *Key {
+	return &Key{
+		ID:         id,
+		Algorithm:  AlgorithmEdDSA,
+		privateKey: privateKey,
+		publicKey:  privateKey.Public().(ed25519.PublicKey),
+	}
+}
+
+// NewEd25519PublicKey returns an EdDSA key that only verifies.
+func NewEd25519PublicKey(id string, publicKey ed25519.PublicKey) *Key {
+	return &Key{ID: id, Algorithm: AlgorithmEdDSA, publicKey: publicKey}
+}
+
+// LoadKey reads a key from a file.
+// A PEM encoded PKCS #8 Ed25519 private key or PKIX Ed25519 public key is an EdDSA key,
+// e.g. generated by `openssl genpkey -algorithm ed25519`.
+// Any other file is the secret of a HS256 key.
+func LoadKey(id, path string) (*Key, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return NewHMACKey(id, b)
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		privateKey, ok := k.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("jwt: key %q is not an Ed25519 key", id)
+		}
+
+		return NewEd25519Key(id, privateKey), nil
+	case "PUBLIC KEY":
+		k, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		publicKey, ok := k.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("jwt: key %q is not an Ed25519 key", id)
+		}
+
+		return NewEd25519PublicKey(id, publicKey), nil
+	default:
+		return nil, fmt.Errorf("jwt: unsupported PEM block %q in key %q", block.Type, id)
+	}
+}
+
+func (k *Key) canSign() bool {
+	return k.secret != nil || k.privateKey != nil
+}
+
+func (k *Key) sign(input []byte) ([]byte, error) {
+	switch {
+	case k.secret != nil:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case k.privateKey != nil:
+		return ed25519.Sign(k.privateKey, input), nil
+	default:
+		return nil, errors.New("jwt: key cannot sign")
+	}
+}
+
+func (k *Key) verify(input, signature []byte) bool {
+	switch k.Algorithm {
+	case AlgorithmHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return hmac.Equal(signature, mac.Sum(nil))
+	case AlgorithmEdDSA:
+		return ed25519.Verify(k.publicKey, input, signature)
+	default:
+		return false
+	}
+}
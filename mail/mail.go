@@ -0,0 +1,206 @@
+// Package mail sends the emails of the server, such as the password reset emails.
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is an email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers the emails.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+var _ Mailer = (*LogMailer)(nil)
+
+// LogMailer writes the emails to a logger instead of sending them, useful for local development.
+type LogMailer struct {
+	logger *log.Logger
+}
+
+func NewLogMailer(logger *log.Logger) *LogMailer {
+	return &LogMailer{logger: logger}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	m.logger.Printf("MAIL: to %q, subject %q\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+var _ Mailer = (*FileMailer)(nil)
+
+// FileMailer writes each email to its own file in a directory instead of sending it, useful for local development
+// and for reading the emails in the end to end tests. It is safe for concurrent use.
+type FileMailer struct {
+	dir string
+	now func() time.Time
+
+	mu   sync.Mutex
+	last int64
+}
+
+// NewFileMailer returns a FileMailer that writes to dir. The directory is created if it does not exist.
+func NewFileMailer(dir string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{dir: dir, now: time.Now}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+
+	// The file names sort by the time the emails are sent, and never collide.
+	id := now.UnixNano()
+	if id <= m.last {
+		id = m.last + 1
+	}
+	m.last = id
+
+	content := fmt.Sprintf("To: %s\nSubject: %s\nDate: %s\n\n%s\n", msg.To, msg.Subject, now.Format(time.RFC1123Z), msg.Body)
+
+	name := filepath.Join(m.dir, strconv.FormatInt(id, 10)+".eml")
+
+	return ioutil.WriteFile(name, []byte(content), 0600)
+}
+
+var _ Mailer = (*SMTPMailer)(nil)
+
+// smtpTimeout limits the delivery of an email when the context has no deadline.
+const smtpTimeout = 30 * time.Second
+
+var errHeaderInjection = errors.New("mail: line break in a header")
+
+// SMTPMailer sends the emails through an SMTP server. The connection is upgraded with STARTTLS if the server
+// supports it, and the password is only sent over TLS, or to localhost.
+type SMTPMailer struct {
+	addr string
+	host string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer returns an SMTPMailer that sends through the server at addr, a host:port, as from.
+// The username and password are empty if the server does not require authentication.
+func NewSMTPMailer(addr, username, password, from string) (*SMTPMailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.ContainsAny(from, "\r\n") {
+		return nil, errHeaderInjection
+	}
+
+	m := &SMTPMailer{addr: addr, host: host, from: from}
+
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return m, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	// The recipient and the subject may come from the users, e.g. the email address given on registration.
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return errHeaderInjection
+	}
+
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(smtpTimeout)
+	}
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return err
+		}
+	}
+
+	if m.auth != nil {
+		if err := c.Auth(m.auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(m.from); err != nil {
+		return err
+	}
+
+	if err := c.Rcpt(msg.To); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	header := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n",
+		m.from, msg.To, mime.QEncoding.Encode("utf-8", msg.Subject), time.Now().Format(time.RFC1123Z))
+
+	if _, err := w.Write([]byte(header)); err != nil {
+		return err
+	}
+
+	qp := quotedprintable.NewWriter(w)
+
+	if _, err := qp.Write([]byte(msg.Body)); err != nil {
+		return err
+	}
+
+	if err := qp.Close(); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
@@ -0,0 +1,173 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+
+	m := NewLogMailer(log.New(&buf, "", 0))
+
+	err := m.Send(context.Background(), Message{To: "username1", Subject: "subject", Body: "body"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.Contains(t, buf.String(), `to "username1"`)
+	assert.Contains(t, buf.String(), `subject "subject"`)
+	assert.Contains(t, buf.String(), "body")
+}
+
+func TestFileMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := NewFileMailer(filepath.Join(dir, "outbox"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	// Emails sent at the same time still get their own file.
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	for _, to := range []string{"username1", "username2"} {
+		err := m.Send(context.Background(), Message{To: to, Subject: "subject", Body: "body"})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, "outbox"))
+	if !assert.NoError(t, err) || !assert.Len(t, files, 2) {
+		t.FailNow()
+	}
+
+	for i, to := range []string{"username1", "username2"} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, "outbox", files[i].Name()))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		assert.Contains(t, string(content), "To: "+to+"\n")
+		assert.Contains(t, string(content), "Subject: subject\n")
+		assert.Contains(t, string(content), "\n\nbody\n")
+	}
+}
+
+// smtpServer accepts a single email, and records the commands and the data.
+func smtpServer(t *testing.T) (addr string, received chan []string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	received = make(chan []string, 1)
+
+	go func() {
+		defer l.Close()
+
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		c := textproto.NewConn(conn)
+
+		var lines []string
+		defer func() { received <- lines }()
+
+		_ = c.PrintfLine("220 localhost ESMTP")
+
+		for {
+			line, err := c.ReadLine()
+			if err != nil {
+				return
+			}
+
+			lines = append(lines, line)
+
+			switch strings.ToUpper(strings.SplitN(line, " ", 2)[0]) {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				_ = c.PrintfLine("250 OK")
+			case "DATA":
+				_ = c.PrintfLine("354 Go ahead")
+
+				data, err := c.ReadDotBytes()
+				if err != nil {
+					return
+				}
+
+				lines = append(lines, string(data))
+
+				_ = c.PrintfLine("250 OK")
+			case "QUIT":
+				_ = c.PrintfLine("221 Bye")
+				return
+			default:
+				_ = c.PrintfLine("502 Not implemented")
+			}
+		}
+	}()
+
+	return l.Addr().String(), received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	addr, received := smtpServer(t)
+
+	m, err := NewSMTPMailer(addr, "", "", "noreply@example.com")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	err = m.Send(context.Background(), Message{To: "user@example.com", Subject: "Vérifiez", Body: "body\n.\nend"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	lines := <-received
+	if !assert.Len(t, lines, 6) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, "MAIL FROM:<noreply@example.com>", lines[1])
+	assert.Equal(t, "RCPT TO:<user@example.com>", lines[2])
+	assert.Equal(t, "QUIT", lines[5])
+
+	data := lines[4]
+	assert.Contains(t, data, "From: noreply@example.com\n")
+	assert.Contains(t, data, "To: user@example.com\n")
+	assert.Contains(t, data, "Subject: =?utf-8?q?V=C3=A9rifiez?=\n")
+	assert.Contains(t, data, "\n\nbody\n.\nend", "the dot is unstuffed by the server")
+}
+
+func TestSMTPMailerHeaderInjection(t *testing.T) {
+	m, err := NewSMTPMailer("127.0.0.1:25", "", "", "noreply@example.com")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	err = m.Send(context.Background(), Message{To: "user@example.com\r\nBcc: victim@example.com", Subject: "subject", Body: "body"})
+	assert.Equal(t, errHeaderInjection, err)
+
+	_, err = NewSMTPMailer("localhost", "", "", "noreply@example.com")
+	assert.Error(t, err, "no port")
+}
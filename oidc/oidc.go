@@ -0,0 +1,211 @@
+// Package oidc signs in the users with an OpenID Connect provider, using the authorization code flow with PKCE.
+// Only the ID tokens signed with RS256 or ES256 are accepted.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidIDToken = errors.New("oidc: invalid ID token")
+
+// Config is the client registered at the provider.
+type Config struct {
+	ClientID string
+	// ClientSecret is empty for a public client, which relies on PKCE alone.
+	ClientSecret string
+	// RedirectURL is the callback URL, which must be registered at the provider.
+	RedirectURL string
+	// Scopes are requested along with openid, e.g. email and profile.
+	Scopes []string
+	// HTTPClient is used to talk to the provider. The default is a client with a 10 seconds timeout.
+	HTTPClient *http.Client
+}
+
+// Provider is an OpenID Connect provider, discovered from its issuer URL.
+type Provider struct {
+	issuer   string
+	authURL  string
+	tokenURL string
+	jwksURL  string
+
+	config Config
+	client *http.Client
+
+	// keys is the cached JWKS of the provider, by key ID.
+	mu            sync.Mutex
+	keys          map[string]*publicKey
+	keysFetchedAt time.Time
+}
+
+// Discover fetches the configuration of the provider from issuer/.well-known/openid-configuration.
+func Discover(ctx context.Context, issuer string, config Config) (*Provider, error) {
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	var discovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+
+	wellKnown := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(ctx, client, wellKnown, &discovery); err != nil {
+		return nil, fmt.Errorf("oidc: discover %s: %v", issuer, err)
+	}
+
+	// The ID tokens are checked against the issuer, so it must be exactly the configured one.
+	if discovery.Issuer != issuer {
+		return nil, fmt.Errorf("oidc: issuer %q does not match the discovered issuer %q", issuer, discovery.Issuer)
+	}
+
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: discovery of %s misses an endpoint", issuer)
+	}
+
+	p := &Provider{
+		issuer:   discovery.Issuer,
+		authURL:  discovery.AuthorizationEndpoint,
+		tokenURL: discovery.TokenEndpoint,
+		jwksURL:  discovery.JWKSURI,
+		config:   config,
+		client:   client,
+	}
+
+	return p, nil
+}
+
+func (p *Provider) Issuer() string {
+	return p.issuer
+}
+
+func (p *Provider) RedirectURL() string {
+	return p.config.RedirectURL
+}
+
+// AuthCodeURL returns the URL of the provider to send the user to. The state is given back to the callback,
+// the nonce is put into the ID token, and the verifier is needed to exchange the code, see NewVerifier.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(append([]string{"openid"}, p.config.Scopes...), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", Challenge(verifier))
+	query.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.authURL, "?") {
+		sep = "&"
+	}
+
+	return p.authURL + sep + query.Encode()
+}
+
+// Exchange exchanges the authorization code for the tokens, and returns the raw ID token.
+// The ID token must be verified with Verify.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oidc: exchange code: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&res); err != nil {
+		return "", fmt.Errorf("oidc: exchange code: status %d: %v", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc: exchange code: status %d: %s %s", resp.StatusCode, res.Error, res.ErrorDescription)
+	}
+
+	if res.IDToken == "" {
+		return "", errors.New("oidc: exchange code: no id_token in the response")
+	}
+
+	return res.IDToken, nil
+}
+
+// NewVerifier returns a random PKCE code verifier. It is also fit for a state or a nonce.
+func NewVerifier() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b[:]), nil
+}
+
+// Challenge returns the S256 PKCE code challenge of the verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, body)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,210 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/oidc"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+)
+
+const redirectURL = "http://client.example/callback"
+
+func newProvider(t *testing.T, server *oidctest.Server) *oidc.Provider {
+	p, err := oidc.Discover(context.Background(), server.Issuer(), oidc.Config{
+		ClientID:     server.ClientID,
+		ClientSecret: server.ClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"email"},
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return p
+}
+
+// authorize follows the authorization URL, and returns the query of the redirect to the callback.
+func authorize(t *testing.T, authURL string) url.Values {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(authURL)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	resp.Body.Close()
+
+	if !assert.Equal(t, http.StatusFound, resp.StatusCode) {
+		t.FailNow()
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(location.String(), redirectURL))
+
+	return location.Query()
+}
+
+func TestChallenge(t *testing.T) {
+	// RFC 7636 appendix B
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", oidc.Challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	server := oidctest.NewServer("client", "secret")
+	defer server.Close()
+
+	_, err := oidc.Discover(context.Background(), server.Issuer()+"/", oidc.Config{ClientID: "client"})
+	assert.Error(t, err)
+}
+
+func TestFlow(t *testing.T) {
+	for _, secret := range []string{"secret", ""} {
+		server := oidctest.NewServer("client", secret)
+		defer server.Close()
+
+		server.SetIdentity(oidctest.Identity{Subject: "user1", Email: "user1@example.com", EmailVerified: true})
+
+		p := newProvider(t, server)
+
+		verifier, err := oidc.NewVerifier()
+		assert.NoError(t, err)
+
+		query := authorize(t, p.AuthCodeURL("state", "nonce", verifier))
+		assert.Equal(t, "state", query.Get("state"))
+
+		_, err = p.Exchange(context.Background(), query.Get("code"), "wrong verifier")
+		assert.Error(t, err, "wrong verifier")
+
+		query = authorize(t, p.AuthCodeURL("state", "nonce", verifier))
+
+		rawIDToken, err := p.Exchange(context.Background(), query.Get("code"), verifier)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		_, err = p.Exchange(context.Background(), query.Get("code"), verifier)
+		assert.Error(t, err, "code used twice")
+
+		token, err := p.Verify(context.Background(), rawIDToken, "nonce", time.Now())
+		if assert.NoError(t, err) {
+			assert.Equal(t, server.Issuer(), token.Issuer)
+			assert.Equal(t, "user1", token.Subject)
+			assert.Equal(t, "user1@example.com", token.Email)
+			assert.True(t, token.EmailVerified)
+		}
+
+		_, err = p.Verify(context.Background(), rawIDToken, "other nonce", time.Now())
+		assert.True(t, errors.Is(err, oidc.ErrInvalidIDToken), "nonce mismatch")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	server := oidctest.NewServer("client", "secret")
+	defer server.Close()
+
+	other := oidctest.NewServer("client", "secret")
+	defer other.Close()
+
+	p := newProvider(t, server)
+
+	identity := oidctest.Identity{Subject: "user1"}
+
+	tests := []struct {
+		name   string
+		token  func() string
+		reason string
+	}{
+		{
+			name: "wrong audience",
+			token: func() string {
+				c := server.Claims(identity, "nonce")
+				c["aud"] = "other"
+				return server.Sign(c)
+			},
+		},
+		{
+			name: "many audiences without azp",
+			token: func() string {
+				c := server.Claims(identity, "nonce")
+				c["aud"] = []string{"client", "other"}
+				return server.Sign(c)
+			},
+		},
+		{
+			name: "wrong issuer",
+			token: func() string {
+				c := server.Claims(identity, "nonce")
+				c["iss"] = "https://evil.example"
+				return server.Sign(c)
+			},
+		},
+		{
+			name: "expired",
+			token: func() string {
+				c := server.Claims(identity, "nonce")
+				c["exp"] = time.Now().Add(-2 * time.Minute).Unix()
+				return server.Sign(c)
+			},
+		},
+		{
+			name: "issued in the future",
+			token: func() string {
+				c := server.Claims(identity, "nonce")
+				c["iat"] = time.Now().Add(time.Hour).Unix()
+				return server.Sign(c)
+			},
+		},
+		{
+			name: "no subject",
+			token: func() string {
+				return server.Sign(server.Claims(oidctest.Identity{}, "nonce"))
+			},
+		},
+		{
+			name: "signed by another key",
+			token: func() string {
+				c := server.Claims(identity, "nonce")
+				return other.Sign(c)
+			},
+		},
+		{
+			name: "alg none",
+			token: func() string {
+				parts := strings.Split(server.Sign(server.Claims(identity, "nonce")), ".")
+				return "eyJhbGciOiJub25lIiwidHlwIjoiSldUIn0." + parts[1] + "."
+			},
+		},
+		{
+			name: "malformed",
+			token: func() string {
+				return "not a token"
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := p.Verify(context.Background(), tc.token(), "nonce", time.Now())
+			assert.True(t, errors.Is(err, oidc.ErrInvalidIDToken), "%v", err)
+		})
+	}
+
+	// Many audiences are fine if the token is authorized for the client.
+	c := server.Claims(identity, "nonce")
+	c["aud"] = []string{"client", "other"}
+	c["azp"] = "client"
+
+	_, err := p.Verify(context.Background(), server.Sign(c), "nonce", time.Now())
+	assert.NoError(t, err)
+}
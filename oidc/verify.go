@@ -0,0 +1,371 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	algorithmRS256 = "RS256"
+	algorithmES256 = "ES256"
+
+	// leeway is the clock difference allowed between the provider and the server.
+	leeway = time.Minute
+
+	// minKeysRefreshInterval limits how often the JWKS is fetched again for an unknown key ID,
+	// so the tokens with made up key IDs cannot flood the provider.
+	minKeysRefreshInterval = time.Minute
+)
+
+// IDToken is the verified identity of the user.
+type IDToken struct {
+	Issuer  string
+	Subject string
+	// Email is empty if the provider does not give it, e.g. without the email scope.
+	Email         string
+	EmailVerified bool
+	IssuedAt      time.Time
+	Expiry        time.Time
+}
+
+type idTokenClaims struct {
+	Issuer        string       `json:"iss"`
+	Subject       string       `json:"sub"`
+	Audience      audience     `json:"aud"`
+	AuthorizedBy  string       `json:"azp"`
+	Nonce         string       `json:"nonce"`
+	Email         string       `json:"email"`
+	EmailVerified flexibleBool `json:"email_verified"`
+	IssuedAt      int64        `json:"iat"`
+	Expiry        int64        `json:"exp"`
+}
+
+// audience is either a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+
+	*a = ss
+
+	return nil
+}
+
+// flexibleBool is either a boolean or a "true" or "false" string, which some providers give for email_verified.
+type flexibleBool bool
+
+func (f *flexibleBool) UnmarshalJSON(b []byte) error {
+	var v bool
+	if err := json.Unmarshal(b, &v); err == nil {
+		*f = flexibleBool(v)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	*f = s == "true"
+
+	return nil
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// Verify checks the signature of the ID token against the provider's keys, and its issuer, audience,
+// expiry and nonce. It returns an error wrapping ErrInvalidIDToken if the ID token is not valid.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string, now time.Time) (*IDToken, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, invalidIDToken("malformed token")
+	}
+
+	var h jwtHeader
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, invalidIDToken("malformed header")
+	}
+
+	if h.Algorithm != algorithmRS256 && h.Algorithm != algorithmES256 {
+		return nil, invalidIDToken("unsupported algorithm " + h.Algorithm)
+	}
+
+	key, err := p.publicKey(ctx, h.KeyID, h.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalidIDToken("malformed signature")
+	}
+
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, invalidIDToken("bad signature")
+	}
+
+	var c idTokenClaims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, invalidIDToken("malformed claims")
+	}
+
+	if c.Issuer != p.issuer {
+		return nil, invalidIDToken("unexpected issuer " + c.Issuer)
+	}
+
+	if !containsString(c.Audience, p.config.ClientID) {
+		return nil, invalidIDToken("not issued to this client")
+	}
+
+	if len(c.Audience) > 1 && c.AuthorizedBy != p.config.ClientID {
+		return nil, invalidIDToken("not authorized for this client")
+	}
+
+	if c.Subject == "" {
+		return nil, invalidIDToken("no subject")
+	}
+
+	if c.Expiry == 0 || !now.Before(time.Unix(c.Expiry, 0).Add(leeway)) {
+		return nil, invalidIDToken("expired")
+	}
+
+	if time.Unix(c.IssuedAt, 0).After(now.Add(leeway)) {
+		return nil, invalidIDToken("issued in the future")
+	}
+
+	if c.Nonce != nonce {
+		return nil, invalidIDToken("nonce mismatch")
+	}
+
+	token := &IDToken{
+		Issuer:        c.Issuer,
+		Subject:       c.Subject,
+		Email:         c.Email,
+		EmailVerified: bool(c.EmailVerified),
+		IssuedAt:      time.Unix(c.IssuedAt, 0),
+		Expiry:        time.Unix(c.Expiry, 0),
+	}
+
+	return token, nil
+}
+
+func invalidIDToken(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidIDToken, reason)
+}
+
+type publicKey struct {
+	algorithm string
+	rsa       *rsa.PublicKey
+	ecdsa     *ecdsa.PublicKey
+}
+
+func (k *publicKey) verify(signingInput, signature []byte) bool {
+	digest := sha256.Sum256(signingInput)
+
+	switch k.algorithm {
+	case algorithmRS256:
+		return rsa.VerifyPKCS1v15(k.rsa, crypto.SHA256, digest[:], signature) == nil
+	case algorithmES256:
+		// The signature is r and s of 32 bytes each, not ASN.1.
+		if len(signature) != 64 {
+			return false
+		}
+
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+
+		return ecdsa.Verify(k.ecdsa, digest[:], r, s)
+	default:
+		return false
+	}
+}
+
+// publicKey returns the key of the provider for the key ID and the algorithm. The JWKS is fetched again
+// for an unknown key ID, as the provider may have rotated its keys.
+func (p *Provider) publicKey(ctx context.Context, keyID, algorithm string) (*publicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, ok := p.findKey(keyID, algorithm)
+	if ok {
+		return key, nil
+	}
+
+	if !p.keysFetchedAt.IsZero() && time.Since(p.keysFetchedAt) < minKeysRefreshInterval {
+		return nil, invalidIDToken("unknown key " + keyID)
+	}
+
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	key, ok = p.findKey(keyID, algorithm)
+	if !ok {
+		return nil, invalidIDToken("unknown key " + keyID)
+	}
+
+	return key, nil
+}
+
+// findKey must be called with the lock held. A token without a key ID is accepted if the provider has a single key
+// for the algorithm.
+func (p *Provider) findKey(keyID, algorithm string) (*publicKey, bool) {
+	if keyID != "" {
+		key, ok := p.keys[keyID]
+		return key, ok && key.algorithm == algorithm
+	}
+
+	var found *publicKey
+	for _, key := range p.keys {
+		if key.algorithm != algorithm {
+			continue
+		}
+
+		if found != nil {
+			return nil, false
+		}
+		found = key
+	}
+
+	return found, found != nil
+}
+
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+// fetchKeys returns the signing keys of the JWKS. The keys of other types or for encryption are skipped.
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]*publicKey, error) {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	if err := getJSON(ctx, p.client, p.jwksURL, &jwks); err != nil {
+		return nil, fmt.Errorf("oidc: fetch keys: %v", err)
+	}
+
+	keys := make(map[string]*publicKey)
+
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := parseKey(jwk)
+		if err != nil {
+			return nil, fmt.Errorf("oidc: key %q: %v", jwk.KeyID, err)
+		}
+
+		if key == nil || (jwk.Algorithm != "" && jwk.Algorithm != key.algorithm) {
+			continue
+		}
+
+		keys[jwk.KeyID] = key
+	}
+
+	return keys, nil
+}
+
+// parseKey returns nil for a key type that is not supported.
+func parseKey(jwk jsonWebKey) (*publicKey, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) < 256 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid RSA key")
+		}
+
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+
+		return &publicKey{algorithm: algorithmRS256, rsa: key}, nil
+	case "EC":
+		if jwk.Curve != "P-256" {
+			return nil, nil
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("invalid EC key")
+		}
+
+		return &publicKey{algorithm: algorithmES256, ecdsa: key}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func decodeSegment(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+
+	return json.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,295 @@
+// Package outbox relays the events of the outbox of the store to a sink, such as a webhook or the events pushed to
+// the users.
+// The events are written in the same transaction as the changes, so none is lost, but an event is delivered again
+// if the relay stops between delivering it and marking it delivered. The consumers tell the events apart by ID.
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/events"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+	"github.com/pkg/errors"
+)
+
+const (
+	// batchSize is the most events read from the store at once.
+	batchSize = 100
+
+	// deliverTimeout limits the delivery of an event, so a stuck sink does not hold the relay forever.
+	deliverTimeout = 30 * time.Second
+
+	// cleanupInterval is how often the delivered events older than the retention are deleted.
+	cleanupInterval = time.Minute
+)
+
+// Event is an event as delivered to the sinks.
+type Event struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+func newEvent(e *store.OutboxEvent) Event {
+	return Event{
+		ID:        e.ID,
+		Type:      e.Type,
+		CreatedAt: e.CreatedAt,
+		Payload:   e.Payload,
+	}
+}
+
+// Sink delivers the events. An event is delivered again after an error, so the sink must not keep a partial delivery.
+type Sink interface {
+	Deliver(ctx context.Context, e Event) error
+}
+
+var _ Sink = (*LogSink)(nil)
+
+// LogSink writes the events to a logger, useful for local development.
+type LogSink struct {
+	logger *log.Logger
+}
+
+func NewLogSink(logger *log.Logger) *LogSink {
+	return &LogSink{logger: logger}
+}
+
+func (s *LogSink) Deliver(ctx context.Context, e Event) error {
+	s.logger.Printf("OUTBOX: event %d %s %s", e.ID, e.Type, e.Payload)
+	return nil
+}
+
+var _ Sink = (*FileSink)(nil)
+
+// FileSink appends the events to a file, one JSON object per line. It is safe for concurrent use.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink returns a FileSink that appends to the file at path. The file is created if it does not exist.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{file: f}, nil
+}
+
+// Deliver returns once the event is synced to the disk.
+func (s *FileSink) Deliver(ctx context.Context, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A single write, so a failed delivery leaves at most a partial line behind.
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	return s.file.Sync()
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+var _ Sink = (*WebhookSink)(nil)
+
+// WebhookSink POSTs each event as JSON to a URL. The event is delivered once the URL responds with a 2xx status.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink returns a WebhookSink that POSTs to url. A nil client means a client with a timeout of 10 seconds.
+func NewWebhookSink(url string, client *http.Client) *WebhookSink {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &WebhookSink{url: url, client: client}
+}
+
+func (s *WebhookSink) Deliver(ctx context.Context, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Read the body, so the connection is reused.
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("outbox: webhook responded with %s", resp.Status)
+	}
+
+	return nil
+}
+
+var _ Sink = (*EventsSink)(nil)
+
+// EventsSink publishes the events of the messages to the events pushed to the users, see api.WithOutboxEvents.
+// The users may get an event twice, as it is delivered at least once.
+type EventsSink struct {
+	publisher events.Publisher
+}
+
+func NewEventsSink(publisher events.Publisher) *EventsSink {
+	return &EventsSink{publisher: publisher}
+}
+
+func (s *EventsSink) Deliver(ctx context.Context, e Event) error {
+	var payload store.MessageEvent
+	if err := json.Unmarshal(e.Payload, &payload); err != nil {
+		return err
+	}
+
+	if payload.Message == nil {
+		return fmt.Errorf("outbox: event %d has no message", e.ID)
+	}
+
+	payload.Message.SenderID = payload.SenderID
+
+	return s.publisher.Publish(ctx, events.Event{
+		Type:                 e.Type,
+		Message:              payload.Message,
+		RecipientIDs:         payload.RecipientIDs,
+		PreviousRecipientIDs: payload.PreviousRecipientIDs,
+	})
+}
+
+// Relay delivers the events of the outbox to a sink, in the order of their IDs. A failed delivery is retried on
+// the next poll, and the events after it wait. Only one relay must run against a store, or the relays deliver the
+// same events concurrently.
+//
+// The IDs are given out on insert, so an event can commit after an event with a higher ID. An event after a missing
+// ID waits for it until the event is older than the settle time, as the IDs of the rolled back transactions are
+// skipped for good. An event that commits later than that is delivered out of order, and logged. With MySQL's
+// auto_increment_increment above 1, every event waits for the settle time.
+type Relay struct {
+	outbox store.OutboxStore
+	sink   Sink
+	logger *log.Logger
+
+	pollInterval time.Duration
+	settleTime   time.Duration
+	retention    time.Duration
+
+	// lastID is the highest ID delivered since the start.
+	lastID int64
+}
+
+// NewRelay returns a relay that polls the outbox every pollInterval, waits up to settleTime for the missing IDs,
+// and keeps the delivered events for the retention.
+func NewRelay(outbox store.OutboxStore, sink Sink, logger *log.Logger, pollInterval, settleTime, retention time.Duration) *Relay {
+	return &Relay{
+		outbox:       outbox,
+		sink:         sink,
+		logger:       logger,
+		pollInterval: pollInterval,
+		settleTime:   settleTime,
+		retention:    retention,
+	}
+}
+
+// Run relays the events until ctx is done. The errors are logged and retried.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	var lastCleanup time.Time
+
+	for {
+		if err := r.relay(ctx); err != nil && ctx.Err() == nil {
+			r.logger.Printf("WARNING: %v", errors.WithMessage(err, "relay outbox events"))
+		}
+
+		if time.Since(lastCleanup) > cleanupInterval {
+			lastCleanup = time.Now()
+
+			if _, err := r.outbox.DeleteDelivered(ctx, time.Now().Add(-r.retention)); err != nil && ctx.Err() == nil {
+				r.logger.Printf("WARNING: %v", errors.WithMessage(err, "clean up outbox events"))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay delivers the pending events, and stops at the first error to keep the order.
+func (r *Relay) relay(ctx context.Context) error {
+	for {
+		events, err := r.outbox.GetPending(ctx, batchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range events {
+			// The missing event may be still committing, unless it would have done so by now.
+			if e.ID > r.lastID+1 && time.Since(e.CreatedAt) < r.settleTime {
+				return nil
+			}
+
+			if err := r.deliver(ctx, e); err != nil {
+				return errors.WithMessagef(err, "event %d", e.ID)
+			}
+
+			if e.ID < r.lastID {
+				r.logger.Printf("WARNING: outbox event %d delivered out of order, after event %d", e.ID, r.lastID)
+			} else {
+				r.lastID = e.ID
+			}
+
+			// The event is delivered again if this fails.
+			if err := r.outbox.MarkDelivered(ctx, e.ID, time.Now()); err != nil {
+				return errors.WithMessagef(err, "mark event %d delivered", e.ID)
+			}
+		}
+
+		if len(events) < batchSize {
+			return nil
+		}
+	}
+}
+
+func (r *Relay) deliver(ctx context.Context, e *store.OutboxEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, deliverTimeout)
+	defer cancel()
+
+	return r.sink.Deliver(ctx, newEvent(e))
+}
@@ -0,0 +1,369 @@
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/events"
+	memoryevents "github.com/ahmadmuzakkir/go-sample-api-server-structure/events/memory"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store/memory"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingSink records the delivered events, and fails the deliveries of the events in fail once.
+type recordingSink struct {
+	mu        sync.Mutex
+	delivered []Event
+	fail      map[int64]bool
+}
+
+func (s *recordingSink) Deliver(ctx context.Context, e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fail[e.ID] {
+		delete(s.fail, e.ID)
+		return errors.New("sink failed")
+	}
+
+	s.delivered = append(s.delivered, e)
+
+	return nil
+}
+
+func (s *recordingSink) ids() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []int64
+	for _, e := range s.delivered {
+		ids = append(ids, e.ID)
+	}
+
+	return ids
+}
+
+func TestRelay(t *testing.T) {
+	s := memory.New()
+
+	for _, username := range []string{"username1", "username2"} {
+		if !assert.NoError(t, s.User().Create(context.Background(), username, "password_hash")) {
+			t.FailNow()
+		}
+	}
+
+	for _, content := range []string{"message 1", "message 2", "message 3"} {
+		_, err := s.Message().Create(context.Background(), store.Message{
+			Content:      content,
+			SenderID:     1,
+			SentDateTime: time.Now(),
+		}, []int64{2})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+
+	sink := &recordingSink{fail: map[int64]bool{2: true}}
+	relay := NewRelay(s.Outbox(), sink, log.New(ioutil.Discard, "", 0), time.Hour, time.Second, time.Hour)
+
+	// The events after the failed one wait for it.
+	assert.Error(t, relay.relay(context.Background()))
+	assert.Equal(t, []int64{1}, sink.ids())
+
+	assert.NoError(t, relay.relay(context.Background()))
+	assert.Equal(t, []int64{1, 2, 3}, sink.ids())
+
+	pending, err := s.Outbox().GetPending(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Len(t, pending, 0)
+
+	var payload store.MessageEvent
+	if assert.NoError(t, json.Unmarshal(sink.delivered[0].Payload, &payload)) {
+		assert.Equal(t, store.EventMessageCreated, sink.delivered[0].Type)
+		assert.Equal(t, "message 1", payload.Message.Content)
+		assert.Equal(t, []int64{2}, payload.RecipientIDs)
+	}
+}
+
+func TestRelayMarkDeliveredFailure(t *testing.T) {
+	delivered := make(map[int64]bool)
+	failMark := true
+
+	outbox := &mock.OutboxStore{
+		OnGetPending: func(ctx context.Context, limit int) ([]*store.OutboxEvent, error) {
+			var events []*store.OutboxEvent
+			for _, id := range []int64{1, 2} {
+				if !delivered[id] {
+					events = append(events, &store.OutboxEvent{ID: id, Type: store.EventMessageCreated, Payload: []byte("{}")})
+				}
+			}
+
+			return events, nil
+		},
+		OnMarkDelivered: func(ctx context.Context, id int64, deliveredAt time.Time) error {
+			if failMark {
+				failMark = false
+				return errors.New("store failed")
+			}
+
+			delivered[id] = true
+
+			return nil
+		},
+	}
+
+	sink := &recordingSink{}
+	relay := NewRelay(outbox, sink, log.New(ioutil.Discard, "", 0), time.Hour, time.Second, time.Hour)
+
+	assert.Error(t, relay.relay(context.Background()))
+	assert.NoError(t, relay.relay(context.Background()))
+
+	// At least once: the event is delivered again, as it is not known to be delivered.
+	assert.Equal(t, []int64{1, 1, 2}, sink.ids())
+}
+
+func TestRelayOrder(t *testing.T) {
+	var pending []*store.OutboxEvent
+
+	outbox := &mock.OutboxStore{
+		OnGetPending: func(ctx context.Context, limit int) ([]*store.OutboxEvent, error) {
+			return append([]*store.OutboxEvent(nil), pending...), nil
+		},
+		OnMarkDelivered: func(ctx context.Context, id int64, deliveredAt time.Time) error {
+			for i, e := range pending {
+				if e.ID == id {
+					pending = append(pending[:i], pending[i+1:]...)
+					break
+				}
+			}
+
+			return nil
+		},
+	}
+
+	var buf bytes.Buffer
+
+	sink := &recordingSink{}
+	relay := NewRelay(outbox, sink, log.New(&buf, "", 0), time.Hour, time.Minute, time.Hour)
+
+	now := time.Now()
+
+	// Event 2 is still committing, so event 3 waits for it.
+	pending = []*store.OutboxEvent{
+		{ID: 1, CreatedAt: now},
+		{ID: 3, CreatedAt: now},
+	}
+
+	assert.NoError(t, relay.relay(context.Background()))
+	assert.Equal(t, []int64{1}, sink.ids())
+
+	pending = append([]*store.OutboxEvent{{ID: 2, CreatedAt: now}}, pending...)
+
+	assert.NoError(t, relay.relay(context.Background()))
+	assert.Equal(t, []int64{1, 2, 3}, sink.ids())
+
+	// Event 4 is rolled back, so event 5 no longer waits for it once settled.
+	pending = []*store.OutboxEvent{{ID: 5, CreatedAt: now.Add(-2 * time.Minute)}}
+
+	assert.NoError(t, relay.relay(context.Background()))
+	assert.Equal(t, []int64{1, 2, 3, 5}, sink.ids())
+
+	// An event that commits after the settle time is still delivered, out of order.
+	pending = []*store.OutboxEvent{{ID: 4, CreatedAt: now.Add(-3 * time.Minute)}}
+
+	assert.NoError(t, relay.relay(context.Background()))
+	assert.Equal(t, []int64{1, 2, 3, 5, 4}, sink.ids())
+	assert.Contains(t, buf.String(), "outbox event 4 delivered out of order")
+}
+
+func TestRelayRun(t *testing.T) {
+	s := memory.New()
+
+	if !assert.NoError(t, s.User().Create(context.Background(), "username1", "password_hash")) {
+		t.FailNow()
+	}
+
+	_, err := s.Message().Create(context.Background(), store.Message{
+		Content:      "message 1",
+		SenderID:     1,
+		SentDateTime: time.Now(),
+	}, []int64{1})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	sink := &recordingSink{fail: map[int64]bool{1: true}}
+	relay := NewRelay(s.Outbox(), sink, log.New(ioutil.Discard, "", 0), 10*time.Millisecond, time.Second, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		relay.Run(ctx)
+		close(done)
+	}()
+
+	// The failed delivery is retried on the next poll.
+	assert.Eventually(t, func() bool {
+		return len(sink.ids()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+
+	pending, err := s.Outbox().GetPending(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Len(t, pending, 0)
+}
+
+func TestEventsSink(t *testing.T) {
+	bus := memoryevents.New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := bus.Subscribe(ctx)
+
+	sink := NewEventsSink(bus)
+
+	payload := `{"message":{"id":1,"content":"content","sender":"username1"},"sender_id":1,"recipient_ids":[2,3],"previous_recipient_ids":[2]}`
+
+	err := sink.Deliver(context.Background(), Event{ID: 1, Type: store.EventMessageUpdated, Payload: []byte(payload)})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	e := <-ch
+	assert.Equal(t, events.MessageUpdated, e.Type)
+	if assert.NotNil(t, e.Message) {
+		assert.Equal(t, "content", e.Message.Content)
+		assert.Equal(t, int64(1), e.Message.SenderID)
+	}
+	assert.Equal(t, []int64{2, 3}, e.RecipientIDs)
+	assert.Equal(t, []int64{2}, e.PreviousRecipientIDs)
+
+	assert.Error(t, sink.Deliver(context.Background(), Event{ID: 2, Type: store.EventMessageDeleted, Payload: []byte(`{}`)}))
+}
+
+func TestLogSink(t *testing.T) {
+	var buf bytes.Buffer
+
+	sink := NewLogSink(log.New(&buf, "", 0))
+
+	err := sink.Deliver(context.Background(), Event{ID: 7, Type: store.EventMessageDeleted, Payload: []byte(`{"a":1}`)})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.Contains(t, buf.String(), "event 7 message.deleted")
+	assert.Contains(t, buf.String(), `{"a":1}`)
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.jsonl")
+
+	sink, err := NewFileSink(path)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, id := range []int64{1, 2} {
+		err := sink.Deliver(context.Background(), Event{ID: id, Type: store.EventMessageCreated, CreatedAt: createdAt, Payload: []byte(`{"a":1}`)})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+
+	assert.NoError(t, sink.Close())
+
+	f, err := os.Open(path)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer f.Close()
+
+	var events []Event
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		if assert.NoError(t, json.Unmarshal(scanner.Bytes(), &e)) {
+			events = append(events, e)
+		}
+	}
+
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, int64(1), events[0].ID)
+		assert.Equal(t, int64(2), events[1].ID)
+		assert.Equal(t, store.EventMessageCreated, events[1].Type)
+		assert.True(t, createdAt.Equal(events[1].CreatedAt))
+		assert.JSONEq(t, `{"a":1}`, string(events[1].Payload))
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []Event
+		status   = http.StatusInternalServerError
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var e Event
+		if assert.NoError(t, json.NewDecoder(r.Body).Decode(&e)) {
+			received = append(received, e)
+		}
+
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, nil)
+
+	e := Event{ID: 3, Type: store.EventMessageUpdated, Payload: []byte(`{"a":1}`)}
+
+	assert.Error(t, sink.Deliver(context.Background(), e), "non 2xx status")
+
+	mu.Lock()
+	status = http.StatusNoContent
+	mu.Unlock()
+
+	assert.NoError(t, sink.Deliver(context.Background(), e))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if assert.Len(t, received, 2) {
+		assert.Equal(t, int64(3), received[1].ID)
+		assert.Equal(t, store.EventMessageUpdated, received[1].Type)
+		assert.JSONEq(t, `{"a":1}`, string(received[1].Payload))
+	}
+}
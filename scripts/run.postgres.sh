@@ -0,0 +1,32 @@
+#!/bin/bash
+
+TEST=false
+
+while getopts ":t" opt; do
+  case $opt in
+  t)
+    TEST=true
+    ;;
+  \?) ;;
+  esac
+done
+
+if [ ${TEST} = true ]; then
+  # Start postgres database for the postgres unit tests
+  docker run --rm -it \
+    --name "go-sample-api-server-structure-postgres" \
+    --init \
+    -p 5433:5432 \
+    --env="POSTGRES_PASSWORD=test_password" \
+    --env="POSTGRES_DB=test_database" \
+    postgres:12
+else
+  docker run --rm -it \
+    --name "go-sample-api-server-structure-postgres" \
+    --init \
+    --mount source=go_sample_api_server_structure_postgres,target=/var/lib/postgresql/data \
+    -p 5432:5432 \
+    --env="POSTGRES_PASSWORD=12345" \
+    --env="POSTGRES_DB=go_sample_api_server_structure" \
+    postgres:12
+fi
@@ -0,0 +1,128 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.APIKeyStore = (*apiKeyStore)(nil)
+
+type apiKeyStore struct {
+	s *Store
+}
+
+func (a *apiKeyStore) Create(ctx context.Context, key string, k store.APIKey, limit int) (int64, error) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	if _, ok := a.s.users[k.UserID]; !ok {
+		return 0, errUserNotExist
+	}
+
+	if _, ok := a.s.apiKeys[key]; ok {
+		return 0, store.ErrDuplicate
+	}
+
+	count := 0
+	for _, existing := range a.s.apiKeys {
+		if existing.UserID == k.UserID {
+			count++
+		}
+	}
+
+	if count >= limit {
+		return 0, store.ErrLimit
+	}
+
+	a.s.lastAPIKeyID++
+
+	k.ID = a.s.lastAPIKeyID
+	k.LastUsedAt = nil
+	a.s.apiKeys[key] = copyAPIKey(&k)
+
+	return k.ID, nil
+}
+
+func (a *apiKeyStore) Get(ctx context.Context, key string) (*store.APIKey, error) {
+	a.s.mu.RLock()
+	defer a.s.mu.RUnlock()
+
+	k, ok := a.s.apiKeys[key]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	return copyAPIKey(k), nil
+}
+
+func (a *apiKeyStore) List(ctx context.Context, userID int64) ([]*store.APIKey, error) {
+	a.s.mu.RLock()
+	defer a.s.mu.RUnlock()
+
+	var keys []*store.APIKey
+	for _, k := range a.s.apiKeys {
+		if k.UserID == userID {
+			keys = append(keys, copyAPIKey(k))
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.After(keys[j].CreatedAt)
+		}
+		return keys[i].ID > keys[j].ID
+	})
+
+	return keys, nil
+}
+
+// Touch returns ErrNotFound if the key does not exist.
+func (a *apiKeyStore) Touch(ctx context.Context, id int64, lastUsedAt time.Time) error {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	for _, k := range a.s.apiKeys {
+		if k.ID == id {
+			k.LastUsedAt = &lastUsedAt
+			return nil
+		}
+	}
+
+	return store.ErrNotFound
+}
+
+// Delete returns ErrNotFound if the user has no such key.
+func (a *apiKeyStore) Delete(ctx context.Context, userID, id int64) error {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	for key, k := range a.s.apiKeys {
+		if k.ID == id && k.UserID == userID {
+			delete(a.s.apiKeys, key)
+			return nil
+		}
+	}
+
+	return store.ErrNotFound
+}
+
+// copyAPIKey copies the key along with its scopes and times, so the callers cannot change the stored key.
+func copyAPIKey(k *store.APIKey) *store.APIKey {
+	copied := *k
+	copied.Scopes = append([]string(nil), k.Scopes...)
+
+	if k.ExpiresAt != nil {
+		expiresAt := *k.ExpiresAt
+		copied.ExpiresAt = &expiresAt
+	}
+
+	if k.LastUsedAt != nil {
+		lastUsedAt := *k.LastUsedAt
+		copied.LastUsedAt = &lastUsedAt
+	}
+
+	return &copied
+}
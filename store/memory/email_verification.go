@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.EmailVerificationStore = (*emailVerificationStore)(nil)
+
+type emailVerificationStore struct {
+	s *Store
+}
+
+func (p *emailVerificationStore) Create(ctx context.Context, token string, t store.EmailVerificationToken) error {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	if _, ok := p.s.users[t.UserID]; !ok {
+		return errUserNotExist
+	}
+
+	if _, ok := p.s.emailVerifications[token]; ok {
+		return store.ErrDuplicate
+	}
+
+	p.s.lastEmailVerificationID++
+
+	t.ID = p.s.lastEmailVerificationID
+	t.UsedAt = nil
+	p.s.emailVerifications[token] = &t
+
+	return nil
+}
+
+func (p *emailVerificationStore) Get(ctx context.Context, token string) (*store.EmailVerificationToken, error) {
+	p.s.mu.RLock()
+	defer p.s.mu.RUnlock()
+
+	t, ok := p.s.emailVerifications[token]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	copied := *t
+	if t.UsedAt != nil {
+		usedAt := *t.UsedAt
+		copied.UsedAt = &usedAt
+	}
+
+	return &copied, nil
+}
+
+// MarkUsed returns ErrNotFound if the token does not exist or is already used.
+func (p *emailVerificationStore) MarkUsed(ctx context.Context, token string, usedAt time.Time) error {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	t, ok := p.s.emailVerifications[token]
+	if !ok || t.UsedAt != nil {
+		return store.ErrNotFound
+	}
+
+	t.UsedAt = &usedAt
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.LoginFailureStore = (*loginFailureStore)(nil)
+
+type loginFailureStore struct {
+	s *Store
+}
+
+func (l *loginFailureStore) Get(ctx context.Context, key string) (*store.LoginFailures, error) {
+	l.s.mu.RLock()
+	defer l.s.mu.RUnlock()
+
+	f, ok := l.s.loginFailures[key]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	copied := *f
+
+	return &copied, nil
+}
+
+func (l *loginFailureStore) Add(ctx context.Context, key string, failedAt, resetBefore time.Time) (*store.LoginFailures, error) {
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+
+	f, ok := l.s.loginFailures[key]
+	if !ok {
+		f = &store.LoginFailures{Key: key}
+		l.s.loginFailures[key] = f
+	}
+
+	if f.LastFailedAt.Before(resetBefore) {
+		f.Count = 0
+	}
+
+	f.Count++
+	f.LastFailedAt = failedAt
+
+	copied := *f
+
+	return &copied, nil
+}
+
+func (l *loginFailureStore) Undo(ctx context.Context, key string) error {
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+
+	f, ok := l.s.loginFailures[key]
+	if !ok || f.Count == 0 {
+		return store.ErrNotFound
+	}
+
+	f.Count--
+
+	return nil
+}
+
+func (l *loginFailureStore) Lock(ctx context.Context, key string, until time.Time) error {
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+
+	f, ok := l.s.loginFailures[key]
+	if !ok {
+		return store.ErrNotFound
+	}
+
+	f.LockedUntil = until
+
+	return nil
+}
+
+func (l *loginFailureStore) Delete(ctx context.Context, key string) error {
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+
+	if _, ok := l.s.loginFailures[key]; !ok {
+		return store.ErrNotFound
+	}
+
+	delete(l.s.loginFailures, key)
+
+	return nil
+}
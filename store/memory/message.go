@@ -0,0 +1,297 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.MessageStore = (*messageStore)(nil)
+
+type messageStore struct {
+	s *Store
+}
+
+func (m *messageStore) Create(ctx context.Context, msg store.Message, recipientUserIDs []int64) (int64, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if _, ok := m.s.users[msg.SenderID]; !ok {
+		return 0, errUserNotExist
+	}
+
+	if err := m.checkRecipients(recipientUserIDs); err != nil {
+		return 0, err
+	}
+
+	created := &message{
+		id:         m.s.lastMessageID + 1,
+		content:    msg.Content,
+		senderID:   msg.SenderID,
+		createdAt:  msg.SentDateTime,
+		updatedAt:  msg.SentDateTime,
+		recipients: append([]int64(nil), recipientUserIDs...),
+	}
+
+	if err := m.writeEvent(store.EventMessageCreated, created, created.recipients, nil); err != nil {
+		return 0, err
+	}
+
+	m.s.lastMessageID++
+	m.s.messages[created.id] = created
+
+	return created.id, nil
+}
+
+func (m *messageStore) GetByID(ctx context.Context, msgID int64) (*store.Message, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	msg, ok := m.s.messages[msgID]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	return m.toMessage(msg), nil
+}
+
+func (m *messageStore) Get(ctx context.Context, userID int64) ([]*store.Message, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	var messages []*store.Message
+	for _, msg := range m.s.messages {
+		if hasRecipient(msg, userID) {
+			messages = append(messages, m.toMessage(msg))
+		}
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].ID < messages[j].ID
+	})
+
+	return messages, nil
+}
+
+func (m *messageStore) GetPage(ctx context.Context, userID int64, after *store.Cursor, limit int) ([]*store.Message, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	var messages []*store.Message
+	for _, msg := range m.s.messages {
+		if !hasRecipient(msg, userID) {
+			continue
+		}
+
+		if after != nil && !olderThan(msg, after) {
+			continue
+		}
+
+		messages = append(messages, m.toMessage(msg))
+	}
+
+	sortNewestFirst(messages)
+
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+
+	return messages, nil
+}
+
+func (m *messageStore) GetSent(ctx context.Context, senderID int64, after *store.Cursor, limit int) ([]*store.Message, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	var messages []*store.Message
+	for _, msg := range m.s.messages {
+		if msg.senderID != senderID {
+			continue
+		}
+
+		if after != nil && !olderThan(msg, after) {
+			continue
+		}
+
+		sent := m.toMessage(msg)
+		sent.Recipients = sortedRecipients(msg)
+
+		messages = append(messages, sent)
+	}
+
+	sortNewestFirst(messages)
+
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+
+	return messages, nil
+}
+
+func (m *messageStore) GetRecipients(ctx context.Context, msgID int64) ([]int64, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	msg, ok := m.s.messages[msgID]
+	if !ok {
+		return nil, nil
+	}
+
+	return sortedRecipients(msg), nil
+}
+
+// Update returns ErrNotFound if the message does not exist.
+func (m *messageStore) Update(ctx context.Context, msg store.Message, recipientUserIDs []int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	existing, ok := m.s.messages[msg.ID]
+	if !ok {
+		return store.ErrNotFound
+	}
+
+	if err := m.checkRecipients(recipientUserIDs); err != nil {
+		return err
+	}
+
+	previousRecipients := sortedRecipients(existing)
+
+	updated := *existing
+	updated.content = msg.Content
+	updated.updatedAt = msg.UpdatedDateTime
+	updated.recipients = append([]int64(nil), recipientUserIDs...)
+
+	if err := m.writeEvent(store.EventMessageUpdated, &updated, updated.recipients, previousRecipients); err != nil {
+		return err
+	}
+
+	*existing = updated
+
+	return nil
+}
+
+// Delete returns ErrNotFound if the message does not exist.
+func (m *messageStore) Delete(ctx context.Context, messageID int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	existing, ok := m.s.messages[messageID]
+	if !ok {
+		return store.ErrNotFound
+	}
+
+	if err := m.writeEvent(store.EventMessageDeleted, existing, sortedRecipients(existing), nil); err != nil {
+		return err
+	}
+
+	// The recipients are stored within the message, so they are removed together.
+	delete(m.s.messages, messageID)
+
+	return nil
+}
+
+// checkRecipients mirrors the foreign key and unique index on the MySQL user_message_recipients table.
+// It must be called with the lock held.
+func (m *messageStore) checkRecipients(recipientUserIDs []int64) error {
+	seen := make(map[int64]bool, len(recipientUserIDs))
+
+	for _, rec := range recipientUserIDs {
+		if _, ok := m.s.users[rec]; !ok {
+			return errUserNotExist
+		}
+
+		if seen[rec] {
+			return store.ErrDuplicate
+		}
+		seen[rec] = true
+	}
+
+	return nil
+}
+
+// writeEvent adds the event of the message to the outbox, along with the change.
+// It must be called with the lock held.
+func (m *messageStore) writeEvent(eventType string, msg *message, recipientUserIDs, previousRecipientUserIDs []int64) error {
+	payload, err := json.Marshal(store.MessageEvent{
+		Message:              m.toMessage(msg),
+		SenderID:             msg.senderID,
+		RecipientIDs:         recipientUserIDs,
+		PreviousRecipientIDs: previousRecipientUserIDs,
+	})
+	if err != nil {
+		return err
+	}
+
+	m.s.lastOutboxID++
+
+	m.s.outbox = append(m.s.outbox, &store.OutboxEvent{
+		ID:        m.s.lastOutboxID,
+		Type:      eventType,
+		Payload:   payload,
+		CreatedAt: time.Now(),
+	})
+
+	return nil
+}
+
+// toMessage must be called with the lock held.
+func (m *messageStore) toMessage(msg *message) *store.Message {
+	var sender string
+	if u, ok := m.s.users[msg.senderID]; ok {
+		sender = u.Username
+	}
+
+	return &store.Message{
+		ID:              msg.id,
+		Content:         msg.content,
+		SenderID:        msg.senderID,
+		Sender:          sender,
+		SentDateTime:    msg.createdAt,
+		UpdatedDateTime: msg.updatedAt,
+	}
+}
+
+func hasRecipient(msg *message, userID int64) bool {
+	for _, rec := range msg.recipients {
+		if rec == userID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// olderThan reports whether the message comes after the cursor, in the newest first order.
+func olderThan(msg *message, cursor *store.Cursor) bool {
+	if msg.createdAt.Equal(cursor.SentDateTime) {
+		return msg.id < cursor.ID
+	}
+
+	return msg.createdAt.Before(cursor.SentDateTime)
+}
+
+// sortedRecipients must be called with the lock held.
+func sortedRecipients(msg *message) []int64 {
+	if len(msg.recipients) == 0 {
+		return nil
+	}
+
+	recipients := append([]int64(nil), msg.recipients...)
+	sort.Slice(recipients, func(i, j int) bool {
+		return recipients[i] < recipients[j]
+	})
+
+	return recipients
+}
+
+func sortNewestFirst(messages []*store.Message) {
+	sort.Slice(messages, func(i, j int) bool {
+		if !messages[i].SentDateTime.Equal(messages[j].SentDateTime) {
+			return messages[i].SentDateTime.After(messages[j].SentDateTime)
+		}
+		return messages[i].ID > messages[j].ID
+	})
+}
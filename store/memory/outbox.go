@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.OutboxStore = (*outboxStore)(nil)
+
+type outboxStore struct {
+	s *Store
+}
+
+func (o *outboxStore) GetPending(ctx context.Context, limit int) ([]*store.OutboxEvent, error) {
+	o.s.mu.RLock()
+	defer o.s.mu.RUnlock()
+
+	var events []*store.OutboxEvent
+	for _, e := range o.s.outbox {
+		if len(events) == limit {
+			break
+		}
+
+		if e.DeliveredAt == nil {
+			events = append(events, copyOutboxEvent(e))
+		}
+	}
+
+	return events, nil
+}
+
+// MarkDelivered returns ErrNotFound if the event does not exist.
+func (o *outboxStore) MarkDelivered(ctx context.Context, id int64, deliveredAt time.Time) error {
+	o.s.mu.Lock()
+	defer o.s.mu.Unlock()
+
+	for _, e := range o.s.outbox {
+		if e.ID == id {
+			e.DeliveredAt = &deliveredAt
+			return nil
+		}
+	}
+
+	return store.ErrNotFound
+}
+
+func (o *outboxStore) DeleteDelivered(ctx context.Context, before time.Time) (int64, error) {
+	o.s.mu.Lock()
+	defer o.s.mu.Unlock()
+
+	var deleted int64
+
+	kept := o.s.outbox[:0]
+	for _, e := range o.s.outbox {
+		if e.DeliveredAt != nil && e.DeliveredAt.Before(before) {
+			deleted++
+			continue
+		}
+
+		kept = append(kept, e)
+	}
+
+	// Clear the tail, so the deleted events can be garbage collected.
+	for i := len(kept); i < len(o.s.outbox); i++ {
+		o.s.outbox[i] = nil
+	}
+
+	o.s.outbox = kept
+
+	return deleted, nil
+}
+
+func copyOutboxEvent(e *store.OutboxEvent) *store.OutboxEvent {
+	copied := *e
+	copied.Payload = append([]byte(nil), e.Payload...)
+
+	if e.DeliveredAt != nil {
+		deliveredAt := *e.DeliveredAt
+		copied.DeliveredAt = &deliveredAt
+	}
+
+	return &copied
+}
@@ -0,0 +1,79 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.RefreshTokenStore = (*refreshTokenStore)(nil)
+
+type refreshTokenStore struct {
+	s *Store
+}
+
+func (r *refreshTokenStore) Create(ctx context.Context, refreshToken string, tok store.RefreshToken) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if !r.hasSession(tok.SessionID) {
+		return errSessionNotExist
+	}
+
+	if _, ok := r.s.refreshTokens[refreshToken]; ok {
+		return store.ErrDuplicate
+	}
+
+	r.s.lastRefreshTokenID++
+
+	tok.ID = r.s.lastRefreshTokenID
+	tok.UsedAt = nil
+	r.s.refreshTokens[refreshToken] = &tok
+
+	return nil
+}
+
+func (r *refreshTokenStore) Get(ctx context.Context, refreshToken string) (*store.RefreshToken, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	tok, ok := r.s.refreshTokens[refreshToken]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	copied := *tok
+	if tok.UsedAt != nil {
+		usedAt := *tok.UsedAt
+		copied.UsedAt = &usedAt
+	}
+
+	return &copied, nil
+}
+
+// MarkUsed returns ErrNotFound if the refresh token does not exist or is already used.
+func (r *refreshTokenStore) MarkUsed(ctx context.Context, refreshToken string, usedAt time.Time) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	tok, ok := r.s.refreshTokens[refreshToken]
+	if !ok || tok.UsedAt != nil {
+		return store.ErrNotFound
+	}
+
+	tok.UsedAt = &usedAt
+
+	return nil
+}
+
+// hasSession must be called with the lock held.
+func (r *refreshTokenStore) hasSession(sessionID int64) bool {
+	for _, tok := range r.s.tokens {
+		if tok.ID == sessionID {
+			return true
+		}
+	}
+
+	return false
+}
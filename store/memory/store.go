@@ -0,0 +1,180 @@
+package memory
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.Store = (*Store)(nil)
+
+// errUserNotExist mirrors the foreign key violation returned by MySQL when referencing a missing user.
+var errUserNotExist = errors.New("memory: user does not exist")
+
+// errSessionNotExist mirrors the foreign key violation when referencing a missing session.
+var errSessionNotExist = errors.New("memory: session does not exist")
+
+// Store is an in-memory implementation of store.Store. It is meant for local development and tests.
+// All the sub stores share the same lock, so it is safe for concurrent use.
+type Store struct {
+	mu sync.RWMutex
+
+	users      map[int64]*store.User
+	lastUserID int64
+
+	// identities is the user ID linked to the subjects of the external identity providers.
+	identities map[identity]int64
+
+	tokens      map[string]*store.Token
+	lastTokenID int64
+
+	refreshTokens      map[string]*store.RefreshToken
+	lastRefreshTokenID int64
+
+	passwordResets      map[string]*store.PasswordResetToken
+	lastPasswordResetID int64
+
+	emailVerifications      map[string]*store.EmailVerificationToken
+	lastEmailVerificationID int64
+
+	loginFailures map[string]*store.LoginFailures
+
+	totps         map[int64]*store.TOTP
+	recoveryCodes map[int64]map[string]bool
+
+	challenges      map[string]*store.LoginChallenge
+	lastChallengeID int64
+
+	apiKeys      map[string]*store.APIKey
+	lastAPIKeyID int64
+
+	messages      map[int64]*message
+	lastMessageID int64
+
+	// outbox is ordered by ID.
+	outbox       []*store.OutboxEvent
+	lastOutboxID int64
+
+	messageStore *messageStore
+	userStore    *userStore
+	tokenStore   *tokenStore
+
+	refreshTokenStore      *refreshTokenStore
+	passwordResetStore     *passwordResetStore
+	emailVerificationStore *emailVerificationStore
+	loginFailureStore      *loginFailureStore
+	twoFactorStore         *twoFactorStore
+	apiKeyStore            *apiKeyStore
+	outboxStore            *outboxStore
+}
+
+type identity struct {
+	issuer  string
+	subject string
+}
+
+type message struct {
+	id         int64
+	content    string
+	senderID   int64
+	createdAt  time.Time
+	updatedAt  time.Time
+	recipients []int64
+}
+
+func New() *Store {
+	s := &Store{
+		users:              make(map[int64]*store.User),
+		identities:         make(map[identity]int64),
+		tokens:             make(map[string]*store.Token),
+		refreshTokens:      make(map[string]*store.RefreshToken),
+		passwordResets:     make(map[string]*store.PasswordResetToken),
+		emailVerifications: make(map[string]*store.EmailVerificationToken),
+		loginFailures:      make(map[string]*store.LoginFailures),
+		totps:              make(map[int64]*store.TOTP),
+		recoveryCodes:      make(map[int64]map[string]bool),
+		challenges:         make(map[string]*store.LoginChallenge),
+		apiKeys:            make(map[string]*store.APIKey),
+		messages:           make(map[int64]*message),
+	}
+
+	s.messageStore = &messageStore{s: s}
+	s.userStore = &userStore{s: s}
+	s.tokenStore = &tokenStore{s: s}
+	s.refreshTokenStore = &refreshTokenStore{s: s}
+	s.passwordResetStore = &passwordResetStore{s: s}
+	s.emailVerificationStore = &emailVerificationStore{s: s}
+	s.loginFailureStore = &loginFailureStore{s: s}
+	s.twoFactorStore = &twoFactorStore{s: s}
+	s.apiKeyStore = &apiKeyStore{s: s}
+	s.outboxStore = &outboxStore{s: s}
+
+	return s
+}
+
+func (s *Store) Message() store.MessageStore {
+	return s.messageStore
+}
+
+func (s *Store) User() store.UserStore {
+	return s.userStore
+}
+
+func (s *Store) Token() store.TokenStore {
+	return s.tokenStore
+}
+
+func (s *Store) RefreshToken() store.RefreshTokenStore {
+	return s.refreshTokenStore
+}
+
+func (s *Store) PasswordReset() store.PasswordResetStore {
+	return s.passwordResetStore
+}
+
+func (s *Store) EmailVerification() store.EmailVerificationStore {
+	return s.emailVerificationStore
+}
+
+func (s *Store) LoginFailure() store.LoginFailureStore {
+	return s.loginFailureStore
+}
+
+func (s *Store) TwoFactor() store.TwoFactorStore {
+	return s.twoFactorStore
+}
+
+func (s *Store) APIKey() store.APIKeyStore {
+	return s.apiKeyStore
+}
+
+func (s *Store) Outbox() store.OutboxStore {
+	return s.outboxStore
+}
+
+// deleteTokens must be called with the lock held.
+func (s *Store) deleteTokens(userID int64) {
+	for k, v := range s.tokens {
+		if v.UserID == userID {
+			delete(s.tokens, k)
+		}
+	}
+
+	for k, v := range s.refreshTokens {
+		if v.UserID == userID {
+			delete(s.refreshTokens, k)
+		}
+	}
+}
+
+// deleteRefreshTokens mirrors the cascading foreign key from refresh_tokens to tokens.
+// It must be called with the lock held.
+func (s *Store) deleteRefreshTokens(sessionID int64) {
+	for k, v := range s.refreshTokens {
+		if v.SessionID == sessionID {
+			delete(s.refreshTokens, k)
+		}
+	}
+}
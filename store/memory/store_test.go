@@ -0,0 +1,37 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store/storetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.RunConformance(t, func(t *testing.T) store.Store {
+		return New()
+	})
+}
+
+func TestConcurrentCreate(t *testing.T) {
+	s := New()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, s.User().Create(context.Background(), fmt.Sprintf("username%d", i), "hash"))
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 50; i++ {
+		_, err := s.User().GetByUsername(context.Background(), fmt.Sprintf("username%d", i))
+		assert.NoError(t, err)
+	}
+}
@@ -0,0 +1,167 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.TokenStore = (*tokenStore)(nil)
+
+type tokenStore struct {
+	s *Store
+}
+
+func (t *tokenStore) Create(ctx context.Context, userToken string, tok store.Token) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	if _, ok := t.s.users[tok.UserID]; !ok {
+		return errUserNotExist
+	}
+
+	if _, ok := t.s.tokens[userToken]; ok {
+		return store.ErrDuplicate
+	}
+
+	t.s.lastTokenID++
+
+	tok.ID = t.s.lastTokenID
+	t.s.tokens[userToken] = &tok
+
+	return nil
+}
+
+func (t *tokenStore) GetUserID(ctx context.Context, userToken string) (*store.Token, error) {
+	t.s.mu.RLock()
+	defer t.s.mu.RUnlock()
+
+	tok, ok := t.s.tokens[userToken]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	copied := *tok
+	return &copied, nil
+}
+
+// GetByID returns ErrNotFound if the session does not exist.
+func (t *tokenStore) GetByID(ctx context.Context, id int64) (*store.Token, error) {
+	t.s.mu.RLock()
+	defer t.s.mu.RUnlock()
+
+	for _, tok := range t.s.tokens {
+		if tok.ID == id {
+			copied := *tok
+			return &copied, nil
+		}
+	}
+
+	return nil, store.ErrNotFound
+}
+
+// Renew returns ErrNotFound if the session does not exist.
+func (t *tokenStore) Renew(ctx context.Context, id int64, userToken string, expiresAt time.Time) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	if _, ok := t.s.tokens[userToken]; ok {
+		return store.ErrDuplicate
+	}
+
+	for k, tok := range t.s.tokens {
+		if tok.ID == id {
+			delete(t.s.tokens, k)
+
+			tok.ExpiresAt = expiresAt
+			t.s.tokens[userToken] = tok
+
+			return nil
+		}
+	}
+
+	return store.ErrNotFound
+}
+
+// Delete returns ErrNotFound if the token does not exist.
+func (t *tokenStore) Delete(ctx context.Context, userToken string) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	tok, ok := t.s.tokens[userToken]
+	if !ok {
+		return store.ErrNotFound
+	}
+
+	delete(t.s.tokens, userToken)
+
+	t.s.deleteRefreshTokens(tok.ID)
+
+	return nil
+}
+
+// DeleteByID returns ErrNotFound if the session does not exist.
+func (t *tokenStore) DeleteByID(ctx context.Context, id int64) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	for k, tok := range t.s.tokens {
+		if tok.ID == id {
+			delete(t.s.tokens, k)
+
+			t.s.deleteRefreshTokens(id)
+
+			return nil
+		}
+	}
+
+	return store.ErrNotFound
+}
+
+// Touch returns ErrNotFound if the token does not exist.
+func (t *tokenStore) Touch(ctx context.Context, userToken string, lastUsedAt time.Time) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	tok, ok := t.s.tokens[userToken]
+	if !ok {
+		return store.ErrNotFound
+	}
+
+	tok.LastUsedAt = lastUsedAt
+
+	return nil
+}
+
+func (t *tokenStore) DeleteByUserID(ctx context.Context, userID int64) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	t.s.deleteTokens(userID)
+
+	return nil
+}
+
+func (t *tokenStore) List(ctx context.Context, userID int64) ([]*store.Token, error) {
+	t.s.mu.RLock()
+	defer t.s.mu.RUnlock()
+
+	var tokens []*store.Token
+	for _, tok := range t.s.tokens {
+		if tok.UserID == userID {
+			copied := *tok
+			tokens = append(tokens, &copied)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+		}
+		return tokens[i].ID > tokens[j].ID
+	})
+
+	return tokens, nil
+}
@@ -0,0 +1,168 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.TwoFactorStore = (*twoFactorStore)(nil)
+
+type twoFactorStore struct {
+	s *Store
+}
+
+func (t *twoFactorStore) SetTOTP(ctx context.Context, totp store.TOTP) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	if _, ok := t.s.users[totp.UserID]; !ok {
+		return errUserNotExist
+	}
+
+	totp.Secret = append([]byte(nil), totp.Secret...)
+	totp.ConfirmedAt = nil
+	totp.LastUsedStep = 0
+	t.s.totps[totp.UserID] = &totp
+
+	return nil
+}
+
+func (t *twoFactorStore) GetTOTP(ctx context.Context, userID int64) (*store.TOTP, error) {
+	t.s.mu.RLock()
+	defer t.s.mu.RUnlock()
+
+	totp, ok := t.s.totps[userID]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	copied := *totp
+	copied.Secret = append([]byte(nil), totp.Secret...)
+	if totp.ConfirmedAt != nil {
+		confirmedAt := *totp.ConfirmedAt
+		copied.ConfirmedAt = &confirmedAt
+	}
+
+	return &copied, nil
+}
+
+func (t *twoFactorStore) ConfirmTOTP(ctx context.Context, userID int64, confirmedAt time.Time) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	totp, ok := t.s.totps[userID]
+	if !ok || totp.ConfirmedAt != nil {
+		return store.ErrNotFound
+	}
+
+	totp.ConfirmedAt = &confirmedAt
+
+	return nil
+}
+
+func (t *twoFactorStore) UseTOTPStep(ctx context.Context, userID int64, step int64) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	totp, ok := t.s.totps[userID]
+	if !ok || step <= totp.LastUsedStep {
+		return store.ErrNotFound
+	}
+
+	totp.LastUsedStep = step
+
+	return nil
+}
+
+func (t *twoFactorStore) DeleteTOTP(ctx context.Context, userID int64) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	if _, ok := t.s.totps[userID]; !ok {
+		return store.ErrNotFound
+	}
+
+	delete(t.s.totps, userID)
+	delete(t.s.recoveryCodes, userID)
+
+	return nil
+}
+
+func (t *twoFactorStore) SetRecoveryCodes(ctx context.Context, userID int64, codes []string) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	if _, ok := t.s.users[userID]; !ok {
+		return errUserNotExist
+	}
+
+	set := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		set[code] = true
+	}
+	t.s.recoveryCodes[userID] = set
+
+	return nil
+}
+
+func (t *twoFactorStore) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	if !t.s.recoveryCodes[userID][code] {
+		return store.ErrNotFound
+	}
+
+	delete(t.s.recoveryCodes[userID], code)
+
+	return nil
+}
+
+func (t *twoFactorStore) CreateChallenge(ctx context.Context, token string, c store.LoginChallenge) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	if _, ok := t.s.users[c.UserID]; !ok {
+		return errUserNotExist
+	}
+
+	if _, ok := t.s.challenges[token]; ok {
+		return store.ErrDuplicate
+	}
+
+	t.s.lastChallengeID++
+
+	c.ID = t.s.lastChallengeID
+	t.s.challenges[token] = &c
+
+	return nil
+}
+
+func (t *twoFactorStore) GetChallenge(ctx context.Context, token string) (*store.LoginChallenge, error) {
+	t.s.mu.RLock()
+	defer t.s.mu.RUnlock()
+
+	c, ok := t.s.challenges[token]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	copied := *c
+
+	return &copied, nil
+}
+
+func (t *twoFactorStore) DeleteChallenge(ctx context.Context, token string) error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	if _, ok := t.s.challenges[token]; !ok {
+		return store.ErrNotFound
+	}
+
+	delete(t.s.challenges, token)
+
+	return nil
+}
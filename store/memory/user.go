@@ -0,0 +1,214 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.UserStore = (*userStore)(nil)
+
+type userStore struct {
+	s *Store
+}
+
+func (u *userStore) Create(ctx context.Context, username, passwordHash string) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	for _, user := range u.s.users {
+		if user.Username == username {
+			return store.ErrDuplicate
+		}
+	}
+
+	u.s.lastUserID++
+
+	u.s.users[u.s.lastUserID] = &store.User{
+		ID:           u.s.lastUserID,
+		Username:     username,
+		PasswordHash: passwordHash,
+	}
+
+	return nil
+}
+
+func (u *userStore) GetByUsername(ctx context.Context, username string) (*store.User, error) {
+	u.s.mu.RLock()
+	defer u.s.mu.RUnlock()
+
+	for _, user := range u.s.users {
+		if user.Username == username {
+			return copyUser(user), nil
+		}
+	}
+
+	return nil, store.ErrNotFound
+}
+
+func (u *userStore) GetByID(ctx context.Context, id int64) (*store.User, error) {
+	u.s.mu.RLock()
+	defer u.s.mu.RUnlock()
+
+	user, ok := u.s.users[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+// UpdatePasswordHash returns ErrNotFound if the user does not exist.
+func (u *userStore) UpdatePasswordHash(ctx context.Context, id int64, passwordHash string) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	user, ok := u.s.users[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+
+	user.PasswordHash = passwordHash
+
+	return nil
+}
+
+// SetEmail returns ErrNotFound if the user does not exist.
+func (u *userStore) SetEmail(ctx context.Context, id int64, email string) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	user, ok := u.s.users[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+
+	user.Email = email
+	user.VerifiedAt = nil
+
+	return nil
+}
+
+// VerifyEmail returns ErrNotFound if the user does not exist or the address is no longer email.
+func (u *userStore) VerifyEmail(ctx context.Context, id int64, email string, verifiedAt time.Time) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	user, ok := u.s.users[id]
+	if !ok || user.Email != email {
+		return store.ErrNotFound
+	}
+
+	user.VerifiedAt = &verifiedAt
+
+	return nil
+}
+
+func (u *userStore) GetByIdentity(ctx context.Context, issuer, subject string) (*store.User, error) {
+	u.s.mu.RLock()
+	defer u.s.mu.RUnlock()
+
+	id, ok := u.s.identities[identity{issuer: issuer, subject: subject}]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	return copyUser(u.s.users[id]), nil
+}
+
+// LinkIdentity returns ErrDuplicate if the subject is already linked to a user.
+func (u *userStore) LinkIdentity(ctx context.Context, userID int64, issuer, subject string) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	if _, ok := u.s.users[userID]; !ok {
+		return errUserNotExist
+	}
+
+	key := identity{issuer: issuer, subject: subject}
+
+	if _, ok := u.s.identities[key]; ok {
+		return store.ErrDuplicate
+	}
+
+	u.s.identities[key] = userID
+
+	return nil
+}
+
+// Delete returns ErrNotFound if the user does not exist.
+// Same as the cascading foreign keys in MySQL, the user's tokens, password reset tokens, email verification tokens, API keys, identities, sent messages and recipient entries are removed.
+func (u *userStore) Delete(ctx context.Context, id int64) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	if _, ok := u.s.users[id]; !ok {
+		return store.ErrNotFound
+	}
+
+	delete(u.s.users, id)
+
+	u.s.deleteTokens(id)
+
+	for k, v := range u.s.passwordResets {
+		if v.UserID == id {
+			delete(u.s.passwordResets, k)
+		}
+	}
+
+	for k, v := range u.s.emailVerifications {
+		if v.UserID == id {
+			delete(u.s.emailVerifications, k)
+		}
+	}
+
+	delete(u.s.totps, id)
+	delete(u.s.recoveryCodes, id)
+
+	for k, v := range u.s.challenges {
+		if v.UserID == id {
+			delete(u.s.challenges, k)
+		}
+	}
+
+	for k, v := range u.s.apiKeys {
+		if v.UserID == id {
+			delete(u.s.apiKeys, k)
+		}
+	}
+
+	for k, v := range u.s.identities {
+		if v == id {
+			delete(u.s.identities, k)
+		}
+	}
+
+	for k, msg := range u.s.messages {
+		if msg.senderID == id {
+			delete(u.s.messages, k)
+			continue
+		}
+
+		recipients := msg.recipients[:0]
+		for _, rec := range msg.recipients {
+			if rec != id {
+				recipients = append(recipients, rec)
+			}
+		}
+		msg.recipients = recipients
+	}
+
+	return nil
+}
+
+// copyUser must be called with the lock held.
+func copyUser(user *store.User) *store.User {
+	copied := *user
+	if user.VerifiedAt != nil {
+		verifiedAt := *user.VerifiedAt
+		copied.VerifiedAt = &verifiedAt
+	}
+
+	return &copied
+}
@@ -0,0 +1,38 @@
+package mock
+
+import (
+	"context"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.APIKeyStore = (*APIKeyStore)(nil)
+
+type APIKeyStore struct {
+	OnCreate func(ctx context.Context, key string, k store.APIKey, limit int) (int64, error)
+	OnGet    func(ctx context.Context, key string) (*store.APIKey, error)
+	OnList   func(ctx context.Context, userID int64) ([]*store.APIKey, error)
+	OnTouch  func(ctx context.Context, id int64, lastUsedAt time.Time) error
+	OnDelete func(ctx context.Context, userID, id int64) error
+}
+
+func (a *APIKeyStore) Create(ctx context.Context, key string, k store.APIKey, limit int) (int64, error) {
+	return a.OnCreate(ctx, key, k, limit)
+}
+
+func (a *APIKeyStore) Get(ctx context.Context, key string) (*store.APIKey, error) {
+	return a.OnGet(ctx, key)
+}
+
+func (a *APIKeyStore) List(ctx context.Context, userID int64) ([]*store.APIKey, error) {
+	return a.OnList(ctx, userID)
+}
+
+func (a *APIKeyStore) Touch(ctx context.Context, id int64, lastUsedAt time.Time) error {
+	return a.OnTouch(ctx, id, lastUsedAt)
+}
+
+func (a *APIKeyStore) Delete(ctx context.Context, userID, id int64) error {
+	return a.OnDelete(ctx, userID, id)
+}
@@ -0,0 +1,28 @@
+package mock
+
+import (
+	"context"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.EmailVerificationStore = (*EmailVerificationStore)(nil)
+
+type EmailVerificationStore struct {
+	OnCreate   func(ctx context.Context, token string, t store.EmailVerificationToken) error
+	OnGet      func(ctx context.Context, token string) (*store.EmailVerificationToken, error)
+	OnMarkUsed func(ctx context.Context, token string, usedAt time.Time) error
+}
+
+func (p *EmailVerificationStore) Create(ctx context.Context, token string, t store.EmailVerificationToken) error {
+	return p.OnCreate(ctx, token, t)
+}
+
+func (p *EmailVerificationStore) Get(ctx context.Context, token string) (*store.EmailVerificationToken, error) {
+	return p.OnGet(ctx, token)
+}
+
+func (p *EmailVerificationStore) MarkUsed(ctx context.Context, token string, usedAt time.Time) error {
+	return p.OnMarkUsed(ctx, token, usedAt)
+}
@@ -0,0 +1,38 @@
+package mock
+
+import (
+	"context"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.LoginFailureStore = (*LoginFailureStore)(nil)
+
+type LoginFailureStore struct {
+	OnGet    func(ctx context.Context, key string) (*store.LoginFailures, error)
+	OnAdd    func(ctx context.Context, key string, failedAt, resetBefore time.Time) (*store.LoginFailures, error)
+	OnUndo   func(ctx context.Context, key string) error
+	OnLock   func(ctx context.Context, key string, until time.Time) error
+	OnDelete func(ctx context.Context, key string) error
+}
+
+func (l *LoginFailureStore) Get(ctx context.Context, key string) (*store.LoginFailures, error) {
+	return l.OnGet(ctx, key)
+}
+
+func (l *LoginFailureStore) Add(ctx context.Context, key string, failedAt, resetBefore time.Time) (*store.LoginFailures, error) {
+	return l.OnAdd(ctx, key, failedAt, resetBefore)
+}
+
+func (l *LoginFailureStore) Undo(ctx context.Context, key string) error {
+	return l.OnUndo(ctx, key)
+}
+
+func (l *LoginFailureStore) Lock(ctx context.Context, key string, until time.Time) error {
+	return l.OnLock(ctx, key, until)
+}
+
+func (l *LoginFailureStore) Delete(ctx context.Context, key string) error {
+	return l.OnDelete(ctx, key)
+}
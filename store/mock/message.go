@@ -9,14 +9,17 @@ import (
 var _ store.MessageStore = (*MessageStore)(nil)
 
 type MessageStore struct {
-	OnCreate  func(ctx context.Context, msg store.Message, recipientUserIDs []int64) error
-	OnGet     func(ctx context.Context, userID int64) ([]*store.Message, error)
-	OnGetByID func(ctx context.Context, msgID int64) (*store.Message, error)
-	OnDelete  func(ctx context.Context, userID int64) error
-	OnUpdate  func(ctx context.Context, msg store.Message, recipientUserIDs []int64) error
+	OnCreate        func(ctx context.Context, msg store.Message, recipientUserIDs []int64) (int64, error)
+	OnGet           func(ctx context.Context, userID int64) ([]*store.Message, error)
+	OnGetPage       func(ctx context.Context, userID int64, after *store.Cursor, limit int) ([]*store.Message, error)
+	OnGetSent       func(ctx context.Context, senderID int64, after *store.Cursor, limit int) ([]*store.Message, error)
+	OnGetByID       func(ctx context.Context, msgID int64) (*store.Message, error)
+	OnGetRecipients func(ctx context.Context, msgID int64) ([]int64, error)
+	OnDelete        func(ctx context.Context, userID int64) error
+	OnUpdate        func(ctx context.Context, msg store.Message, recipientUserIDs []int64) error
 }
 
-func (m *MessageStore) Create(ctx context.Context, msg store.Message, recipientUserIDs []int64) error {
+func (m *MessageStore) Create(ctx context.Context, msg store.Message, recipientUserIDs []int64) (int64, error) {
 	return m.OnCreate(ctx, msg, recipientUserIDs)
 }
 
@@ -24,8 +27,20 @@ func (m *MessageStore) Get(ctx context.Context, userID int64) ([]*store.Message,
 	return m.OnGet(ctx, userID)
 }
 
+func (m *MessageStore) GetPage(ctx context.Context, userID int64, after *store.Cursor, limit int) ([]*store.Message, error) {
+	return m.OnGetPage(ctx, userID, after, limit)
+}
+
+func (m *MessageStore) GetSent(ctx context.Context, senderID int64, after *store.Cursor, limit int) ([]*store.Message, error) {
+	return m.OnGetSent(ctx, senderID, after, limit)
+}
+
 func (m *MessageStore) GetByID(ctx context.Context, msgID int64) (*store.Message, error) {
-	return m.GetByID(ctx, msgID)
+	return m.OnGetByID(ctx, msgID)
+}
+
+func (m *MessageStore) GetRecipients(ctx context.Context, msgID int64) ([]int64, error) {
+	return m.OnGetRecipients(ctx, msgID)
 }
 
 func (m *MessageStore) Delete(ctx context.Context, userID int64) error {
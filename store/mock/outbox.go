@@ -0,0 +1,28 @@
+package mock
+
+import (
+	"context"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.OutboxStore = (*OutboxStore)(nil)
+
+type OutboxStore struct {
+	OnGetPending      func(ctx context.Context, limit int) ([]*store.OutboxEvent, error)
+	OnMarkDelivered   func(ctx context.Context, id int64, deliveredAt time.Time) error
+	OnDeleteDelivered func(ctx context.Context, before time.Time) (int64, error)
+}
+
+func (o *OutboxStore) GetPending(ctx context.Context, limit int) ([]*store.OutboxEvent, error) {
+	return o.OnGetPending(ctx, limit)
+}
+
+func (o *OutboxStore) MarkDelivered(ctx context.Context, id int64, deliveredAt time.Time) error {
+	return o.OnMarkDelivered(ctx, id, deliveredAt)
+}
+
+func (o *OutboxStore) DeleteDelivered(ctx context.Context, before time.Time) (int64, error) {
+	return o.OnDeleteDelivered(ctx, before)
+}
@@ -0,0 +1,28 @@
+package mock
+
+import (
+	"context"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.PasswordResetStore = (*PasswordResetStore)(nil)
+
+type PasswordResetStore struct {
+	OnCreate   func(ctx context.Context, token string, t store.PasswordResetToken) error
+	OnGet      func(ctx context.Context, token string) (*store.PasswordResetToken, error)
+	OnMarkUsed func(ctx context.Context, token string, usedAt time.Time) error
+}
+
+func (p *PasswordResetStore) Create(ctx context.Context, token string, t store.PasswordResetToken) error {
+	return p.OnCreate(ctx, token, t)
+}
+
+func (p *PasswordResetStore) Get(ctx context.Context, token string) (*store.PasswordResetToken, error) {
+	return p.OnGet(ctx, token)
+}
+
+func (p *PasswordResetStore) MarkUsed(ctx context.Context, token string, usedAt time.Time) error {
+	return p.OnMarkUsed(ctx, token, usedAt)
+}
@@ -0,0 +1,28 @@
+package mock
+
+import (
+	"context"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.RefreshTokenStore = (*RefreshTokenStore)(nil)
+
+type RefreshTokenStore struct {
+	OnCreate   func(ctx context.Context, token string, t store.RefreshToken) error
+	OnGet      func(ctx context.Context, token string) (*store.RefreshToken, error)
+	OnMarkUsed func(ctx context.Context, token string, usedAt time.Time) error
+}
+
+func (r *RefreshTokenStore) Create(ctx context.Context, token string, t store.RefreshToken) error {
+	return r.OnCreate(ctx, token, t)
+}
+
+func (r *RefreshTokenStore) Get(ctx context.Context, token string) (*store.RefreshToken, error) {
+	return r.OnGet(ctx, token)
+}
+
+func (r *RefreshTokenStore) MarkUsed(ctx context.Context, token string, usedAt time.Time) error {
+	return r.OnMarkUsed(ctx, token, usedAt)
+}
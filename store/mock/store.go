@@ -10,6 +10,14 @@ type Store struct {
 	UserStore    store.UserStore
 	MessageStore store.MessageStore
 	TokenStore   store.TokenStore
+
+	RefreshTokenStore      store.RefreshTokenStore
+	PasswordResetStore     store.PasswordResetStore
+	EmailVerificationStore store.EmailVerificationStore
+	LoginFailureStore      store.LoginFailureStore
+	TwoFactorStore         store.TwoFactorStore
+	APIKeyStore            store.APIKeyStore
+	OutboxStore            store.OutboxStore
 }
 
 func (s *Store) Message() store.MessageStore {
@@ -20,7 +28,34 @@ func (s *Store) Token() store.TokenStore {
 	return s.TokenStore
 }
 
+func (s *Store) RefreshToken() store.RefreshTokenStore {
+	return s.RefreshTokenStore
+}
+
+func (s *Store) PasswordReset() store.PasswordResetStore {
+	return s.PasswordResetStore
+}
+
+func (s *Store) EmailVerification() store.EmailVerificationStore {
+	return s.EmailVerificationStore
+}
+
+func (s *Store) LoginFailure() store.LoginFailureStore {
+	return s.LoginFailureStore
+}
+
+func (s *Store) TwoFactor() store.TwoFactorStore {
+	return s.TwoFactorStore
+}
+
+func (s *Store) APIKey() store.APIKeyStore {
+	return s.APIKeyStore
+}
+
+func (s *Store) Outbox() store.OutboxStore {
+	return s.OutboxStore
+}
+
 func (s *Store) User() store.UserStore {
 	return s.UserStore
 }
-
@@ -10,14 +10,49 @@ import (
 var _ store.TokenStore = (*TokenStore)(nil)
 
 type TokenStore struct {
-	OnCreate    func(ctx context.Context, userID int64, token string, updatedAt time.Time) error
-	OnGetUserID func(ctx context.Context, token string) (*store.Token, error)
+	OnCreate         func(ctx context.Context, token string, t store.Token) error
+	OnGetUserID      func(ctx context.Context, token string) (*store.Token, error)
+	OnGetByID        func(ctx context.Context, id int64) (*store.Token, error)
+	OnRenew          func(ctx context.Context, id int64, token string, expiresAt time.Time) error
+	OnDelete         func(ctx context.Context, token string) error
+	OnDeleteByID     func(ctx context.Context, id int64) error
+	OnTouch          func(ctx context.Context, token string, lastUsedAt time.Time) error
+	OnDeleteByUserID func(ctx context.Context, userID int64) error
+	OnList           func(ctx context.Context, userID int64) ([]*store.Token, error)
 }
 
-func (t *TokenStore) Create(ctx context.Context, userID int64, token string, updatedAt time.Time) error {
-	return t.OnCreate(ctx, userID, token, updatedAt)
+func (t *TokenStore) Create(ctx context.Context, token string, tok store.Token) error {
+	return t.OnCreate(ctx, token, tok)
 }
 
 func (t *TokenStore) GetUserID(ctx context.Context, token string) (*store.Token, error) {
 	return t.OnGetUserID(ctx, token)
 }
+
+func (t *TokenStore) GetByID(ctx context.Context, id int64) (*store.Token, error) {
+	return t.OnGetByID(ctx, id)
+}
+
+func (t *TokenStore) Renew(ctx context.Context, id int64, token string, expiresAt time.Time) error {
+	return t.OnRenew(ctx, id, token, expiresAt)
+}
+
+func (t *TokenStore) Delete(ctx context.Context, token string) error {
+	return t.OnDelete(ctx, token)
+}
+
+func (t *TokenStore) DeleteByID(ctx context.Context, id int64) error {
+	return t.OnDeleteByID(ctx, id)
+}
+
+func (t *TokenStore) Touch(ctx context.Context, token string, lastUsedAt time.Time) error {
+	return t.OnTouch(ctx, token, lastUsedAt)
+}
+
+func (t *TokenStore) DeleteByUserID(ctx context.Context, userID int64) error {
+	return t.OnDeleteByUserID(ctx, userID)
+}
+
+func (t *TokenStore) List(ctx context.Context, userID int64) ([]*store.Token, error) {
+	return t.OnList(ctx, userID)
+}
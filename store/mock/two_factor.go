@@ -0,0 +1,63 @@
+package mock
+
+import (
+	"context"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.TwoFactorStore = (*TwoFactorStore)(nil)
+
+type TwoFactorStore struct {
+	OnSetTOTP          func(ctx context.Context, t store.TOTP) error
+	OnGetTOTP          func(ctx context.Context, userID int64) (*store.TOTP, error)
+	OnConfirmTOTP      func(ctx context.Context, userID int64, confirmedAt time.Time) error
+	OnUseTOTPStep      func(ctx context.Context, userID int64, step int64) error
+	OnDeleteTOTP       func(ctx context.Context, userID int64) error
+	OnSetRecoveryCodes func(ctx context.Context, userID int64, codes []string) error
+	OnUseRecoveryCode  func(ctx context.Context, userID int64, code string) error
+	OnCreateChallenge  func(ctx context.Context, token string, c store.LoginChallenge) error
+	OnGetChallenge     func(ctx context.Context, token string) (*store.LoginChallenge, error)
+	OnDeleteChallenge  func(ctx context.Context, token string) error
+}
+
+func (t *TwoFactorStore) SetTOTP(ctx context.Context, totp store.TOTP) error {
+	return t.OnSetTOTP(ctx, totp)
+}
+
+func (t *TwoFactorStore) GetTOTP(ctx context.Context, userID int64) (*store.TOTP, error) {
+	return t.OnGetTOTP(ctx, userID)
+}
+
+func (t *TwoFactorStore) ConfirmTOTP(ctx context.Context, userID int64, confirmedAt time.Time) error {
+	return t.OnConfirmTOTP(ctx, userID, confirmedAt)
+}
+
+func (t *TwoFactorStore) UseTOTPStep(ctx context.Context, userID int64, step int64) error {
+	return t.OnUseTOTPStep(ctx, userID, step)
+}
+
+func (t *TwoFactorStore) DeleteTOTP(ctx context.Context, userID int64) error {
+	return t.OnDeleteTOTP(ctx, userID)
+}
+
+func (t *TwoFactorStore) SetRecoveryCodes(ctx context.Context, userID int64, codes []string) error {
+	return t.OnSetRecoveryCodes(ctx, userID, codes)
+}
+
+func (t *TwoFactorStore) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	return t.OnUseRecoveryCode(ctx, userID, code)
+}
+
+func (t *TwoFactorStore) CreateChallenge(ctx context.Context, token string, c store.LoginChallenge) error {
+	return t.OnCreateChallenge(ctx, token, c)
+}
+
+func (t *TwoFactorStore) GetChallenge(ctx context.Context, token string) (*store.LoginChallenge, error) {
+	return t.OnGetChallenge(ctx, token)
+}
+
+func (t *TwoFactorStore) DeleteChallenge(ctx context.Context, token string) error {
+	return t.OnDeleteChallenge(ctx, token)
+}
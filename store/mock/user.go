@@ -2,6 +2,7 @@ package mock
 
 import (
 	"context"
+	"time"
 
 	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
 )
@@ -9,9 +10,16 @@ import (
 var _ store.UserStore = (*UserStore)(nil)
 
 type UserStore struct {
-	OnCreate func(ctx context.Context, username, passwordHash string) error
+	OnCreate        func(ctx context.Context, username, passwordHash string) error
 	OnGetByUsername func(ctx context.Context, username string) (*store.User, error)
-	OnGetByID func(ctx context.Context, id int64) (*store.User, error)
+	OnGetByID       func(ctx context.Context, id int64) (*store.User, error)
+	OnDelete        func(ctx context.Context, id int64) error
+
+	OnUpdatePasswordHash func(ctx context.Context, id int64, passwordHash string) error
+	OnSetEmail           func(ctx context.Context, id int64, email string) error
+	OnVerifyEmail        func(ctx context.Context, id int64, email string, verifiedAt time.Time) error
+	OnGetByIdentity      func(ctx context.Context, issuer, subject string) (*store.User, error)
+	OnLinkIdentity       func(ctx context.Context, userID int64, issuer, subject string) error
 }
 
 func (u *UserStore) Create(ctx context.Context, username, passwordHash string) error {
@@ -26,3 +34,26 @@ func (u *UserStore) GetByID(ctx context.Context, id int64) (*store.User, error)
 	return u.OnGetByID(ctx, id)
 }
 
+func (u *UserStore) UpdatePasswordHash(ctx context.Context, id int64, passwordHash string) error {
+	return u.OnUpdatePasswordHash(ctx, id, passwordHash)
+}
+
+func (u *UserStore) SetEmail(ctx context.Context, id int64, email string) error {
+	return u.OnSetEmail(ctx, id, email)
+}
+
+func (u *UserStore) VerifyEmail(ctx context.Context, id int64, email string, verifiedAt time.Time) error {
+	return u.OnVerifyEmail(ctx, id, email, verifiedAt)
+}
+
+func (u *UserStore) Delete(ctx context.Context, id int64) error {
+	return u.OnDelete(ctx, id)
+}
+
+func (u *UserStore) GetByIdentity(ctx context.Context, issuer, subject string) (*store.User, error) {
+	return u.OnGetByIdentity(ctx, issuer, subject)
+}
+
+func (u *UserStore) LinkIdentity(ctx context.Context, userID int64, issuer, subject string) error {
+	return u.OnLinkIdentity(ctx, userID, issuer, subject)
+}
@@ -8,9 +8,8 @@ import (
 	"time"
 
 	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store/storetest"
 	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/mysql"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/crypto/bcrypt"
 
@@ -34,15 +33,11 @@ func getTestStore(t *testing.T) (*Store, func()) {
 		)
 	}
 
-	driver, err := mysql.WithInstance(s.db, &mysql.Config{
-		DatabaseName: testDatabase,
-	})
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://./migrations",
-		"mysql", driver)
+	m, err := s.Migrate()
 	if !assert.NoError(t, err) {
 		t.FailNow()
 	}
+	defer m.Close()
 
 	if err := m.Down(); err != nil && err != migrate.ErrNoChange {
 		assert.FailNow(t, err.Error())
@@ -59,6 +54,14 @@ func getTestStore(t *testing.T) (*Store, func()) {
 	return s, cleanup
 }
 
+func TestConformance(t *testing.T) {
+	storetest.RunConformance(t, func(t *testing.T) store.Store {
+		s, cleanup := getTestStore(t)
+		t.Cleanup(cleanup)
+		return s
+	})
+}
+
 // Test User and Token tables
 func TestUseAndToken(t *testing.T) {
 	s, cleanup := getTestStore(t)
@@ -88,9 +91,14 @@ func TestUseAndToken(t *testing.T) {
 		t.FailNow()
 	}
 
-	updatedAt := time.Now()
+	createdAt := time.Now()
 
-	err = s.tokenStore.Create(context.Background(), user.ID, fmt.Sprintf("%x", token), updatedAt)
+	err = s.tokenStore.Create(context.Background(), fmt.Sprintf("%x", token), store.Token{
+		UserID:     user.ID,
+		CreatedAt:  createdAt,
+		LastUsedAt: createdAt,
+		ExpiresAt:  createdAt.Add(time.Hour),
+	})
 	if !assert.NoError(t, err) {
 		t.FailNow()
 	}
@@ -101,7 +109,7 @@ func TestUseAndToken(t *testing.T) {
 	}
 
 	assert.Equal(t, user.ID, userToken.UserID)
-	assert.Equal(t, updatedAt.Nanosecond(), userToken.UpdatedAt.Nanosecond())
+	assert.Equal(t, createdAt.Nanosecond(), userToken.CreatedAt.Nanosecond())
 }
 
 func TestMessage(t *testing.T) {
@@ -120,7 +128,7 @@ func TestMessage(t *testing.T) {
 		SentDateTime: time.Now(),
 	}
 
-	err := s.messageStore.Create(context.Background(), msg, []int64{user2.ID, user3.ID})
+	_, err := s.messageStore.Create(context.Background(), msg, []int64{user2.ID, user3.ID})
 	if !assert.NoError(t, err) {
 		t.FailNow()
 	}
@@ -0,0 +1,37 @@
+package mysql
+
+import (
+	"database/sql"
+	"embed"
+	"net/http"
+
+	"github.com/golang-migrate/migrate/v4"
+	migratemysql "github.com/golang-migrate/migrate/v4/database/mysql"
+	"github.com/golang-migrate/migrate/v4/source/httpfs"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Migrate returns a migrate instance that applies the embedded migrations to the store database.
+// It uses a separate connection, so closing it does not affect the store.
+func (s *Store) Migrate() (*migrate.Migrate, error) {
+	db, err := sql.Open("mysql", s.connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := migratemysql.WithInstance(db, &migratemysql.Config{})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	source, err := httpfs.New(http.FS(migrations), "migrations")
+	if err != nil {
+		_ = driver.Close()
+		return nil, err
+	}
+
+	return migrate.NewWithInstance("httpfs", source, "mysql", driver)
+}
@@ -0,0 +1,2 @@
+ALTER TABLE `messages`
+    DROP INDEX `idx_messages_created_at`;
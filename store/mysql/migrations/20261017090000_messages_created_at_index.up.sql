@@ -0,0 +1,2 @@
+ALTER TABLE `messages`
+    ADD INDEX `idx_messages_created_at` (`created_at`, `id`);
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS `tokens`;
+
+CREATE TABLE IF NOT EXISTS `tokens`
+(
+    `user_id`    INT          NOT NULL,
+    `token`      VARCHAR(255) NOT NULL,
+    `updated_at` DATETIME(6)  NULL DEFAULT NULL,
+
+    CONSTRAINT `fk_tokens_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE,
+    PRIMARY KEY (`token`),
+    UNIQUE INDEX `idx_user_id` (`user_id`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4;
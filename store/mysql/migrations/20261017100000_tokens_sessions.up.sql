@@ -0,0 +1,18 @@
+-- A user can have many tokens, one per session. The existing tokens are dropped, so every user has to login again.
+DROP TABLE IF EXISTS `tokens`;
+
+CREATE TABLE IF NOT EXISTS `tokens`
+(
+    `id`           INT          NOT NULL AUTO_INCREMENT,
+    `user_id`      INT          NOT NULL,
+    `token`        VARCHAR(255) NOT NULL,
+    `user_agent`   VARCHAR(255) NOT NULL DEFAULT '',
+    `created_at`   DATETIME(6)  NOT NULL,
+    `last_used_at` DATETIME(6)  NOT NULL,
+
+    CONSTRAINT `fk_tokens_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_token` (`token`),
+    INDEX `idx_tokens_user_id` (`user_id`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,26 @@
+-- The existing tokens keep the previous fixed lifetime of 24 hours.
+ALTER TABLE `tokens`
+    ADD COLUMN `expires_at` DATETIME(6) NOT NULL DEFAULT '1970-01-01 00:00:01';
+
+UPDATE `tokens` SET `expires_at` = DATE_ADD(`created_at`, INTERVAL 1 DAY);
+
+ALTER TABLE `tokens`
+    ALTER COLUMN `expires_at` DROP DEFAULT;
+
+CREATE TABLE IF NOT EXISTS `refresh_tokens`
+(
+    `id`         INT          NOT NULL AUTO_INCREMENT,
+    `session_id` INT          NOT NULL,
+    `user_id`    INT          NOT NULL,
+    `token`      VARCHAR(255) NOT NULL,
+    `created_at` DATETIME(6)  NOT NULL,
+    `expires_at` DATETIME(6)  NOT NULL,
+    `used_at`    DATETIME(6)  NULL DEFAULT NULL,
+
+    CONSTRAINT `fk_refresh_tokens_session` FOREIGN KEY (`session_id`) REFERENCES `tokens` (`id`) ON DELETE CASCADE,
+    CONSTRAINT `fk_refresh_tokens_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_refresh_token` (`token`),
+    INDEX `idx_refresh_tokens_session_id` (`session_id`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4;
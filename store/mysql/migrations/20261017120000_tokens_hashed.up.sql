@@ -0,0 +1,3 @@
+-- The tokens are now stored as keyed hashes, which cannot be computed from SQL without the secret.
+-- The existing sessions are removed, along with their refresh tokens, so every user has to login again.
+DELETE FROM `tokens`;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS `password_reset_tokens`
+(
+    `id`         INT          NOT NULL AUTO_INCREMENT,
+    `user_id`    INT          NOT NULL,
+    `token`      VARCHAR(255) NOT NULL,
+    `created_at` DATETIME(6)  NOT NULL,
+    `expires_at` DATETIME(6)  NOT NULL,
+    `used_at`    DATETIME(6)  NULL DEFAULT NULL,
+
+    CONSTRAINT `fk_password_reset_tokens_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_password_reset_token` (`token`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS `login_failures`
+(
+    `login_key`      VARCHAR(255) NOT NULL,
+    `failures`       INT          NOT NULL,
+    `last_failed_at` DATETIME(6)  NOT NULL,
+    `locked_until`   DATETIME(6)  NULL DEFAULT NULL,
+
+    PRIMARY KEY (`login_key`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4;
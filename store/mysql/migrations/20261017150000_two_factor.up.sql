@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS `totp_secrets`
+(
+    `user_id`        INT            NOT NULL,
+    `secret`         VARBINARY(255) NOT NULL,
+    `created_at`     DATETIME(6)    NOT NULL,
+    `confirmed_at`   DATETIME(6)    NULL DEFAULT NULL,
+    `last_used_step` BIGINT         NOT NULL DEFAULT 0,
+
+    CONSTRAINT `fk_totp_secrets_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE,
+    PRIMARY KEY (`user_id`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `recovery_codes`
+(
+    `user_id` INT          NOT NULL,
+    `code`    VARCHAR(255) NOT NULL,
+
+    CONSTRAINT `fk_recovery_codes_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE,
+    PRIMARY KEY (`user_id`, `code`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `login_challenges`
+(
+    `id`         INT          NOT NULL AUTO_INCREMENT,
+    `user_id`    INT          NOT NULL,
+    `token`      VARCHAR(255) NOT NULL,
+    `created_at` DATETIME(6)  NOT NULL,
+    `expires_at` DATETIME(6)  NOT NULL,
+
+    CONSTRAINT `fk_login_challenges_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_login_challenge_token` (`token`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS `api_keys`
+(
+    `id`           INT          NOT NULL AUTO_INCREMENT,
+    `user_id`      INT          NOT NULL,
+    `api_key`      VARCHAR(255) NOT NULL,
+    `name`         VARCHAR(255) NOT NULL,
+    `prefix`       VARCHAR(255) NOT NULL,
+    `scopes`       VARCHAR(255) NOT NULL,
+    `created_at`   DATETIME(6)  NOT NULL,
+    `expires_at`   DATETIME(6)  NULL DEFAULT NULL,
+    `last_used_at` DATETIME(6)  NULL DEFAULT NULL,
+
+    CONSTRAINT `fk_api_keys_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_api_key` (`api_key`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS `user_identities`
+(
+    `issuer`  VARCHAR(255) NOT NULL,
+    `subject` VARCHAR(255) NOT NULL,
+    `user_id` INT          NOT NULL,
+
+    CONSTRAINT `fk_user_identities_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE,
+    PRIMARY KEY (`issuer`, `subject`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,19 @@
+ALTER TABLE `users`
+    ADD COLUMN `email`       VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN `verified_at` DATETIME(6)  NULL DEFAULT NULL;
+
+CREATE TABLE IF NOT EXISTS `email_verification_tokens`
+(
+    `id`         INT          NOT NULL AUTO_INCREMENT,
+    `user_id`    INT          NOT NULL,
+    `token`      VARCHAR(255) NOT NULL,
+    `email`      VARCHAR(255) NOT NULL,
+    `created_at` DATETIME(6)  NOT NULL,
+    `expires_at` DATETIME(6)  NOT NULL,
+    `used_at`    DATETIME(6)  NULL DEFAULT NULL,
+
+    CONSTRAINT `fk_email_verification_tokens_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_email_verification_token` (`token`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,12 @@
+-- The events shared between the servers, see the events/mysql package.
+CREATE TABLE IF NOT EXISTS `events`
+(
+    `id`         BIGINT      NOT NULL AUTO_INCREMENT,
+    `type`       VARCHAR(64) NOT NULL,
+    `payload`    MEDIUMTEXT  NOT NULL,
+    `created_at` DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+    PRIMARY KEY (`id`),
+    INDEX `idx_events_created_at` (`created_at`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,13 @@
+-- The events of the messages, written along with the messages and relayed by the server, see the outbox package.
+CREATE TABLE IF NOT EXISTS `outbox`
+(
+    `id`           BIGINT      NOT NULL AUTO_INCREMENT,
+    `type`         VARCHAR(64) NOT NULL,
+    `payload`      MEDIUMTEXT  NOT NULL,
+    `created_at`   DATETIME(6) NOT NULL,
+    `delivered_at` DATETIME(6) NULL DEFAULT NULL,
+
+    PRIMARY KEY (`id`),
+    INDEX `idx_outbox_delivered_at` (`delivered_at`)
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,5 @@
+-- The foreign key of recipient_id needs an index once the inbox index is dropped.
+ALTER TABLE `user_message_recipients`
+    ADD INDEX `idx_user_message_recipients_recipient_id` (`recipient_id`),
+    DROP INDEX `idx_user_message_recipients_inbox`,
+    DROP COLUMN `created_at`;
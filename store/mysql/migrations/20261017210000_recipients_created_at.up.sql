@@ -0,0 +1,11 @@
+-- The created_at of the messages is copied to their recipients, so the inbox of a user is paginated by an index of
+-- the recipients alone, instead of joining every message the user received.
+ALTER TABLE `user_message_recipients`
+    ADD COLUMN `created_at` DATETIME(6) NULL DEFAULT NULL;
+
+UPDATE `user_message_recipients` umr
+    INNER JOIN `messages` m ON umr.`message_id` = m.`id`
+SET umr.`created_at` = m.`created_at`;
+
+ALTER TABLE `user_message_recipients`
+    ADD INDEX `idx_user_message_recipients_inbox` (`recipient_id`, `created_at`, `message_id`);
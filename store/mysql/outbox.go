@@ -0,0 +1,61 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.OutboxStore = (*outboxStore)(nil)
+
+type outboxStore struct {
+	db *sql.DB
+}
+
+func (o *outboxStore) GetPending(ctx context.Context, limit int) ([]*store.OutboxEvent, error) {
+	rows, err := o.db.QueryContext(ctx, "SELECT id, type, payload, created_at FROM outbox WHERE delivered_at IS NULL ORDER BY id LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*store.OutboxEvent
+	for rows.Next() {
+		var e store.OutboxEvent
+
+		if err := rows.Scan(&e.ID, &e.Type, &e.Payload, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		events = append(events, &e)
+	}
+
+	return events, rows.Err()
+}
+
+// MarkDelivered returns ErrNotFound if the event does not exist.
+func (o *outboxStore) MarkDelivered(ctx context.Context, id int64, deliveredAt time.Time) error {
+	res, err := o.db.ExecContext(ctx, "UPDATE outbox SET delivered_at=? WHERE id=?", deliveredAt, id)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
+
+func (o *outboxStore) DeleteDelivered(ctx context.Context, before time.Time) (int64, error) {
+	res, err := o.db.ExecContext(ctx, "DELETE FROM outbox WHERE delivered_at < ?", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -11,11 +11,20 @@ import (
 var _ store.Store = (*Store)(nil)
 
 type Store struct {
-	db *sql.DB
+	db      *sql.DB
+	connStr string
 
 	messageStore *messageStore
 	userStore    *userStore
 	tokenStore   *tokenStore
+
+	refreshTokenStore      *refreshTokenStore
+	passwordResetStore     *passwordResetStore
+	emailVerificationStore *emailVerificationStore
+	loginFailureStore      *loginFailureStore
+	twoFactorStore         *twoFactorStore
+	apiKeyStore            *apiKeyStore
+	outboxStore            *outboxStore
 }
 
 func Connect(host string, port int, username, password, database string) (*Store, error) {
@@ -34,10 +43,18 @@ func Connect(host string, port int, username, password, database string) (*Store
 	}
 
 	s := &Store{
-		db:           db,
-		messageStore: &messageStore{db: db},
-		userStore:    &userStore{db: db},
-		tokenStore:   &tokenStore{db: db},
+		db:                     db,
+		connStr:                connStr,
+		messageStore:           &messageStore{db: db},
+		userStore:              &userStore{db: db},
+		tokenStore:             &tokenStore{db: db},
+		refreshTokenStore:      &refreshTokenStore{db: db},
+		passwordResetStore:     &passwordResetStore{db: db},
+		emailVerificationStore: &emailVerificationStore{db: db},
+		loginFailureStore:      &loginFailureStore{db: db},
+		twoFactorStore:         &twoFactorStore{db: db},
+		apiKeyStore:            &apiKeyStore{db: db},
+		outboxStore:            &outboxStore{db: db},
 	}
 
 	return s, nil
@@ -54,3 +71,36 @@ func (s *Store) User() store.UserStore {
 func (s *Store) Token() store.TokenStore {
 	return s.tokenStore
 }
+
+func (s *Store) RefreshToken() store.RefreshTokenStore {
+	return s.refreshTokenStore
+}
+
+func (s *Store) PasswordReset() store.PasswordResetStore {
+	return s.passwordResetStore
+}
+
+func (s *Store) EmailVerification() store.EmailVerificationStore {
+	return s.emailVerificationStore
+}
+
+func (s *Store) LoginFailure() store.LoginFailureStore {
+	return s.loginFailureStore
+}
+
+func (s *Store) TwoFactor() store.TwoFactorStore {
+	return s.twoFactorStore
+}
+
+func (s *Store) APIKey() store.APIKeyStore {
+	return s.apiKeyStore
+}
+
+func (s *Store) Outbox() store.OutboxStore {
+	return s.outboxStore
+}
+
+// DB returns the database of the store, e.g. to share it with the events.
+func (s *Store) DB() *sql.DB {
+	return s.db
+}
@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.EmailVerificationStore = (*emailVerificationStore)(nil)
+
+type emailVerificationStore struct {
+	db *sql.DB
+}
+
+func (p *emailVerificationStore) Create(ctx context.Context, token string, tok store.EmailVerificationToken) error {
+	_, err := p.db.ExecContext(ctx, "INSERT INTO email_verification_tokens(user_id, token, email, created_at, expires_at) VALUES($1,$2,$3,$4,$5)",
+		tok.UserID, token, tok.Email, tok.CreatedAt, tok.ExpiresAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return store.ErrDuplicate
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (p *emailVerificationStore) Get(ctx context.Context, token string) (*store.EmailVerificationToken, error) {
+	row := p.db.QueryRowContext(ctx, "SELECT id, user_id, email, created_at, expires_at, used_at FROM email_verification_tokens WHERE token=$1", token)
+
+	var tok store.EmailVerificationToken
+
+	err := row.Scan(&tok.ID, &tok.UserID, &tok.Email, &tok.CreatedAt, &tok.ExpiresAt, &tok.UsedAt)
+	if err == sql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &tok, nil
+}
+
+// MarkUsed returns ErrNotFound if the token does not exist or is already used.
+// The check and the update are a single statement, so only one of the concurrent callers succeeds.
+func (p *emailVerificationStore) MarkUsed(ctx context.Context, token string, usedAt time.Time) error {
+	res, err := p.db.ExecContext(ctx, "UPDATE email_verification_tokens SET used_at=$1 WHERE token=$2 AND used_at IS NULL", usedAt, token)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
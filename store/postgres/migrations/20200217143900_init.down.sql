@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS user_message_recipients;
+
+DROP TABLE IF EXISTS tokens;
+
+DROP TABLE IF EXISTS messages;
+
+DROP TABLE IF EXISTS users;
\ No newline at end of file
@@ -0,0 +1,46 @@
+CREATE TABLE IF NOT EXISTS users
+(
+    id            SERIAL       NOT NULL,
+    username      VARCHAR(255) NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+
+    PRIMARY KEY (id),
+    CONSTRAINT idx_username UNIQUE (username)
+);
+
+CREATE TABLE IF NOT EXISTS tokens
+(
+    user_id    INT          NOT NULL,
+    token      VARCHAR(255) NOT NULL,
+    updated_at TIMESTAMPTZ  NULL DEFAULT NULL,
+
+    CONSTRAINT fk_tokens_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (token),
+    CONSTRAINT idx_user_id UNIQUE (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS messages
+(
+    id         SERIAL       NOT NULL,
+    content    VARCHAR(255) NOT NULL,
+    sender_id  INT          NOT NULL,
+    created_at TIMESTAMPTZ  NULL DEFAULT NULL,
+    updated_at TIMESTAMPTZ  NULL DEFAULT NULL,
+
+    CONSTRAINT fk_messages_sender FOREIGN KEY (sender_id) REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_sender_id ON messages (sender_id);
+
+CREATE TABLE IF NOT EXISTS user_message_recipients
+(
+    message_id   INT NOT NULL,
+    recipient_id INT NOT NULL,
+
+    CONSTRAINT fk_user_message_recipients_message FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE,
+    CONSTRAINT fk_user_message_recipients_user FOREIGN KEY (recipient_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT idx_user_message_recipients UNIQUE (message_id, recipient_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_message_recipients_recipient_id ON user_message_recipients (recipient_id);
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS tokens;
+
+CREATE TABLE IF NOT EXISTS tokens
+(
+    user_id    INT          NOT NULL,
+    token      VARCHAR(255) NOT NULL,
+    updated_at TIMESTAMPTZ  NULL DEFAULT NULL,
+
+    CONSTRAINT fk_tokens_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (token),
+    CONSTRAINT idx_user_id UNIQUE (user_id)
+);
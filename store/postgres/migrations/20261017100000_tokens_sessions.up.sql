@@ -0,0 +1,18 @@
+-- A user can have many tokens, one per session. The existing tokens are dropped, so every user has to login again.
+DROP TABLE IF EXISTS tokens;
+
+CREATE TABLE IF NOT EXISTS tokens
+(
+    id           SERIAL       NOT NULL,
+    user_id      INT          NOT NULL,
+    token        VARCHAR(255) NOT NULL,
+    user_agent   VARCHAR(255) NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ  NOT NULL,
+    last_used_at TIMESTAMPTZ  NOT NULL,
+
+    CONSTRAINT fk_tokens_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (id),
+    CONSTRAINT idx_token UNIQUE (token)
+);
+
+CREATE INDEX IF NOT EXISTS idx_tokens_user_id ON tokens (user_id);
@@ -0,0 +1,26 @@
+-- The existing tokens keep the previous fixed lifetime of 24 hours.
+ALTER TABLE tokens
+    ADD COLUMN expires_at TIMESTAMPTZ;
+
+UPDATE tokens SET expires_at = created_at + INTERVAL '1 day';
+
+ALTER TABLE tokens
+    ALTER COLUMN expires_at SET NOT NULL;
+
+CREATE TABLE IF NOT EXISTS refresh_tokens
+(
+    id         SERIAL       NOT NULL,
+    session_id INT          NOT NULL,
+    user_id    INT          NOT NULL,
+    token      VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ  NOT NULL,
+    expires_at TIMESTAMPTZ  NOT NULL,
+    used_at    TIMESTAMPTZ  NULL DEFAULT NULL,
+
+    CONSTRAINT fk_refresh_tokens_session FOREIGN KEY (session_id) REFERENCES tokens (id) ON DELETE CASCADE,
+    CONSTRAINT fk_refresh_tokens_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (id),
+    CONSTRAINT idx_refresh_token UNIQUE (token)
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_id ON refresh_tokens (session_id);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS password_reset_tokens
+(
+    id         SERIAL       NOT NULL,
+    user_id    INT          NOT NULL,
+    token      VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ  NOT NULL,
+    expires_at TIMESTAMPTZ  NOT NULL,
+    used_at    TIMESTAMPTZ  NULL DEFAULT NULL,
+
+    CONSTRAINT fk_password_reset_tokens_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (id),
+    CONSTRAINT idx_password_reset_token UNIQUE (token)
+);
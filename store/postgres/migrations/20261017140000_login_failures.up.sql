@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS login_failures
+(
+    login_key      VARCHAR(255) NOT NULL,
+    failures       INT          NOT NULL,
+    last_failed_at TIMESTAMPTZ  NOT NULL,
+    locked_until   TIMESTAMPTZ  NULL DEFAULT NULL,
+
+    PRIMARY KEY (login_key)
+);
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS totp_secrets
+(
+    user_id        INT         NOT NULL,
+    secret         BYTEA       NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL,
+    confirmed_at   TIMESTAMPTZ NULL DEFAULT NULL,
+    last_used_step BIGINT      NOT NULL DEFAULT 0,
+
+    CONSTRAINT fk_totp_secrets_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes
+(
+    user_id INT          NOT NULL,
+    code    VARCHAR(255) NOT NULL,
+
+    CONSTRAINT fk_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, code)
+);
+
+CREATE TABLE IF NOT EXISTS login_challenges
+(
+    id         SERIAL       NOT NULL,
+    user_id    INT          NOT NULL,
+    token      VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ  NOT NULL,
+    expires_at TIMESTAMPTZ  NOT NULL,
+
+    CONSTRAINT fk_login_challenges_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (id),
+    CONSTRAINT idx_login_challenge_token UNIQUE (token)
+);
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id           SERIAL       NOT NULL,
+    user_id      INT          NOT NULL,
+    api_key      VARCHAR(255) NOT NULL,
+    name         VARCHAR(255) NOT NULL,
+    prefix       VARCHAR(255) NOT NULL,
+    scopes       VARCHAR(255) NOT NULL,
+    created_at   TIMESTAMPTZ  NOT NULL,
+    expires_at   TIMESTAMPTZ  NULL DEFAULT NULL,
+    last_used_at TIMESTAMPTZ  NULL DEFAULT NULL,
+
+    CONSTRAINT fk_api_keys_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (id),
+    CONSTRAINT idx_api_key UNIQUE (api_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys (user_id);
@@ -0,0 +1,18 @@
+ALTER TABLE users
+    ADD COLUMN email       VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN verified_at TIMESTAMPTZ  NULL DEFAULT NULL;
+
+CREATE TABLE IF NOT EXISTS email_verification_tokens
+(
+    id         SERIAL       NOT NULL,
+    user_id    INT          NOT NULL,
+    token      VARCHAR(255) NOT NULL,
+    email      VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ  NOT NULL,
+    expires_at TIMESTAMPTZ  NOT NULL,
+    used_at    TIMESTAMPTZ  NULL DEFAULT NULL,
+
+    CONSTRAINT fk_email_verification_tokens_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (id),
+    CONSTRAINT idx_email_verification_token UNIQUE (token)
+);
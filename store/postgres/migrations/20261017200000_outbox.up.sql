@@ -0,0 +1,13 @@
+-- The events of the messages, written along with the messages and relayed by the server, see the outbox package.
+CREATE TABLE IF NOT EXISTS outbox
+(
+    id           BIGSERIAL   NOT NULL,
+    type         VARCHAR(64) NOT NULL,
+    payload      TEXT        NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL,
+    delivered_at TIMESTAMPTZ NULL DEFAULT NULL,
+
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_delivered_at ON outbox (delivered_at);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_user_message_recipients_inbox;
+
+ALTER TABLE user_message_recipients
+    DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,11 @@
+-- The created_at of the messages is copied to their recipients, so the inbox of a user is paginated by an index of
+-- the recipients alone, instead of joining every message the user received.
+ALTER TABLE user_message_recipients
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NULL DEFAULT NULL;
+
+UPDATE user_message_recipients umr
+SET created_at = m.created_at
+FROM messages m
+WHERE umr.message_id = m.id;
+
+CREATE INDEX IF NOT EXISTS idx_user_message_recipients_inbox ON user_message_recipients (recipient_id, created_at, message_id);
@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.PasswordResetStore = (*passwordResetStore)(nil)
+
+type passwordResetStore struct {
+	db *sql.DB
+}
+
+func (p *passwordResetStore) Create(ctx context.Context, token string, tok store.PasswordResetToken) error {
+	_, err := p.db.ExecContext(ctx, "INSERT INTO password_reset_tokens(user_id, token, created_at, expires_at) VALUES($1,$2,$3,$4)",
+		tok.UserID, token, tok.CreatedAt, tok.ExpiresAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return store.ErrDuplicate
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (p *passwordResetStore) Get(ctx context.Context, token string) (*store.PasswordResetToken, error) {
+	row := p.db.QueryRowContext(ctx, "SELECT id, user_id, created_at, expires_at, used_at FROM password_reset_tokens WHERE token=$1", token)
+
+	var tok store.PasswordResetToken
+
+	err := row.Scan(&tok.ID, &tok.UserID, &tok.CreatedAt, &tok.ExpiresAt, &tok.UsedAt)
+	if err == sql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &tok, nil
+}
+
+// MarkUsed returns ErrNotFound if the token does not exist or is already used.
+// The check and the update are a single statement, so only one of the concurrent callers succeeds.
+func (p *passwordResetStore) MarkUsed(ctx context.Context, token string, usedAt time.Time) error {
+	res, err := p.db.ExecContext(ctx, "UPDATE password_reset_tokens SET used_at=$1 WHERE token=$2 AND used_at IS NULL", usedAt, token)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
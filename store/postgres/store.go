@@ -0,0 +1,110 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+	"github.com/lib/pq"
+)
+
+var _ store.Store = (*Store)(nil)
+
+type Store struct {
+	db      *sql.DB
+	connStr string
+
+	messageStore *messageStore
+	userStore    *userStore
+	tokenStore   *tokenStore
+
+	refreshTokenStore      *refreshTokenStore
+	passwordResetStore     *passwordResetStore
+	emailVerificationStore *emailVerificationStore
+	loginFailureStore      *loginFailureStore
+	twoFactorStore         *twoFactorStore
+	apiKeyStore            *apiKeyStore
+	outboxStore            *outboxStore
+}
+
+func Connect(host string, port int, username, password, database, sslMode string) (*Store, error) {
+	connStr := fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		host, port, username, password, database, sslMode,
+	)
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		db:                     db,
+		connStr:                connStr,
+		messageStore:           &messageStore{db: db},
+		userStore:              &userStore{db: db},
+		tokenStore:             &tokenStore{db: db},
+		refreshTokenStore:      &refreshTokenStore{db: db},
+		passwordResetStore:     &passwordResetStore{db: db},
+		emailVerificationStore: &emailVerificationStore{db: db},
+		loginFailureStore:      &loginFailureStore{db: db},
+		twoFactorStore:         &twoFactorStore{db: db},
+		apiKeyStore:            &apiKeyStore{db: db},
+		outboxStore:            &outboxStore{db: db},
+	}
+
+	return s, nil
+}
+
+func (s *Store) Message() store.MessageStore {
+	return s.messageStore
+}
+
+func (s *Store) User() store.UserStore {
+	return s.userStore
+}
+
+func (s *Store) Token() store.TokenStore {
+	return s.tokenStore
+}
+
+func (s *Store) RefreshToken() store.RefreshTokenStore {
+	return s.refreshTokenStore
+}
+
+func (s *Store) PasswordReset() store.PasswordResetStore {
+	return s.passwordResetStore
+}
+
+func (s *Store) EmailVerification() store.EmailVerificationStore {
+	return s.emailVerificationStore
+}
+
+func (s *Store) LoginFailure() store.LoginFailureStore {
+	return s.loginFailureStore
+}
+
+func (s *Store) TwoFactor() store.TwoFactorStore {
+	return s.twoFactorStore
+}
+
+func (s *Store) APIKey() store.APIKeyStore {
+	return s.apiKeyStore
+}
+
+func (s *Store) Outbox() store.OutboxStore {
+	return s.outboxStore
+}
+
+// isUniqueViolation reports whether err is a unique_violation error.
+func isUniqueViolation(err error) bool {
+	if sqlErr, ok := err.(*pq.Error); ok {
+		return sqlErr.Code == "23505"
+	}
+
+	return false
+}
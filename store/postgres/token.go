@@ -0,0 +1,153 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.TokenStore = (*tokenStore)(nil)
+
+type tokenStore struct {
+	db *sql.DB
+}
+
+func (t *tokenStore) Create(ctx context.Context, token string, tok store.Token) error {
+	_, err := t.db.ExecContext(ctx, "INSERT INTO tokens(user_id, token, user_agent, created_at, last_used_at, expires_at) VALUES($1,$2,$3,$4,$5,$6)",
+		tok.UserID, token, tok.UserAgent, tok.CreatedAt, tok.LastUsedAt, tok.ExpiresAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return store.ErrDuplicate
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (t *tokenStore) GetUserID(ctx context.Context, userToken string) (*store.Token, error) {
+	row := t.db.QueryRowContext(ctx, "SELECT id, user_id, user_agent, created_at, last_used_at, expires_at FROM tokens WHERE token=$1", userToken)
+
+	var token store.Token
+
+	err := row.Scan(&token.ID, &token.UserID, &token.UserAgent, &token.CreatedAt, &token.LastUsedAt, &token.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// GetByID returns ErrNotFound if the session does not exist.
+func (t *tokenStore) GetByID(ctx context.Context, id int64) (*store.Token, error) {
+	row := t.db.QueryRowContext(ctx, "SELECT id, user_id, user_agent, created_at, last_used_at, expires_at FROM tokens WHERE id=$1", id)
+
+	var token store.Token
+
+	err := row.Scan(&token.ID, &token.UserID, &token.UserAgent, &token.CreatedAt, &token.LastUsedAt, &token.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// Renew returns ErrNotFound if the session does not exist.
+func (t *tokenStore) Renew(ctx context.Context, id int64, token string, expiresAt time.Time) error {
+	res, err := t.db.ExecContext(ctx, "UPDATE tokens SET token=$1, expires_at=$2 WHERE id=$3", token, expiresAt, id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return store.ErrDuplicate
+		}
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
+
+// Delete returns ErrNotFound if the token does not exist.
+func (t *tokenStore) Delete(ctx context.Context, userToken string) error {
+	res, err := t.db.ExecContext(ctx, "DELETE FROM tokens WHERE token=$1", userToken)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
+
+// DeleteByID returns ErrNotFound if the session does not exist.
+// The refresh tokens of the session are removed by the cascading foreign key.
+func (t *tokenStore) DeleteByID(ctx context.Context, id int64) error {
+	res, err := t.db.ExecContext(ctx, "DELETE FROM tokens WHERE id=$1", id)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
+
+// Touch returns ErrNotFound if the token does not exist.
+func (t *tokenStore) Touch(ctx context.Context, userToken string, lastUsedAt time.Time) error {
+	res, err := t.db.ExecContext(ctx, "UPDATE tokens SET last_used_at=$1 WHERE token=$2", lastUsedAt, userToken)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
+
+func (t *tokenStore) DeleteByUserID(ctx context.Context, userID int64) error {
+	_, err := t.db.ExecContext(ctx, "DELETE FROM tokens WHERE user_id=$1", userID)
+	return err
+}
+
+func (t *tokenStore) List(ctx context.Context, userID int64) ([]*store.Token, error) {
+	rows, err := t.db.QueryContext(ctx, "SELECT id, user_id, user_agent, created_at, last_used_at, expires_at FROM tokens WHERE user_id=$1 ORDER BY created_at DESC, id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*store.Token
+	for rows.Next() {
+		var token store.Token
+
+		if err := rows.Scan(&token.ID, &token.UserID, &token.UserAgent, &token.CreatedAt, &token.LastUsedAt, &token.ExpiresAt); err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, &token)
+	}
+
+	return tokens, rows.Err()
+}
@@ -0,0 +1,147 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.UserStore = (*userStore)(nil)
+
+type userStore struct {
+	db *sql.DB
+}
+
+func (s *userStore) Create(ctx context.Context, username, passwordHash string) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO users(username, password_hash) VALUES ($1, $2)", username, passwordHash)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return store.ErrDuplicate
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *userStore) GetByUsername(ctx context.Context, username string) (*store.User, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT id, username, password_hash, email, verified_at FROM users WHERE username=$1", username)
+
+	var u store.User
+	err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Email, &u.VerifiedAt)
+	if err == sql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+func (s *userStore) GetByID(ctx context.Context, id int64) (*store.User, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT id, username, password_hash, email, verified_at FROM users WHERE id=$1", id)
+
+	var u store.User
+	err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Email, &u.VerifiedAt)
+	if err == sql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+// UpdatePasswordHash returns ErrNotFound if the user does not exist.
+func (s *userStore) UpdatePasswordHash(ctx context.Context, id int64, passwordHash string) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE users SET password_hash=$1 WHERE id=$2", passwordHash, id)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
+
+// SetEmail returns ErrNotFound if the user does not exist.
+func (s *userStore) SetEmail(ctx context.Context, id int64, email string) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE users SET email=$1, verified_at=NULL WHERE id=$2", email, id)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
+
+// VerifyEmail returns ErrNotFound if the user does not exist or the address is no longer email.
+func (s *userStore) VerifyEmail(ctx context.Context, id int64, email string, verifiedAt time.Time) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE users SET verified_at=$1 WHERE id=$2 AND email=$3", verifiedAt, id, email)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *userStore) GetByIdentity(ctx context.Context, issuer, subject string) (*store.User, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT u.id, u.username, u.password_hash, u.email, u.verified_at FROM users u JOIN user_identities i ON i.user_id=u.id WHERE i.issuer=$1 AND i.subject=$2", issuer, subject)
+
+	var u store.User
+	err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Email, &u.VerifiedAt)
+	if err == sql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+// LinkIdentity returns ErrDuplicate if the subject is already linked to a user.
+func (s *userStore) LinkIdentity(ctx context.Context, userID int64, issuer, subject string) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO user_identities(issuer, subject, user_id) VALUES($1,$2,$3)", issuer, subject, userID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return store.ErrDuplicate
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Delete returns ErrNotFound if the user does not exist.
+// The user's tokens and messages are removed by the cascading foreign keys.
+func (s *userStore) Delete(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM users WHERE id=$1", id)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.APIKeyStore = (*apiKeyStore)(nil)
+
+type apiKeyStore struct {
+	db *sql.DB
+}
+
+func (a *apiKeyStore) Create(ctx context.Context, key string, k store.APIKey, limit int) (int64, error) {
+	var expiresAt *time.Time
+	if k.ExpiresAt != nil {
+		t := k.ExpiresAt.UTC()
+		expiresAt = &t
+	}
+
+	// The keys are counted by the insert itself, a single statement which SQLite runs alone.
+	res, err := a.db.ExecContext(ctx, `INSERT INTO api_keys(user_id, api_key, name, prefix, scopes, created_at, expires_at)
+		SELECT ?,?,?,?,?,?,? WHERE (SELECT COUNT(*) FROM api_keys WHERE user_id=?) < ?`,
+		k.UserID, key, k.Name, k.Prefix, strings.Join(k.Scopes, " "), k.CreatedAt.UTC(), expiresAt, k.UserID, limit)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, store.ErrDuplicate
+		}
+		return 0, err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return 0, err
+	} else if affected < 1 {
+		return 0, store.ErrLimit
+	}
+
+	return res.LastInsertId()
+}
+
+func (a *apiKeyStore) Get(ctx context.Context, key string) (*store.APIKey, error) {
+	row := a.db.QueryRowContext(ctx, "SELECT id, user_id, name, prefix, scopes, created_at, expires_at, last_used_at FROM api_keys WHERE api_key=?", key)
+
+	k, err := scanAPIKey(row)
+	if err == sql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func (a *apiKeyStore) List(ctx context.Context, userID int64) ([]*store.APIKey, error) {
+	rows, err := a.db.QueryContext(ctx, "SELECT id, user_id, name, prefix, scopes, created_at, expires_at, last_used_at FROM api_keys WHERE user_id=? ORDER BY created_at DESC, id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*store.APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+// Touch returns ErrNotFound if the key does not exist.
+func (a *apiKeyStore) Touch(ctx context.Context, id int64, lastUsedAt time.Time) error {
+	res, err := a.db.ExecContext(ctx, "UPDATE api_keys SET last_used_at=? WHERE id=?", lastUsedAt.UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
+
+// Delete returns ErrNotFound if the user has no such key.
+func (a *apiKeyStore) Delete(ctx context.Context, userID, id int64) error {
+	res, err := a.db.ExecContext(ctx, "DELETE FROM api_keys WHERE id=? AND user_id=?", id, userID)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
+
+// scanner is a *sql.Row or *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAPIKey reads an API key, whose scopes are kept in a column separated by spaces.
+func scanAPIKey(row scanner) (*store.APIKey, error) {
+	var k store.APIKey
+	var scopes string
+
+	err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &scopes, &k.CreatedAt, &k.ExpiresAt, &k.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	k.Scopes = strings.Fields(scopes)
+
+	return &k, nil
+}
@@ -0,0 +1,21 @@
+//go:build cgo
+
+package sqlite
+
+import (
+	"github.com/mattn/go-sqlite3"
+)
+
+// checkCgo fails without cgo only, see nocgo.go.
+func checkCgo() error {
+	return nil
+}
+
+// isUniqueViolation reports whether err is a unique or primary key constraint error.
+func isUniqueViolation(err error) bool {
+	if sqlErr, ok := err.(sqlite3.Error); ok {
+		return sqlErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqlErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+
+	return false
+}
@@ -0,0 +1,93 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.LoginFailureStore = (*loginFailureStore)(nil)
+
+type loginFailureStore struct {
+	db *sql.DB
+}
+
+func (l *loginFailureStore) Get(ctx context.Context, key string) (*store.LoginFailures, error) {
+	row := l.db.QueryRowContext(ctx, "SELECT login_key, failures, last_failed_at, locked_until FROM login_failures WHERE login_key=?", key)
+
+	var f store.LoginFailures
+	var lockedUntil *time.Time
+
+	err := row.Scan(&f.Key, &f.Count, &f.LastFailedAt, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if lockedUntil != nil {
+		f.LockedUntil = *lockedUntil
+	}
+
+	return &f, nil
+}
+
+// Add counts the failed login in a single statement, so the concurrent failed logins on every server are all counted.
+func (l *loginFailureStore) Add(ctx context.Context, key string, failedAt, resetBefore time.Time) (*store.LoginFailures, error) {
+	_, err := l.db.ExecContext(ctx, `INSERT INTO login_failures(login_key, failures, last_failed_at) VALUES(?,1,?)
+		ON CONFLICT (login_key) DO UPDATE
+		SET failures=CASE WHEN last_failed_at < ? THEN 1 ELSE failures+1 END, last_failed_at=excluded.last_failed_at`,
+		key, failedAt.UTC(), resetBefore.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	return l.Get(ctx, key)
+}
+
+func (l *loginFailureStore) Undo(ctx context.Context, key string) error {
+	res, err := l.db.ExecContext(ctx, "UPDATE login_failures SET failures=failures-1 WHERE login_key=? AND failures > 0", key)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
+
+func (l *loginFailureStore) Lock(ctx context.Context, key string, until time.Time) error {
+	res, err := l.db.ExecContext(ctx, "UPDATE login_failures SET locked_until=? WHERE login_key=?", until, key)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
+
+func (l *loginFailureStore) Delete(ctx context.Context, key string) error {
+	res, err := l.db.ExecContext(ctx, "DELETE FROM login_failures WHERE login_key=?", key)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
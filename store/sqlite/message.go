@@ -0,0 +1,427 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+const (
+	getQuery = `
+SELECT umr.message_id, mj.content, mj.username, mj.sender_id, mj.created_at, mj.updated_at
+FROM user_message_recipients umr
+    INNER JOIN (
+        SELECT m.id, m.content, m.sender_id, m.created_at, m.updated_at, u.username
+        FROM messages m
+            INNER JOIN (
+                SELECT id, username
+                FROM users
+            ) u ON m.sender_id = u.id
+    ) mj ON umr.message_id = mj.id
+`
+	getQueryByUserID = getQuery + " WHERE umr.recipient_id = ? ORDER BY umr.message_id;"
+
+	getPageQueryByUserID = getQuery + " WHERE umr.recipient_id = ? ORDER BY umr.created_at DESC, umr.message_id DESC LIMIT ?;"
+
+	// Keyset pagination, the messages older than the cursor. The pages are read from the recipients' copy of
+	// created_at, which idx_user_message_recipients_inbox covers.
+	getPageAfterQueryByUserID = getQuery + `
+WHERE umr.recipient_id = ? AND (umr.created_at < ? OR (umr.created_at = ? AND umr.message_id < ?))
+ORDER BY umr.created_at DESC, umr.message_id DESC LIMIT ?;`
+
+	// The recipients are loaded by setRecipients.
+	getSentQuery = `
+SELECT m.id, m.content, u.username, m.sender_id, m.created_at, m.updated_at
+FROM messages m
+    INNER JOIN users u ON m.sender_id = u.id
+`
+	getSentOrderBy = " ORDER BY m.created_at DESC, m.id DESC"
+
+	getSentQueryBySenderID = getSentQuery + " WHERE m.sender_id = ?" + getSentOrderBy + " LIMIT ?;"
+
+	getSentAfterQueryBySenderID = getSentQuery + " WHERE m.sender_id = ? AND (m.created_at < ? OR (m.created_at = ? AND m.id < ?))" + getSentOrderBy + " LIMIT ?;"
+
+	getQueryByMessageID = getQuery + " WHERE umr.message_id = ?;"
+
+	// The message without its recipients, for the outbox.
+	getMessageQuery = `
+SELECT m.id, m.content, u.username, m.sender_id, m.created_at, m.updated_at
+FROM messages m
+    INNER JOIN users u ON m.sender_id = u.id
+WHERE m.id = ?;`
+)
+
+var _ store.MessageStore = (*messageStore)(nil)
+
+type messageStore struct {
+	db *sql.DB
+}
+
+func (s *messageStore) Create(ctx context.Context, msg store.Message, recipientUserIDs []int64) (int64, error) {
+	messageID, err := s.create(ctx, msg, recipientUserIDs)
+	if err == nil {
+		return messageID, nil
+	}
+
+	if isUniqueViolation(err) {
+		return 0, store.ErrDuplicate
+	}
+	return 0, err
+}
+
+func (s *messageStore) GetByID(ctx context.Context, msgID int64) (*store.Message, error) {
+	row := s.db.QueryRowContext(ctx, getQueryByMessageID, msgID)
+
+	var msg store.Message
+
+	err := row.Scan(&msg.ID, &msg.Content, &msg.Sender, &msg.SenderID, &msg.SentDateTime, &msg.UpdatedDateTime)
+	if err == sql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
+func (s *messageStore) Get(ctx context.Context, userID int64) ([]*store.Message, error) {
+	rows, err := s.db.QueryContext(ctx, getQueryByUserID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMessages(rows)
+}
+
+// GetPage compares the times as text, so they are always stored in UTC to keep the order.
+func (s *messageStore) GetPage(ctx context.Context, userID int64, after *store.Cursor, limit int) ([]*store.Message, error) {
+	var rows *sql.Rows
+	var err error
+
+	if after == nil {
+		rows, err = s.db.QueryContext(ctx, getPageQueryByUserID, userID, limit)
+	} else {
+		rows, err = s.db.QueryContext(ctx, getPageAfterQueryByUserID, userID, after.SentDateTime.UTC(), after.SentDateTime.UTC(), after.ID, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMessages(rows)
+}
+
+func (s *messageStore) GetSent(ctx context.Context, senderID int64, after *store.Cursor, limit int) ([]*store.Message, error) {
+	var rows *sql.Rows
+	var err error
+
+	if after == nil {
+		rows, err = s.db.QueryContext(ctx, getSentQueryBySenderID, senderID, limit)
+	} else {
+		rows, err = s.db.QueryContext(ctx, getSentAfterQueryBySenderID, senderID, after.SentDateTime.UTC(), after.SentDateTime.UTC(), after.ID, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setRecipients(ctx, s.db, messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (s *messageStore) GetRecipients(ctx context.Context, msgID int64) ([]int64, error) {
+	return getRecipients(ctx, s.db, msgID)
+}
+
+// Update returns ErrNotFound if the message does not exist.
+func (s *messageStore) Update(ctx context.Context, msg store.Message, recipientUserIDs []int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, "UPDATE messages SET content=?, updated_at=? WHERE id=?", msg.Content, msg.UpdatedDateTime.UTC(), msg.ID)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	// Check if message does not exist
+	if affected, err := res.RowsAffected(); err != nil {
+		_ = tx.Rollback()
+		return err
+	} else if affected < 1 {
+		_ = tx.Rollback()
+		return store.ErrNotFound
+	}
+
+	previousRecipients, err := getRecipients(ctx, tx, msg.ID)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	// Delete the existing recipients.
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_message_recipients WHERE message_id=?", msg.ID)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	// Add the new recipients.
+	err = s.createRecipients(ctx, tx, msg.ID, recipientUserIDs)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	err = writeMessageEvent(ctx, tx, store.EventMessageUpdated, msg.ID, recipientUserIDs, previousRecipients)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete returns ErrNotFound if the message does not exist.
+func (s *messageStore) Delete(ctx context.Context, messageID int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// The event is read before the message is deleted. Writing first takes the write lock, so no other write can
+	// come between the read and the delete.
+	res, err := tx.ExecContext(ctx, "UPDATE messages SET updated_at=updated_at WHERE id=?", messageID)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	// Check if message does not exist
+	if affected, err := res.RowsAffected(); err != nil {
+		_ = tx.Rollback()
+		return err
+	} else if affected < 1 {
+		_ = tx.Rollback()
+		return store.ErrNotFound
+	}
+
+	event, err := getMessageEvent(ctx, tx, messageID)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM messages WHERE id=?", messageID)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	err = insertOutboxEvent(ctx, tx, store.EventMessageDeleted, event)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *messageStore) create(ctx context.Context, msg store.Message, recipientUserIDs []int64) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO messages(content, sender_id, created_at, updated_at) VALUES (?, ?, ?, ?)",
+		msg.Content, msg.SenderID, msg.SentDateTime.UTC(), msg.SentDateTime.UTC())
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	messageID, err := res.LastInsertId()
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	err = s.createRecipients(ctx, tx, messageID, recipientUserIDs)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	err = writeMessageEvent(ctx, tx, store.EventMessageCreated, messageID, recipientUserIDs, nil)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return messageID, nil
+}
+
+// createRecipients copies the created_at of the message to its recipients, for the pagination of the inboxes.
+func (s *messageStore) createRecipients(ctx context.Context, tx *sql.Tx, messageID int64, recipientUserIDs []int64) error {
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO user_message_recipients(message_id, recipient_id, created_at) SELECT id, ?, created_at FROM messages WHERE id=?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, rec := range recipientUserIDs {
+		_, err := stmt.ExecContext(ctx, rec, messageID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// queryer is either the database or a transaction.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func getRecipients(ctx context.Context, q queryer, msgID int64) ([]int64, error) {
+	rows, err := q.QueryContext(ctx, "SELECT recipient_id FROM user_message_recipients WHERE message_id=? ORDER BY recipient_id", msgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []int64
+	for rows.Next() {
+		var id int64
+
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		recipients = append(recipients, id)
+	}
+
+	return recipients, rows.Err()
+}
+
+// getMessageEvent returns the event of the message as it is now, along with its recipients.
+func getMessageEvent(ctx context.Context, q queryer, msgID int64) (*store.MessageEvent, error) {
+	var msg store.Message
+
+	err := q.QueryRowContext(ctx, getMessageQuery, msgID).Scan(&msg.ID, &msg.Content, &msg.Sender, &msg.SenderID, &msg.SentDateTime, &msg.UpdatedDateTime)
+	if err == sql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	recipients, err := getRecipients(ctx, q, msgID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &store.MessageEvent{
+		Message:      &msg,
+		SenderID:     msg.SenderID,
+		RecipientIDs: recipients,
+	}, nil
+}
+
+// writeMessageEvent writes the event of the message, which is read in the transaction of the change.
+func writeMessageEvent(ctx context.Context, tx *sql.Tx, eventType string, msgID int64, recipientUserIDs, previousRecipientUserIDs []int64) error {
+	event, err := getMessageEvent(ctx, tx, msgID)
+	if err != nil {
+		return err
+	}
+
+	event.RecipientIDs = recipientUserIDs
+	event.PreviousRecipientIDs = previousRecipientUserIDs
+
+	return insertOutboxEvent(ctx, tx, eventType, event)
+}
+
+func insertOutboxEvent(ctx context.Context, tx *sql.Tx, eventType string, event *store.MessageEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO outbox(type, payload, created_at) VALUES (?, ?, ?)",
+		eventType, payload, time.Now().UTC())
+
+	return err
+}
+
+func scanMessages(rows *sql.Rows) ([]*store.Message, error) {
+	var messages []*store.Message
+	for rows.Next() {
+		var msg store.Message
+
+		if err := rows.Scan(&msg.ID, &msg.Content, &msg.Sender, &msg.SenderID, &msg.SentDateTime, &msg.UpdatedDateTime); err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, &msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// setRecipients loads the sorted recipients of the messages with a single query, however many they are.
+func setRecipients(ctx context.Context, q queryer, messages []*store.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*store.Message, len(messages))
+	placeholders := make([]string, len(messages))
+	args := make([]interface{}, len(messages))
+
+	for i, msg := range messages {
+		byID[msg.ID] = msg
+		placeholders[i] = "?"
+		args[i] = msg.ID
+	}
+
+	rows, err := q.QueryContext(ctx, "SELECT message_id, recipient_id FROM user_message_recipients WHERE message_id IN ("+
+		strings.Join(placeholders, ",")+") ORDER BY message_id, recipient_id", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msgID, recipientID int64
+
+		if err := rows.Scan(&msgID, &recipientID); err != nil {
+			return err
+		}
+
+		if msg, ok := byID[msgID]; ok {
+			msg.Recipients = append(msg.Recipients, recipientID)
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,45 @@
+CREATE TABLE IF NOT EXISTS `users`
+(
+    `id`            INTEGER      NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `username`      VARCHAR(255) NOT NULL,
+    `password_hash` VARCHAR(255) NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_username` ON `users` (`username`);
+
+CREATE TABLE IF NOT EXISTS `tokens`
+(
+    `user_id`    INTEGER      NOT NULL,
+    `token`      VARCHAR(255) NOT NULL PRIMARY KEY,
+    `updated_at` DATETIME     NULL DEFAULT NULL,
+
+    CONSTRAINT `fk_tokens_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_user_id` ON `tokens` (`user_id`);
+
+CREATE TABLE IF NOT EXISTS `messages`
+(
+    `id`         INTEGER      NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `content`    VARCHAR(255) NOT NULL,
+    `sender_id`  INTEGER      NOT NULL,
+    `created_at` DATETIME     NULL DEFAULT NULL,
+    `updated_at` DATETIME     NULL DEFAULT NULL,
+
+    CONSTRAINT `fk_messages_sender` FOREIGN KEY (`sender_id`) REFERENCES `users` (`id`) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS `idx_messages_sender_id` ON `messages` (`sender_id`);
+
+CREATE TABLE IF NOT EXISTS `user_message_recipients`
+(
+    `message_id`   INTEGER NOT NULL,
+    `recipient_id` INTEGER NOT NULL,
+
+    CONSTRAINT `fk_user_message_recipients_message` FOREIGN KEY (`message_id`) REFERENCES `messages` (`id`) ON DELETE CASCADE,
+    CONSTRAINT `fk_user_message_recipients_user` FOREIGN KEY (`recipient_id`) REFERENCES `users` (`id`) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_user_message_recipients` ON `user_message_recipients` (`message_id`, `recipient_id`);
+
+CREATE INDEX IF NOT EXISTS `idx_user_message_recipients_recipient_id` ON `user_message_recipients` (`recipient_id`);
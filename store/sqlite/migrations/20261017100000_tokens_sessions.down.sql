@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS `tokens`;
+
+CREATE TABLE IF NOT EXISTS `tokens`
+(
+    `user_id`    INTEGER      NOT NULL,
+    `token`      VARCHAR(255) NOT NULL PRIMARY KEY,
+    `updated_at` DATETIME     NULL DEFAULT NULL,
+
+    CONSTRAINT `fk_tokens_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_user_id` ON `tokens` (`user_id`);
@@ -0,0 +1,18 @@
+-- A user can have many tokens, one per session. The existing tokens are dropped, so every user has to login again.
+DROP TABLE IF EXISTS `tokens`;
+
+CREATE TABLE IF NOT EXISTS `tokens`
+(
+    `id`           INTEGER      NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `user_id`      INTEGER      NOT NULL,
+    `token`        VARCHAR(255) NOT NULL,
+    `user_agent`   VARCHAR(255) NOT NULL DEFAULT '',
+    `created_at`   DATETIME     NOT NULL,
+    `last_used_at` DATETIME     NOT NULL,
+
+    CONSTRAINT `fk_tokens_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_token` ON `tokens` (`token`);
+
+CREATE INDEX IF NOT EXISTS `idx_tokens_user_id` ON `tokens` (`user_id`);
@@ -0,0 +1,26 @@
+DROP TABLE IF EXISTS `refresh_tokens`;
+
+-- SQLite before 3.35 cannot drop a column, so the tokens table is rebuilt without it.
+CREATE TABLE `tokens_old`
+(
+    `id`           INTEGER      NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `user_id`      INTEGER      NOT NULL,
+    `token`        VARCHAR(255) NOT NULL,
+    `user_agent`   VARCHAR(255) NOT NULL DEFAULT '',
+    `created_at`   DATETIME     NOT NULL,
+    `last_used_at` DATETIME     NOT NULL,
+
+    CONSTRAINT `fk_tokens_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE
+);
+
+INSERT INTO `tokens_old` (`id`, `user_id`, `token`, `user_agent`, `created_at`, `last_used_at`)
+SELECT `id`, `user_id`, `token`, `user_agent`, `created_at`, `last_used_at`
+FROM `tokens`;
+
+DROP TABLE `tokens`;
+
+ALTER TABLE `tokens_old` RENAME TO `tokens`;
+
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_token` ON `tokens` (`token`);
+
+CREATE INDEX IF NOT EXISTS `idx_tokens_user_id` ON `tokens` (`user_id`);
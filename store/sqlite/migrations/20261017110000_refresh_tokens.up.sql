@@ -0,0 +1,23 @@
+-- The existing tokens keep the previous fixed lifetime of 24 hours.
+ALTER TABLE `tokens`
+    ADD COLUMN `expires_at` DATETIME NOT NULL DEFAULT '1970-01-01 00:00:01';
+
+UPDATE `tokens` SET `expires_at` = datetime(`created_at`, '+1 day');
+
+CREATE TABLE IF NOT EXISTS `refresh_tokens`
+(
+    `id`         INTEGER      NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `session_id` INTEGER      NOT NULL,
+    `user_id`    INTEGER      NOT NULL,
+    `token`      VARCHAR(255) NOT NULL,
+    `created_at` DATETIME     NOT NULL,
+    `expires_at` DATETIME     NOT NULL,
+    `used_at`    DATETIME     NULL DEFAULT NULL,
+
+    CONSTRAINT `fk_refresh_tokens_session` FOREIGN KEY (`session_id`) REFERENCES `tokens` (`id`) ON DELETE CASCADE,
+    CONSTRAINT `fk_refresh_tokens_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_refresh_token` ON `refresh_tokens` (`token`);
+
+CREATE INDEX IF NOT EXISTS `idx_refresh_tokens_session_id` ON `refresh_tokens` (`session_id`);
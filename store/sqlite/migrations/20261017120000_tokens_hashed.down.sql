@@ -0,0 +1,2 @@
+-- The hashed tokens cannot be turned back into tokens, so every user has to login again.
+DELETE FROM `tokens`;
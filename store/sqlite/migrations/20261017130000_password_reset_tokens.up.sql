@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `password_reset_tokens`
+(
+    `id`         INTEGER      NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `user_id`    INTEGER      NOT NULL,
+    `token`      VARCHAR(255) NOT NULL,
+    `created_at` DATETIME     NOT NULL,
+    `expires_at` DATETIME     NOT NULL,
+    `used_at`    DATETIME     NULL DEFAULT NULL,
+
+    CONSTRAINT `fk_password_reset_tokens_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_password_reset_token` ON `password_reset_tokens` (`token`);
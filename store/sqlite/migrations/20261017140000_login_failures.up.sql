@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS `login_failures`
+(
+    `login_key`      VARCHAR(255) NOT NULL PRIMARY KEY,
+    `failures`       INTEGER      NOT NULL,
+    `last_failed_at` DATETIME     NOT NULL,
+    `locked_until`   DATETIME     NULL DEFAULT NULL
+);
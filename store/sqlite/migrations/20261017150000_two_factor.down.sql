@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS `login_challenges`;
+DROP TABLE IF EXISTS `recovery_codes`;
+DROP TABLE IF EXISTS `totp_secrets`;
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS `totp_secrets`
+(
+    `user_id`        INTEGER  NOT NULL PRIMARY KEY,
+    `secret`         BLOB     NOT NULL,
+    `created_at`     DATETIME NOT NULL,
+    `confirmed_at`   DATETIME NULL DEFAULT NULL,
+    `last_used_step` INTEGER  NOT NULL DEFAULT 0,
+
+    CONSTRAINT `fk_totp_secrets_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS `recovery_codes`
+(
+    `user_id` INTEGER      NOT NULL,
+    `code`    VARCHAR(255) NOT NULL,
+
+    CONSTRAINT `fk_recovery_codes_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE,
+    PRIMARY KEY (`user_id`, `code`)
+);
+
+CREATE TABLE IF NOT EXISTS `login_challenges`
+(
+    `id`         INTEGER      NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `user_id`    INTEGER      NOT NULL,
+    `token`      VARCHAR(255) NOT NULL,
+    `created_at` DATETIME     NOT NULL,
+    `expires_at` DATETIME     NOT NULL,
+
+    CONSTRAINT `fk_login_challenges_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_login_challenge_token` ON `login_challenges` (`token`);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS `api_keys`
+(
+    `id`           INTEGER      NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `user_id`      INTEGER      NOT NULL,
+    `api_key`      VARCHAR(255) NOT NULL,
+    `name`         VARCHAR(255) NOT NULL,
+    `prefix`       VARCHAR(255) NOT NULL,
+    `scopes`       VARCHAR(255) NOT NULL,
+    `created_at`   DATETIME     NOT NULL,
+    `expires_at`   DATETIME     NULL DEFAULT NULL,
+    `last_used_at` DATETIME     NULL DEFAULT NULL,
+
+    CONSTRAINT `fk_api_keys_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_api_key` ON `api_keys` (`api_key`);
+CREATE INDEX IF NOT EXISTS `idx_api_keys_user_id` ON `api_keys` (`user_id`);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `user_identities`
+(
+    `issuer`  VARCHAR(255) NOT NULL,
+    `subject` VARCHAR(255) NOT NULL,
+    `user_id` INTEGER      NOT NULL,
+
+    CONSTRAINT `fk_user_identities_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE,
+    PRIMARY KEY (`issuer`, `subject`)
+);
+
+CREATE INDEX IF NOT EXISTS `idx_user_identities_user_id` ON `user_identities` (`user_id`);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS `email_verification_tokens`;
+
+-- SQLite before 3.35 cannot drop a column, and rebuilding the users table would delete every row referencing it,
+-- as the foreign keys cannot be turned off within the transaction of the migration. So the email and verified_at
+-- columns are kept, and ignored by the older code. Drop them by hand before applying the migration again.
+UPDATE `users` SET `email` = '', `verified_at` = NULL;
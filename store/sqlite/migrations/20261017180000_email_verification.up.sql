@@ -0,0 +1,17 @@
+ALTER TABLE `users` ADD COLUMN `email` VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE `users` ADD COLUMN `verified_at` DATETIME NULL DEFAULT NULL;
+
+CREATE TABLE IF NOT EXISTS `email_verification_tokens`
+(
+    `id`         INTEGER      NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `user_id`    INTEGER      NOT NULL,
+    `token`      VARCHAR(255) NOT NULL,
+    `email`      VARCHAR(255) NOT NULL,
+    `created_at` DATETIME     NOT NULL,
+    `expires_at` DATETIME     NOT NULL,
+    `used_at`    DATETIME     NULL DEFAULT NULL,
+
+    CONSTRAINT `fk_email_verification_tokens_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_email_verification_token` ON `email_verification_tokens` (`token`);
@@ -0,0 +1,11 @@
+-- The events of the messages, written along with the messages and relayed by the server, see the outbox package.
+CREATE TABLE IF NOT EXISTS `outbox`
+(
+    `id`           INTEGER     NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `type`         VARCHAR(64) NOT NULL,
+    `payload`      TEXT        NOT NULL,
+    `created_at`   DATETIME    NOT NULL,
+    `delivered_at` DATETIME    NULL DEFAULT NULL
+);
+
+CREATE INDEX IF NOT EXISTS `idx_outbox_delivered_at` ON `outbox` (`delivered_at`);
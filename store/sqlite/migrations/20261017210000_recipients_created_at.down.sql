@@ -0,0 +1,21 @@
+-- SQLite before 3.35 cannot drop a column, so the user_message_recipients table is rebuilt without it.
+CREATE TABLE `user_message_recipients_old`
+(
+    `message_id`   INTEGER NOT NULL,
+    `recipient_id` INTEGER NOT NULL,
+
+    CONSTRAINT `fk_user_message_recipients_message` FOREIGN KEY (`message_id`) REFERENCES `messages` (`id`) ON DELETE CASCADE,
+    CONSTRAINT `fk_user_message_recipients_user` FOREIGN KEY (`recipient_id`) REFERENCES `users` (`id`) ON DELETE CASCADE
+);
+
+INSERT INTO `user_message_recipients_old` (`message_id`, `recipient_id`)
+SELECT `message_id`, `recipient_id`
+FROM `user_message_recipients`;
+
+DROP TABLE `user_message_recipients`;
+
+ALTER TABLE `user_message_recipients_old` RENAME TO `user_message_recipients`;
+
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_user_message_recipients` ON `user_message_recipients` (`message_id`, `recipient_id`);
+
+CREATE INDEX IF NOT EXISTS `idx_user_message_recipients_recipient_id` ON `user_message_recipients` (`recipient_id`);
@@ -0,0 +1,9 @@
+-- The created_at of the messages is copied to their recipients, so the inbox of a user is paginated by an index of
+-- the recipients alone, instead of joining every message the user received.
+ALTER TABLE `user_message_recipients`
+    ADD COLUMN `created_at` DATETIME NULL DEFAULT NULL;
+
+UPDATE `user_message_recipients`
+SET `created_at` = (SELECT `created_at` FROM `messages` WHERE `messages`.`id` = `user_message_recipients`.`message_id`);
+
+CREATE INDEX IF NOT EXISTS `idx_user_message_recipients_inbox` ON `user_message_recipients` (`recipient_id`, `created_at`, `message_id`);
@@ -0,0 +1,18 @@
+//go:build !cgo
+
+package sqlite
+
+import (
+	"errors"
+)
+
+// checkCgo fails, as the SQLite driver is a stub without cgo. Connect fails with a clear error at startup,
+// instead of the first query failing.
+func checkCgo() error {
+	return errors.New("sqlite: the server is built without cgo, which the SQLite driver requires, build it with CGO_ENABLED=1")
+}
+
+// isUniqueViolation is never called, as Connect fails.
+func isUniqueViolation(err error) bool {
+	return false
+}
@@ -0,0 +1,60 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.RefreshTokenStore = (*refreshTokenStore)(nil)
+
+type refreshTokenStore struct {
+	db *sql.DB
+}
+
+func (r *refreshTokenStore) Create(ctx context.Context, token string, tok store.RefreshToken) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO refresh_tokens(session_id, user_id, token, created_at, expires_at) VALUES(?,?,?,?,?)",
+		tok.SessionID, tok.UserID, token, tok.CreatedAt.UTC(), tok.ExpiresAt.UTC())
+	if err != nil {
+		if isUniqueViolation(err) {
+			return store.ErrDuplicate
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (r *refreshTokenStore) Get(ctx context.Context, token string) (*store.RefreshToken, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT id, session_id, user_id, created_at, expires_at, used_at FROM refresh_tokens WHERE token=?", token)
+
+	var tok store.RefreshToken
+
+	err := row.Scan(&tok.ID, &tok.SessionID, &tok.UserID, &tok.CreatedAt, &tok.ExpiresAt, &tok.UsedAt)
+	if err == sql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &tok, nil
+}
+
+// MarkUsed returns ErrNotFound if the refresh token does not exist or is already used.
+// The check and the update are a single statement, so only one of the concurrent callers succeeds.
+func (r *refreshTokenStore) MarkUsed(ctx context.Context, token string, usedAt time.Time) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE refresh_tokens SET used_at=? WHERE token=? AND used_at IS NULL", usedAt.UTC(), token)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
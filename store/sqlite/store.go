@@ -0,0 +1,103 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.Store = (*Store)(nil)
+
+type Store struct {
+	db      *sql.DB
+	connStr string
+
+	messageStore *messageStore
+	userStore    *userStore
+	tokenStore   *tokenStore
+
+	refreshTokenStore      *refreshTokenStore
+	passwordResetStore     *passwordResetStore
+	emailVerificationStore *emailVerificationStore
+	loginFailureStore      *loginFailureStore
+	twoFactorStore         *twoFactorStore
+	apiKeyStore            *apiKeyStore
+	outboxStore            *outboxStore
+}
+
+// Connect opens the SQLite database file at path, creating it if it does not exist.
+// Foreign keys are enforced so the cascading deletes behave the same as MySQL.
+func Connect(path string) (*Store, error) {
+	if err := checkCgo(); err != nil {
+		return nil, err
+	}
+
+	connStr := fmt.Sprintf("file:%s?_foreign_keys=1&_busy_timeout=5000&_journal_mode=WAL", path)
+
+	db, err := sql.Open("sqlite3", connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		db:                     db,
+		connStr:                connStr,
+		messageStore:           &messageStore{db: db},
+		userStore:              &userStore{db: db},
+		tokenStore:             &tokenStore{db: db},
+		refreshTokenStore:      &refreshTokenStore{db: db},
+		passwordResetStore:     &passwordResetStore{db: db},
+		emailVerificationStore: &emailVerificationStore{db: db},
+		loginFailureStore:      &loginFailureStore{db: db},
+		twoFactorStore:         &twoFactorStore{db: db},
+		apiKeyStore:            &apiKeyStore{db: db},
+		outboxStore:            &outboxStore{db: db},
+	}
+
+	return s, nil
+}
+
+func (s *Store) Message() store.MessageStore {
+	return s.messageStore
+}
+
+func (s *Store) User() store.UserStore {
+	return s.userStore
+}
+
+func (s *Store) Token() store.TokenStore {
+	return s.tokenStore
+}
+
+func (s *Store) RefreshToken() store.RefreshTokenStore {
+	return s.refreshTokenStore
+}
+
+func (s *Store) PasswordReset() store.PasswordResetStore {
+	return s.passwordResetStore
+}
+
+func (s *Store) EmailVerification() store.EmailVerificationStore {
+	return s.emailVerificationStore
+}
+
+func (s *Store) LoginFailure() store.LoginFailureStore {
+	return s.loginFailureStore
+}
+
+func (s *Store) TwoFactor() store.TwoFactorStore {
+	return s.twoFactorStore
+}
+
+func (s *Store) APIKey() store.APIKeyStore {
+	return s.apiKeyStore
+}
+
+func (s *Store) Outbox() store.OutboxStore {
+	return s.outboxStore
+}
@@ -0,0 +1,182 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+)
+
+var _ store.TwoFactorStore = (*twoFactorStore)(nil)
+
+type twoFactorStore struct {
+	db *sql.DB
+}
+
+func (t *twoFactorStore) SetTOTP(ctx context.Context, totp store.TOTP) error {
+	_, err := t.db.ExecContext(ctx, `INSERT INTO totp_secrets(user_id, secret, created_at) VALUES(?,?,?)
+		ON CONFLICT (user_id) DO UPDATE SET secret=excluded.secret, created_at=excluded.created_at, confirmed_at=NULL, last_used_step=0`,
+		totp.UserID, totp.Secret, totp.CreatedAt.UTC())
+	return err
+}
+
+func (t *twoFactorStore) GetTOTP(ctx context.Context, userID int64) (*store.TOTP, error) {
+	row := t.db.QueryRowContext(ctx, "SELECT user_id, secret, created_at, confirmed_at, last_used_step FROM totp_secrets WHERE user_id=?", userID)
+
+	var totp store.TOTP
+
+	err := row.Scan(&totp.UserID, &totp.Secret, &totp.CreatedAt, &totp.ConfirmedAt, &totp.LastUsedStep)
+	if err == sql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &totp, nil
+}
+
+// ConfirmTOTP returns ErrNotFound if the user has no TOTP secret, or it is already confirmed.
+func (t *twoFactorStore) ConfirmTOTP(ctx context.Context, userID int64, confirmedAt time.Time) error {
+	res, err := t.db.ExecContext(ctx, "UPDATE totp_secrets SET confirmed_at=? WHERE user_id=? AND confirmed_at IS NULL", confirmedAt.UTC(), userID)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
+
+// UseTOTPStep returns ErrNotFound if the user has no TOTP secret, or the step is not after the last used step.
+// The check and the update are a single statement, so a code is accepted only once even by concurrent requests.
+func (t *twoFactorStore) UseTOTPStep(ctx context.Context, userID int64, step int64) error {
+	res, err := t.db.ExecContext(ctx, "UPDATE totp_secrets SET last_used_step=? WHERE user_id=? AND last_used_step < ?", step, userID, step)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
+
+func (t *twoFactorStore) DeleteTOTP(ctx context.Context, userID int64) error {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM totp_secrets WHERE user_id=?", userID)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		_ = tx.Rollback()
+		return err
+	} else if affected < 1 {
+		_ = tx.Rollback()
+		return store.ErrNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id=?", userID)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (t *twoFactorStore) SetRecoveryCodes(ctx context.Context, userID int64, codes []string) error {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id=?", userID)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	for _, code := range codes {
+		_, err = tx.ExecContext(ctx, "INSERT INTO recovery_codes(user_id, code) VALUES(?,?)", userID, code)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode returns ErrNotFound if the user does not have the recovery code.
+func (t *twoFactorStore) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	res, err := t.db.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id=? AND code=?", userID, code)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
+
+func (t *twoFactorStore) CreateChallenge(ctx context.Context, token string, c store.LoginChallenge) error {
+	_, err := t.db.ExecContext(ctx, "INSERT INTO login_challenges(user_id, token, created_at, expires_at) VALUES(?,?,?,?)",
+		c.UserID, token, c.CreatedAt.UTC(), c.ExpiresAt.UTC())
+	if err != nil {
+		if isUniqueViolation(err) {
+			return store.ErrDuplicate
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (t *twoFactorStore) GetChallenge(ctx context.Context, token string) (*store.LoginChallenge, error) {
+	row := t.db.QueryRowContext(ctx, "SELECT id, user_id, created_at, expires_at FROM login_challenges WHERE token=?", token)
+
+	var c store.LoginChallenge
+
+	err := row.Scan(&c.ID, &c.UserID, &c.CreatedAt, &c.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// DeleteChallenge returns ErrNotFound if the challenge does not exist.
+func (t *twoFactorStore) DeleteChallenge(ctx context.Context, token string) error {
+	res, err := t.db.ExecContext(ctx, "DELETE FROM login_challenges WHERE token=?", token)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < 1 {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
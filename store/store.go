@@ -9,6 +9,7 @@ import (
 var (
 	ErrDuplicate = errors.New("store: duplicate entry")
 	ErrNotFound  = errors.New("store: item not found")
+	ErrLimit     = errors.New("store: limit reached")
 )
 
 type Message struct {
@@ -18,29 +19,176 @@ type Message struct {
 	Sender          string    `json:"sender"`
 	SentDateTime    time.Time `json:"sent_at"`
 	UpdatedDateTime time.Time `json:"updated_at"`
+	// Recipients is the IDs of the recipient users. It is only loaded by GetSent, see also GetRecipients.
+	Recipients []int64 `json:"recipients,omitempty"`
+}
+
+// Cursor is a position in a list of messages, which are ordered by newest first.
+// The ID breaks the tie between messages sent at the same time.
+type Cursor struct {
+	SentDateTime time.Time
+	ID           int64
 }
 
 type User struct {
 	ID           int64
 	Username     string
 	PasswordHash string
+	// Email is empty if the user has not given an email address.
+	Email string
+	// VerifiedAt is nil until the email address is verified.
+	VerifiedAt *time.Time
+}
+
+// PasswordResetToken is used once to set a new password of a user who forgot the password.
+type PasswordResetToken struct {
+	ID        int64
+	UserID    int64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// UsedAt is nil until the token is used.
+	UsedAt *time.Time
 }
 
+// EmailVerificationToken is used once to verify the email address it is sent to.
+type EmailVerificationToken struct {
+	ID     int64
+	UserID int64
+	// Email is the address the token is sent to.
+	Email     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// UsedAt is nil until the token is used.
+	UsedAt *time.Time
+}
+
+// Token is a login session of a user. A user can have many tokens, e.g. one per device.
+// The access token of a session is replaced every time the session is refreshed.
+// The TokenStore and the RefreshTokenStore keep the tokens as given, which is a keyed hash of the tokens given to the client.
 type Token struct {
+	ID         int64
+	UserID     int64
+	UserAgent  string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	// ExpiresAt is the expiry time of the current access token.
+	ExpiresAt time.Time
+}
+
+// RefreshToken is used once to get a new access token and refresh token for a session.
+// All the refresh tokens of a session form a family, which is revoked together with the session.
+type RefreshToken struct {
+	ID        int64
+	SessionID int64
 	UserID    int64
-	UpdatedAt time.Time
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// UsedAt is nil until the refresh token is used.
+	UsedAt *time.Time
+}
+
+// LoginFailures are the recent failed logins of a key, which is e.g. a username or an IP address.
+type LoginFailures struct {
+	Key          string
+	Count        int
+	LastFailedAt time.Time
+	// LockedUntil is zero if the key has never been locked out.
+	LockedUntil time.Time
+}
+
+// TOTP is the time-based one-time password secret of a user's authenticator app.
+// The two-factor authentication of the user is enabled once the secret is confirmed.
+type TOTP struct {
+	UserID int64
+	// Secret is kept as given, which is encrypted by the caller.
+	Secret    []byte
+	CreatedAt time.Time
+	// ConfirmedAt is nil until the user proves to have the secret with a code.
+	ConfirmedAt *time.Time
+	// LastUsedStep is the time step of the last accepted code, so a code cannot be used twice.
+	LastUsedStep int64
+}
+
+// LoginChallenge is given to a user whose password is right, to complete the login with a second factor.
+type LoginChallenge struct {
+	ID        int64
+	UserID    int64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// APIKey is a long-lived credential of a user for the programs acting on behalf of the user, e.g. bots.
+// An API key can only do what its scopes allow. The APIKeyStore keeps the keys as given, which is a keyed hash of the keys.
+type APIKey struct {
+	ID     int64
+	UserID int64
+	Name   string
+	// Prefix is the start of the key, which tells the keys apart without revealing them.
+	Prefix    string
+	Scopes    []string
+	CreatedAt time.Time
+	// ExpiresAt is nil if the key never expires.
+	ExpiresAt *time.Time
+	// LastUsedAt is nil until the key is used.
+	LastUsedAt *time.Time
+}
+
+const (
+	EventMessageCreated = "message.created"
+	EventMessageUpdated = "message.updated"
+	EventMessageDeleted = "message.deleted"
+)
+
+// OutboxEvent is written in the same transaction as the change it tells about, so the event is not lost if the server
+// stops before publishing it. The MessageStore writes a MessageEvent on every change of a message.
+type OutboxEvent struct {
+	ID   int64
+	Type string
+	// Payload is the JSON of the event, e.g. a MessageEvent.
+	Payload   []byte
+	CreatedAt time.Time
+	// DeliveredAt is nil until the event is delivered.
+	DeliveredAt *time.Time
+}
+
+// MessageEvent is the payload of the outbox events of the messages.
+type MessageEvent struct {
+	Message *Message `json:"message"`
+	// SenderID is not in the JSON of the message.
+	SenderID int64 `json:"sender_id"`
+	// RecipientIDs are the recipients of the message. For EventMessageDeleted, they are the recipients before the deletion.
+	RecipientIDs []int64 `json:"recipient_ids"`
+	// PreviousRecipientIDs are the recipients before the update, for EventMessageUpdated only.
+	PreviousRecipientIDs []int64 `json:"previous_recipient_ids,omitempty"`
 }
 
 type Store interface {
 	Message() MessageStore
 	User() UserStore
 	Token() TokenStore
+	RefreshToken() RefreshTokenStore
+	PasswordReset() PasswordResetStore
+	EmailVerification() EmailVerificationStore
+	LoginFailure() LoginFailureStore
+	TwoFactor() TwoFactorStore
+	APIKey() APIKeyStore
+	Outbox() OutboxStore
 }
 
+// MessageStore writes a MessageEvent to the outbox on every change of a message, see OutboxStore.
 type MessageStore interface {
-	Create(ctx context.Context, msg Message, recipientUserIDs []int64) error
+	// Create returns the ID of the new message.
+	Create(ctx context.Context, msg Message, recipientUserIDs []int64) (int64, error)
 	Get(ctx context.Context, userID int64) ([]*Message, error)
+	// GetPage returns at most limit messages received by the user, newest first.
+	// If after is not nil, only the messages older than the cursor are returned.
+	GetPage(ctx context.Context, userID int64, after *Cursor, limit int) ([]*Message, error)
+	// GetSent returns at most limit messages sent by the user, newest first, along with their recipients.
+	// If after is not nil, only the messages older than the cursor are returned.
+	GetSent(ctx context.Context, senderID int64, after *Cursor, limit int) ([]*Message, error)
 	GetByID(ctx context.Context, msgID int64) (*Message, error)
+	// GetRecipients returns the sorted IDs of the message recipients.
+	GetRecipients(ctx context.Context, msgID int64) ([]int64, error)
 	Delete(ctx context.Context, userID int64) error
 	Update(ctx context.Context, msg Message, recipientUserIDs []int64) error
 }
@@ -49,9 +197,138 @@ type UserStore interface {
 	Create(ctx context.Context, username, passwordHash string) error
 	GetByUsername(ctx context.Context, username string) (*User, error)
 	GetByID(ctx context.Context, id int64) (*User, error)
+	// UpdatePasswordHash returns ErrNotFound if the user does not exist.
+	UpdatePasswordHash(ctx context.Context, id int64, passwordHash string) error
+	// SetEmail sets the email address of the user, which is not verified until VerifyEmail.
+	// It returns ErrNotFound if the user does not exist.
+	SetEmail(ctx context.Context, id int64, email string) error
+	// VerifyEmail sets the verified time of the email address of the user. It returns ErrNotFound if the user
+	// does not exist or the address is no longer email, so a token sent to an old address cannot verify a new one.
+	VerifyEmail(ctx context.Context, id int64, email string, verifiedAt time.Time) error
+	// GetByIdentity returns the user linked to the subject of an external identity provider.
+	// It returns ErrNotFound if no user is linked.
+	GetByIdentity(ctx context.Context, issuer, subject string) (*User, error)
+	// LinkIdentity links the subject of an external identity provider to the user.
+	// It returns ErrDuplicate if the subject is already linked to a user.
+	LinkIdentity(ctx context.Context, userID int64, issuer, subject string) error
+	// Delete removes the user, along with the user's tokens, identities, sent messages and received messages.
+	Delete(ctx context.Context, id int64) error
 }
 
 type TokenStore interface {
-	Create(ctx context.Context, userID int64, token string, updatedAt time.Time) error
+	// Create stores a new token for t.UserID. The ID of t is ignored.
+	Create(ctx context.Context, token string, t Token) error
 	GetUserID(ctx context.Context, token string) (*Token, error)
+	// GetByID returns ErrNotFound if the session does not exist.
+	GetByID(ctx context.Context, id int64) (*Token, error)
+	// Renew replaces the access token of the session. It returns ErrNotFound if the session does not exist.
+	Renew(ctx context.Context, id int64, token string, expiresAt time.Time) error
+	// Delete returns ErrNotFound if the token does not exist.
+	Delete(ctx context.Context, token string) error
+	// DeleteByID removes the session along with its refresh tokens. It returns ErrNotFound if the session does not exist.
+	DeleteByID(ctx context.Context, id int64) error
+	// Touch sets the last used time of the token, which is used for the idle timeout of the session.
+	// The callers throttle it, so it is not written on every request. It returns ErrNotFound if the token does not exist.
+	Touch(ctx context.Context, token string, lastUsedAt time.Time) error
+	DeleteByUserID(ctx context.Context, userID int64) error
+	// List returns the tokens of the user, newest first.
+	List(ctx context.Context, userID int64) ([]*Token, error)
+}
+
+type RefreshTokenStore interface {
+	// Create stores a new refresh token for t.SessionID. The ID and UsedAt of t are ignored.
+	Create(ctx context.Context, token string, t RefreshToken) error
+	Get(ctx context.Context, token string) (*RefreshToken, error)
+	// MarkUsed sets the used time of the refresh token.
+	// It returns ErrNotFound if the refresh token does not exist or is already used, so a refresh token can be used only once.
+	MarkUsed(ctx context.Context, token string, usedAt time.Time) error
+}
+
+type PasswordResetStore interface {
+	// Create stores a new password reset token for t.UserID. The ID and UsedAt of t are ignored.
+	Create(ctx context.Context, token string, t PasswordResetToken) error
+	Get(ctx context.Context, token string) (*PasswordResetToken, error)
+	// MarkUsed sets the used time of the token.
+	// It returns ErrNotFound if the token does not exist or is already used, so a token can be used only once.
+	MarkUsed(ctx context.Context, token string, usedAt time.Time) error
+}
+
+type EmailVerificationStore interface {
+	// Create stores a new email verification token for t.UserID. The ID and UsedAt of t are ignored.
+	Create(ctx context.Context, token string, t EmailVerificationToken) error
+	Get(ctx context.Context, token string) (*EmailVerificationToken, error)
+	// MarkUsed sets the used time of the token.
+	// It returns ErrNotFound if the token does not exist or is already used, so a token can be used only once.
+	MarkUsed(ctx context.Context, token string, usedAt time.Time) error
+}
+
+// LoginFailureStore counts the failed logins, to lock out the brute-force attacks.
+// It is kept in the store, so every server sees the same counts.
+type LoginFailureStore interface {
+	// Get returns ErrNotFound if the key has no failed login.
+	Get(ctx context.Context, key string) (*LoginFailures, error)
+	// Add counts a failed login of the key, and returns the failures including it.
+	// If the last failed login is before resetBefore, the count starts over.
+	Add(ctx context.Context, key string, failedAt, resetBefore time.Time) (*LoginFailures, error)
+	// Undo takes back a failed login counted by Add, for a login that has not failed after all.
+	// It returns ErrNotFound if the key has no failed login to take back.
+	Undo(ctx context.Context, key string) error
+	// Lock locks the key out until the time. It returns ErrNotFound if the key has no failed login.
+	Lock(ctx context.Context, key string, until time.Time) error
+	// Delete forgets the failed logins of the key. It returns ErrNotFound if the key has no failed login.
+	Delete(ctx context.Context, key string) error
+}
+
+// TwoFactorStore keeps the second factors of the users, and the logins waiting for a second factor.
+// The recovery codes and the challenge tokens are kept as given, which is a keyed hash of them.
+type TwoFactorStore interface {
+	// SetTOTP replaces the TOTP secret of t.UserID with an unconfirmed one. The ConfirmedAt and LastUsedStep of t are ignored.
+	SetTOTP(ctx context.Context, t TOTP) error
+	// GetTOTP returns ErrNotFound if the user has no TOTP secret.
+	GetTOTP(ctx context.Context, userID int64) (*TOTP, error)
+	// ConfirmTOTP returns ErrNotFound if the user has no TOTP secret, or it is already confirmed.
+	ConfirmTOTP(ctx context.Context, userID int64, confirmedAt time.Time) error
+	// UseTOTPStep sets the last used time step. It returns ErrNotFound if the user has no TOTP secret,
+	// or the step is not after the last used step, so a code can be used only once.
+	UseTOTPStep(ctx context.Context, userID int64, step int64) error
+	// DeleteTOTP removes the TOTP secret along with the recovery codes. It returns ErrNotFound if the user has no TOTP secret.
+	DeleteTOTP(ctx context.Context, userID int64) error
+
+	// SetRecoveryCodes replaces the recovery codes of the user.
+	SetRecoveryCodes(ctx context.Context, userID int64, codes []string) error
+	// UseRecoveryCode removes the recovery code. It returns ErrNotFound if the user does not have it.
+	UseRecoveryCode(ctx context.Context, userID int64, code string) error
+
+	// CreateChallenge stores a new challenge for c.UserID. The ID of c is ignored.
+	CreateChallenge(ctx context.Context, token string, c LoginChallenge) error
+	// GetChallenge returns ErrNotFound if the challenge does not exist.
+	GetChallenge(ctx context.Context, token string) (*LoginChallenge, error)
+	// DeleteChallenge returns ErrNotFound if the challenge does not exist, so a challenge can be completed only once.
+	DeleteChallenge(ctx context.Context, token string) error
+}
+
+type APIKeyStore interface {
+	// Create stores a new API key for k.UserID and returns its ID. The ID and LastUsedAt of k are ignored.
+	// It returns ErrLimit if the user already has limit keys, counted along with the insert so concurrent creates
+	// cannot go over it, and ErrDuplicate if the key exists.
+	Create(ctx context.Context, key string, k APIKey, limit int) (int64, error)
+	// Get returns ErrNotFound if the key does not exist.
+	Get(ctx context.Context, key string) (*APIKey, error)
+	// List returns the API keys of the user, newest first.
+	List(ctx context.Context, userID int64) ([]*APIKey, error)
+	// Touch sets the last used time of the key. The callers throttle it, so it is not written on every request.
+	// It returns ErrNotFound if the key does not exist.
+	Touch(ctx context.Context, id int64, lastUsedAt time.Time) error
+	// Delete removes the API key of the user. It returns ErrNotFound if the user has no such key.
+	Delete(ctx context.Context, userID, id int64) error
+}
+
+// OutboxStore is read by the relay, which delivers the events in order at least once.
+type OutboxStore interface {
+	// GetPending returns at most limit undelivered events, in the order they are written.
+	GetPending(ctx context.Context, limit int) ([]*OutboxEvent, error)
+	// MarkDelivered sets the delivered time of the event. It returns ErrNotFound if the event does not exist.
+	MarkDelivered(ctx context.Context, id int64, deliveredAt time.Time) error
+	// DeleteDelivered removes the events delivered before the time, and returns how many are removed.
+	DeleteDelivered(ctx context.Context, before time.Time) (int64, error)
 }
@@ -0,0 +1,1363 @@
+// Package storetest provides a conformance test suite for store.Store implementations.
+package storetest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ahmadmuzakkir/go-sample-api-server-structure/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// timeTolerance covers the precision lost by the databases when storing a time, e.g. MySQL DATETIME(6).
+const timeTolerance = time.Millisecond
+
+// RunConformance runs the test suite against the stores returned by factory.
+// The factory is called once per sub test with the sub test's t, and must return an empty store.
+func RunConformance(t *testing.T, factory func(t *testing.T) store.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s store.Store)
+	}{
+		{"UserCreate", testUserCreate},
+		{"UserDuplicate", testUserDuplicate},
+		{"UserNotFound", testUserNotFound},
+		{"UserUpdatePasswordHash", testUserUpdatePasswordHash},
+		{"UserEmail", testUserEmail},
+		{"UserIdentity", testUserIdentity},
+		{"UserDeleteCascade", testUserDeleteCascade},
+		{"TokenCreate", testTokenCreate},
+		{"TokenSessions", testTokenSessions},
+		{"TokenRenew", testTokenRenew},
+		{"TokenNotFound", testTokenNotFound},
+		{"RefreshToken", testRefreshToken},
+		{"RefreshTokenRevoke", testRefreshTokenRevoke},
+		{"PasswordReset", testPasswordReset},
+		{"EmailVerification", testEmailVerification},
+		{"LoginFailure", testLoginFailure},
+		{"TwoFactorTOTP", testTwoFactorTOTP},
+		{"TwoFactorRecoveryCodes", testTwoFactorRecoveryCodes},
+		{"TwoFactorChallenge", testTwoFactorChallenge},
+		{"APIKey", testAPIKey},
+		{"APIKeyLimit", testAPIKeyLimit},
+		{"MessageCreate", testMessageCreate},
+		{"MessageNotFound", testMessageNotFound},
+		{"MessagePage", testMessagePage},
+		{"MessageSent", testMessageSent},
+		{"MessageSentManyRecipients", testMessageSentManyRecipients},
+		{"MessageUpdate", testMessageUpdate},
+		{"MessageDelete", testMessageDelete},
+		{"Outbox", testOutbox},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fn(t, factory(t))
+		})
+	}
+}
+
+func testUserCreate(t *testing.T, s store.Store) {
+	err := s.User().Create(context.Background(), "username", "password_hash")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	user, err := s.User().GetByUsername(context.Background(), "username")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.NotZero(t, user.ID)
+	assert.Equal(t, "username", user.Username)
+	assert.Equal(t, "password_hash", user.PasswordHash)
+
+	byID, err := s.User().GetByID(context.Background(), user.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, user, byID)
+	}
+}
+
+func testUserDuplicate(t *testing.T, s store.Store) {
+	addUser(t, s, "username")
+
+	err := s.User().Create(context.Background(), "username", "password_hash")
+	assert.Equal(t, store.ErrDuplicate, err)
+}
+
+func testUserNotFound(t *testing.T, s store.Store) {
+	_, err := s.User().GetByUsername(context.Background(), "username")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	_, err = s.User().GetByID(context.Background(), 1)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	err = s.User().Delete(context.Background(), 1)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	err = s.User().UpdatePasswordHash(context.Background(), 1, "hash")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	err = s.User().SetEmail(context.Background(), 1, "user@example.com")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	err = s.User().VerifyEmail(context.Background(), 1, "user@example.com", time.Now())
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+func testUserUpdatePasswordHash(t *testing.T, s store.Store) {
+	user1 := addUser(t, s, "username1")
+	user2 := addUser(t, s, "username2")
+
+	if !assert.NoError(t, s.User().UpdatePasswordHash(context.Background(), user1.ID, "new hash")) {
+		t.FailNow()
+	}
+
+	user, err := s.User().GetByID(context.Background(), user1.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "new hash", user.PasswordHash)
+	}
+
+	user, err = s.User().GetByID(context.Background(), user2.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, user2.PasswordHash, user.PasswordHash, "password of the other user")
+	}
+}
+
+func testUserEmail(t *testing.T, s store.Store) {
+	user1 := addUser(t, s, "username1")
+	user2 := addUser(t, s, "username2")
+
+	assert.Empty(t, user1.Email)
+	assert.Nil(t, user1.VerifiedAt)
+
+	if !assert.NoError(t, s.User().SetEmail(context.Background(), user1.ID, "user1@example.com")) {
+		t.FailNow()
+	}
+
+	user, err := s.User().GetByID(context.Background(), user1.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "user1@example.com", user.Email)
+		assert.Nil(t, user.VerifiedAt)
+	}
+
+	// Only the current address can be verified.
+	now := time.Now()
+
+	assert.Equal(t, store.ErrNotFound, s.User().VerifyEmail(context.Background(), user1.ID, "old@example.com", now))
+	assert.NoError(t, s.User().VerifyEmail(context.Background(), user1.ID, "user1@example.com", now))
+
+	user, err = s.User().GetByUsername(context.Background(), "username1")
+	if assert.NoError(t, err) && assert.NotNil(t, user.VerifiedAt) {
+		assert.Equal(t, "user1@example.com", user.Email)
+		assert.WithinDuration(t, now, *user.VerifiedAt, timeTolerance)
+	}
+
+	user, err = s.User().GetByID(context.Background(), user2.ID)
+	if assert.NoError(t, err) {
+		assert.Empty(t, user.Email, "email of the other user")
+		assert.Nil(t, user.VerifiedAt, "email of the other user")
+	}
+
+	// A new address is not verified.
+	assert.NoError(t, s.User().SetEmail(context.Background(), user1.ID, "new@example.com"))
+
+	user, err = s.User().GetByID(context.Background(), user1.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "new@example.com", user.Email)
+		assert.Nil(t, user.VerifiedAt)
+	}
+}
+
+func testUserIdentity(t *testing.T, s store.Store) {
+	user1 := addUser(t, s, "username1")
+	user2 := addUser(t, s, "username2")
+
+	_, err := s.User().GetByIdentity(context.Background(), "https://idp.example", "subject1")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	if !assert.NoError(t, s.User().LinkIdentity(context.Background(), user1.ID, "https://idp.example", "subject1")) {
+		t.FailNow()
+	}
+
+	// A user can have many identities, but an identity belongs to one user.
+	assert.NoError(t, s.User().LinkIdentity(context.Background(), user1.ID, "https://other.example", "subject1"))
+	assert.Equal(t, store.ErrDuplicate, s.User().LinkIdentity(context.Background(), user2.ID, "https://idp.example", "subject1"))
+
+	user, err := s.User().GetByIdentity(context.Background(), "https://idp.example", "subject1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, user1.ID, user.ID)
+		assert.Equal(t, "username1", user.Username)
+	}
+
+	_, err = s.User().GetByIdentity(context.Background(), "https://idp.example", "subject2")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	// The identities are removed along with the user.
+	assert.NoError(t, s.User().Delete(context.Background(), user1.ID))
+
+	_, err = s.User().GetByIdentity(context.Background(), "https://idp.example", "subject1")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	assert.NoError(t, s.User().LinkIdentity(context.Background(), user2.ID, "https://idp.example", "subject1"))
+}
+
+func testUserDeleteCascade(t *testing.T, s store.Store) {
+	user1 := addUser(t, s, "username1")
+	user2 := addUser(t, s, "username2")
+	user3 := addUser(t, s, "username3")
+
+	addToken(t, s, user1.ID, "token1", time.Now())
+
+	// user1 sends a message to user2, and user3 sends a message to user1 and user2.
+	msg1 := addMessage(t, s, user1.ID, "from user1", user2.ID)
+	msg2 := addMessage(t, s, user3.ID, "from user3", user1.ID, user2.ID)
+
+	if !assert.NoError(t, s.User().Delete(context.Background(), user1.ID)) {
+		t.FailNow()
+	}
+
+	_, err := s.User().GetByID(context.Background(), user1.ID)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	_, err = s.Token().GetUserID(context.Background(), "token1")
+	assert.Equal(t, store.ErrNotFound, err, "token of the deleted user")
+
+	_, err = s.Message().GetByID(context.Background(), msg1.ID)
+	assert.Equal(t, store.ErrNotFound, err, "message sent by the deleted user")
+
+	// The message from user3 is still delivered to user2.
+	messages, err := s.Message().Get(context.Background(), user2.ID)
+	if assert.NoError(t, err) && assert.Len(t, messages, 1) {
+		assert.Equal(t, msg2.ID, messages[0].ID)
+	}
+
+	assert.Equal(t, store.ErrNotFound, s.User().Delete(context.Background(), user1.ID))
+}
+
+func testTokenCreate(t *testing.T, s store.Store) {
+	user := addUser(t, s, "username")
+
+	createdAt := time.Now()
+
+	err := s.Token().Create(context.Background(), "token1", store.Token{
+		UserID:     user.ID,
+		UserAgent:  "user agent",
+		CreatedAt:  createdAt,
+		LastUsedAt: createdAt,
+		ExpiresAt:  createdAt.Add(time.Hour),
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	token, err := s.Token().GetUserID(context.Background(), "token1")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.NotZero(t, token.ID)
+	assert.Equal(t, user.ID, token.UserID)
+	assert.Equal(t, "user agent", token.UserAgent)
+	assert.WithinDuration(t, createdAt, token.CreatedAt, timeTolerance)
+	assert.WithinDuration(t, createdAt, token.LastUsedAt, timeTolerance)
+	assert.WithinDuration(t, createdAt.Add(time.Hour), token.ExpiresAt, timeTolerance)
+
+	err = s.Token().Create(context.Background(), "token1", store.Token{
+		UserID:     user.ID,
+		CreatedAt:  createdAt,
+		LastUsedAt: createdAt,
+	})
+	assert.Equal(t, store.ErrDuplicate, err)
+}
+
+func testTokenRenew(t *testing.T, s store.Store) {
+	user := addUser(t, s, "username")
+
+	now := time.Now()
+
+	addToken(t, s, user.ID, "token1", now)
+	addToken(t, s, user.ID, "token2", now)
+
+	session, err := s.Token().GetUserID(context.Background(), "token1")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	expiresAt := now.Add(2 * time.Hour)
+
+	err = s.Token().Renew(context.Background(), session.ID, "token3", expiresAt)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	_, err = s.Token().GetUserID(context.Background(), "token1")
+	assert.Equal(t, store.ErrNotFound, err, "the old access token")
+
+	renewed, err := s.Token().GetUserID(context.Background(), "token3")
+	if assert.NoError(t, err) {
+		assert.Equal(t, session.ID, renewed.ID)
+		assert.WithinDuration(t, session.CreatedAt, renewed.CreatedAt, timeTolerance)
+		assert.WithinDuration(t, expiresAt, renewed.ExpiresAt, timeTolerance)
+	}
+
+	err = s.Token().Renew(context.Background(), session.ID, "token2", expiresAt)
+	assert.Equal(t, store.ErrDuplicate, err)
+
+	err = s.Token().Renew(context.Background(), session.ID+100, "token4", expiresAt)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	byID, err := s.Token().GetByID(context.Background(), session.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, session.ID, byID.ID)
+		assert.Equal(t, user.ID, byID.UserID)
+		assert.WithinDuration(t, expiresAt, byID.ExpiresAt, timeTolerance)
+	}
+
+	_, err = s.Token().GetByID(context.Background(), session.ID+100)
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+func testTokenSessions(t *testing.T, s store.Store) {
+	user1 := addUser(t, s, "username1")
+	user2 := addUser(t, s, "username2")
+
+	now := time.Now()
+
+	// A new token does not replace the existing ones.
+	addToken(t, s, user1.ID, "token1", now.Add(-2*time.Minute))
+	addToken(t, s, user1.ID, "token2", now.Add(-time.Minute))
+	addToken(t, s, user1.ID, "token3", now)
+	addToken(t, s, user2.ID, "token4", now)
+
+	tokens, err := s.Token().List(context.Background(), user1.ID)
+	if !assert.NoError(t, err) || !assert.Len(t, tokens, 3) {
+		t.FailNow()
+	}
+
+	token3, err := s.Token().GetUserID(context.Background(), "token3")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, token3.ID, tokens[0].ID, "newest first")
+	assert.WithinDuration(t, now.Add(-2*time.Minute), tokens[2].CreatedAt, timeTolerance)
+
+	// Touch only changes the last used time.
+
+	usedAt := now.Add(time.Minute)
+	assert.NoError(t, s.Token().Touch(context.Background(), "token3", usedAt))
+	assert.Equal(t, store.ErrNotFound, s.Token().Touch(context.Background(), "notexist", usedAt))
+
+	token3, err = s.Token().GetUserID(context.Background(), "token3")
+	if assert.NoError(t, err) {
+		assert.WithinDuration(t, now, token3.CreatedAt, timeTolerance)
+		assert.WithinDuration(t, usedAt, token3.LastUsedAt, timeTolerance)
+	}
+
+	// Revoke a single token.
+
+	assert.NoError(t, s.Token().Delete(context.Background(), "token1"))
+	assert.Equal(t, store.ErrNotFound, s.Token().Delete(context.Background(), "token1"))
+
+	_, err = s.Token().GetUserID(context.Background(), "token1")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	_, err = s.Token().GetUserID(context.Background(), "token2")
+	assert.NoError(t, err)
+
+	// Revoke all the tokens of user1.
+
+	assert.NoError(t, s.Token().DeleteByUserID(context.Background(), user1.ID))
+
+	tokens, err = s.Token().List(context.Background(), user1.ID)
+	assert.NoError(t, err)
+	assert.Len(t, tokens, 0)
+
+	_, err = s.Token().GetUserID(context.Background(), "token4")
+	assert.NoError(t, err, "token of the other user")
+}
+
+func testRefreshToken(t *testing.T, s store.Store) {
+	user := addUser(t, s, "username")
+
+	now := time.Now()
+
+	addToken(t, s, user.ID, "token1", now)
+
+	session, err := s.Token().GetUserID(context.Background(), "token1")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	addRefreshToken(t, s, session, "refresh1", now)
+
+	refresh, err := s.RefreshToken().Get(context.Background(), "refresh1")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.NotZero(t, refresh.ID)
+	assert.Equal(t, session.ID, refresh.SessionID)
+	assert.Equal(t, user.ID, refresh.UserID)
+	assert.WithinDuration(t, now, refresh.CreatedAt, timeTolerance)
+	assert.WithinDuration(t, now.Add(time.Hour), refresh.ExpiresAt, timeTolerance)
+	assert.Nil(t, refresh.UsedAt)
+
+	err = s.RefreshToken().Create(context.Background(), "refresh1", store.RefreshToken{
+		SessionID: session.ID,
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now,
+	})
+	assert.Equal(t, store.ErrDuplicate, err)
+
+	// A refresh token can be used only once.
+
+	usedAt := now.Add(time.Minute)
+	assert.NoError(t, s.RefreshToken().MarkUsed(context.Background(), "refresh1", usedAt))
+	assert.Equal(t, store.ErrNotFound, s.RefreshToken().MarkUsed(context.Background(), "refresh1", usedAt))
+	assert.Equal(t, store.ErrNotFound, s.RefreshToken().MarkUsed(context.Background(), "notexist", usedAt))
+
+	refresh, err = s.RefreshToken().Get(context.Background(), "refresh1")
+	if assert.NoError(t, err) && assert.NotNil(t, refresh.UsedAt) {
+		assert.WithinDuration(t, usedAt, *refresh.UsedAt, timeTolerance)
+	}
+
+	_, err = s.RefreshToken().Get(context.Background(), "notexist")
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+// Revoking a session also revokes its refresh tokens.
+func testRefreshTokenRevoke(t *testing.T, s store.Store) {
+	user1 := addUser(t, s, "username1")
+	user2 := addUser(t, s, "username2")
+
+	now := time.Now()
+
+	for _, token := range []string{"token1", "token2", "token3"} {
+		addToken(t, s, user1.ID, token, now)
+	}
+	addToken(t, s, user2.ID, "token4", now)
+
+	for i, token := range []string{"token1", "token2", "token3", "token4"} {
+		session, err := s.Token().GetUserID(context.Background(), token)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		addRefreshToken(t, s, session, fmt.Sprintf("refresh%d", i+1), now)
+	}
+
+	session1, err := s.Token().GetUserID(context.Background(), "token1")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.NoError(t, s.Token().DeleteByID(context.Background(), session1.ID))
+	assert.Equal(t, store.ErrNotFound, s.Token().DeleteByID(context.Background(), session1.ID))
+
+	_, err = s.Token().GetUserID(context.Background(), "token1")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	_, err = s.RefreshToken().Get(context.Background(), "refresh1")
+	assert.Equal(t, store.ErrNotFound, err, "revoked by DeleteByID")
+
+	assert.NoError(t, s.Token().Delete(context.Background(), "token2"))
+
+	_, err = s.RefreshToken().Get(context.Background(), "refresh2")
+	assert.Equal(t, store.ErrNotFound, err, "revoked by Delete")
+
+	assert.NoError(t, s.Token().DeleteByUserID(context.Background(), user1.ID))
+
+	_, err = s.RefreshToken().Get(context.Background(), "refresh3")
+	assert.Equal(t, store.ErrNotFound, err, "revoked by DeleteByUserID")
+
+	_, err = s.RefreshToken().Get(context.Background(), "refresh4")
+	assert.NoError(t, err, "refresh token of the other user")
+}
+
+func testPasswordReset(t *testing.T, s store.Store) {
+	user := addUser(t, s, "username")
+
+	now := time.Now()
+
+	err := s.PasswordReset().Create(context.Background(), "reset1", store.PasswordResetToken{
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	reset, err := s.PasswordReset().Get(context.Background(), "reset1")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.NotZero(t, reset.ID)
+	assert.Equal(t, user.ID, reset.UserID)
+	assert.WithinDuration(t, now, reset.CreatedAt, timeTolerance)
+	assert.WithinDuration(t, now.Add(time.Hour), reset.ExpiresAt, timeTolerance)
+	assert.Nil(t, reset.UsedAt)
+
+	err = s.PasswordReset().Create(context.Background(), "reset1", store.PasswordResetToken{
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now,
+	})
+	assert.Equal(t, store.ErrDuplicate, err)
+
+	// A token can be used only once.
+
+	usedAt := now.Add(time.Minute)
+	assert.NoError(t, s.PasswordReset().MarkUsed(context.Background(), "reset1", usedAt))
+	assert.Equal(t, store.ErrNotFound, s.PasswordReset().MarkUsed(context.Background(), "reset1", usedAt))
+	assert.Equal(t, store.ErrNotFound, s.PasswordReset().MarkUsed(context.Background(), "notexist", usedAt))
+
+	reset, err = s.PasswordReset().Get(context.Background(), "reset1")
+	if assert.NoError(t, err) && assert.NotNil(t, reset.UsedAt) {
+		assert.WithinDuration(t, usedAt, *reset.UsedAt, timeTolerance)
+	}
+
+	_, err = s.PasswordReset().Get(context.Background(), "notexist")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	// The tokens are removed along with the user.
+
+	assert.NoError(t, s.User().Delete(context.Background(), user.ID))
+
+	_, err = s.PasswordReset().Get(context.Background(), "reset1")
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+func testEmailVerification(t *testing.T, s store.Store) {
+	user := addUser(t, s, "username")
+
+	now := time.Now()
+
+	err := s.EmailVerification().Create(context.Background(), "verify1", store.EmailVerificationToken{
+		UserID:    user.ID,
+		Email:     "user@example.com",
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	verification, err := s.EmailVerification().Get(context.Background(), "verify1")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.NotZero(t, verification.ID)
+	assert.Equal(t, user.ID, verification.UserID)
+	assert.Equal(t, "user@example.com", verification.Email)
+	assert.WithinDuration(t, now, verification.CreatedAt, timeTolerance)
+	assert.WithinDuration(t, now.Add(time.Hour), verification.ExpiresAt, timeTolerance)
+	assert.Nil(t, verification.UsedAt)
+
+	err = s.EmailVerification().Create(context.Background(), "verify1", store.EmailVerificationToken{
+		UserID:    user.ID,
+		Email:     "user@example.com",
+		CreatedAt: now,
+		ExpiresAt: now,
+	})
+	assert.Equal(t, store.ErrDuplicate, err)
+
+	// A token can be used only once.
+
+	usedAt := now.Add(time.Minute)
+	assert.NoError(t, s.EmailVerification().MarkUsed(context.Background(), "verify1", usedAt))
+	assert.Equal(t, store.ErrNotFound, s.EmailVerification().MarkUsed(context.Background(), "verify1", usedAt))
+	assert.Equal(t, store.ErrNotFound, s.EmailVerification().MarkUsed(context.Background(), "notexist", usedAt))
+
+	verification, err = s.EmailVerification().Get(context.Background(), "verify1")
+	if assert.NoError(t, err) && assert.NotNil(t, verification.UsedAt) {
+		assert.WithinDuration(t, usedAt, *verification.UsedAt, timeTolerance)
+	}
+
+	_, err = s.EmailVerification().Get(context.Background(), "notexist")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	// The tokens are removed along with the user.
+
+	assert.NoError(t, s.User().Delete(context.Background(), user.ID))
+
+	_, err = s.EmailVerification().Get(context.Background(), "verify1")
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+func testLoginFailure(t *testing.T, s store.Store) {
+	now := time.Now()
+
+	_, err := s.LoginFailure().Get(context.Background(), "username:username")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	for i := 1; i <= 3; i++ {
+		f, err := s.LoginFailure().Add(context.Background(), "username:username", now.Add(time.Duration(i)*time.Second), now.Add(-time.Hour))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		assert.Equal(t, "username:username", f.Key)
+		assert.Equal(t, i, f.Count)
+		assert.WithinDuration(t, now.Add(time.Duration(i)*time.Second), f.LastFailedAt, timeTolerance)
+		assert.True(t, f.LockedUntil.IsZero())
+	}
+
+	// Another key has its own count.
+	f, err := s.LoginFailure().Add(context.Background(), "ip:192.0.2.1", now, now.Add(-time.Hour))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, f.Count)
+	}
+
+	// A failed login can be taken back, but not below zero.
+	assert.NoError(t, s.LoginFailure().Undo(context.Background(), "ip:192.0.2.1"))
+	assert.Equal(t, store.ErrNotFound, s.LoginFailure().Undo(context.Background(), "ip:192.0.2.1"))
+	assert.Equal(t, store.ErrNotFound, s.LoginFailure().Undo(context.Background(), "notexist"))
+
+	f, err = s.LoginFailure().Get(context.Background(), "ip:192.0.2.1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 0, f.Count)
+	}
+
+	assert.NoError(t, s.LoginFailure().Lock(context.Background(), "username:username", now.Add(time.Minute)))
+	assert.Equal(t, store.ErrNotFound, s.LoginFailure().Lock(context.Background(), "notexist", now))
+
+	f, err = s.LoginFailure().Get(context.Background(), "username:username")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, f.Count)
+		assert.WithinDuration(t, now.Add(time.Minute), f.LockedUntil, timeTolerance)
+	}
+
+	// The count starts over when the last failed login is old enough.
+	f, err = s.LoginFailure().Add(context.Background(), "username:username", now.Add(time.Hour), now.Add(time.Hour-time.Second))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, f.Count)
+		assert.WithinDuration(t, now.Add(time.Hour), f.LastFailedAt, timeTolerance)
+	}
+
+	assert.NoError(t, s.LoginFailure().Delete(context.Background(), "username:username"))
+	assert.Equal(t, store.ErrNotFound, s.LoginFailure().Delete(context.Background(), "username:username"))
+
+	_, err = s.LoginFailure().Get(context.Background(), "username:username")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	_, err = s.LoginFailure().Get(context.Background(), "ip:192.0.2.1")
+	assert.NoError(t, err)
+}
+
+func testTwoFactorTOTP(t *testing.T, s store.Store) {
+	user := addUser(t, s, "username")
+
+	now := time.Now()
+
+	_, err := s.TwoFactor().GetTOTP(context.Background(), user.ID)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	err = s.TwoFactor().SetTOTP(context.Background(), store.TOTP{UserID: user.ID, Secret: []byte("secret1"), CreatedAt: now})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	totp, err := s.TwoFactor().GetTOTP(context.Background(), user.ID)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, user.ID, totp.UserID)
+	assert.Equal(t, []byte("secret1"), totp.Secret)
+	assert.WithinDuration(t, now, totp.CreatedAt, timeTolerance)
+	assert.Nil(t, totp.ConfirmedAt)
+	assert.Zero(t, totp.LastUsedStep)
+
+	// A secret is confirmed only once.
+	assert.NoError(t, s.TwoFactor().ConfirmTOTP(context.Background(), user.ID, now.Add(time.Minute)))
+	assert.Equal(t, store.ErrNotFound, s.TwoFactor().ConfirmTOTP(context.Background(), user.ID, now))
+
+	// A time step is used only once, and never an older one.
+	assert.NoError(t, s.TwoFactor().UseTOTPStep(context.Background(), user.ID, 100))
+	assert.Equal(t, store.ErrNotFound, s.TwoFactor().UseTOTPStep(context.Background(), user.ID, 100))
+	assert.Equal(t, store.ErrNotFound, s.TwoFactor().UseTOTPStep(context.Background(), user.ID, 99))
+	assert.NoError(t, s.TwoFactor().UseTOTPStep(context.Background(), user.ID, 101))
+
+	totp, err = s.TwoFactor().GetTOTP(context.Background(), user.ID)
+	if assert.NoError(t, err) && assert.NotNil(t, totp.ConfirmedAt) {
+		assert.WithinDuration(t, now.Add(time.Minute), *totp.ConfirmedAt, timeTolerance)
+		assert.Equal(t, int64(101), totp.LastUsedStep)
+	}
+
+	// A new secret replaces the confirmed one.
+	err = s.TwoFactor().SetTOTP(context.Background(), store.TOTP{UserID: user.ID, Secret: []byte("secret2"), CreatedAt: now})
+	assert.NoError(t, err)
+
+	totp, err = s.TwoFactor().GetTOTP(context.Background(), user.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []byte("secret2"), totp.Secret)
+		assert.Nil(t, totp.ConfirmedAt)
+		assert.Zero(t, totp.LastUsedStep)
+	}
+
+	assert.NoError(t, s.TwoFactor().DeleteTOTP(context.Background(), user.ID))
+	assert.Equal(t, store.ErrNotFound, s.TwoFactor().DeleteTOTP(context.Background(), user.ID))
+	assert.Equal(t, store.ErrNotFound, s.TwoFactor().ConfirmTOTP(context.Background(), user.ID, now))
+	assert.Equal(t, store.ErrNotFound, s.TwoFactor().UseTOTPStep(context.Background(), user.ID, 200))
+
+	// The secret is removed along with the user.
+	err = s.TwoFactor().SetTOTP(context.Background(), store.TOTP{UserID: user.ID, Secret: []byte("secret3"), CreatedAt: now})
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.User().Delete(context.Background(), user.ID))
+
+	_, err = s.TwoFactor().GetTOTP(context.Background(), user.ID)
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+func testTwoFactorRecoveryCodes(t *testing.T, s store.Store) {
+	user1 := addUser(t, s, "username1")
+	user2 := addUser(t, s, "username2")
+
+	now := time.Now()
+
+	err := s.TwoFactor().SetTOTP(context.Background(), store.TOTP{UserID: user1.ID, Secret: []byte("secret"), CreatedAt: now})
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.TwoFactor().SetRecoveryCodes(context.Background(), user1.ID, []string{"code1", "code2"}))
+	assert.NoError(t, s.TwoFactor().SetRecoveryCodes(context.Background(), user2.ID, []string{"code3"}))
+
+	// A recovery code is used only once, and only by its user.
+	assert.NoError(t, s.TwoFactor().UseRecoveryCode(context.Background(), user1.ID, "code1"))
+	assert.Equal(t, store.ErrNotFound, s.TwoFactor().UseRecoveryCode(context.Background(), user1.ID, "code1"))
+	assert.Equal(t, store.ErrNotFound, s.TwoFactor().UseRecoveryCode(context.Background(), user1.ID, "code3"))
+
+	// New recovery codes replace the old ones.
+	assert.NoError(t, s.TwoFactor().SetRecoveryCodes(context.Background(), user1.ID, []string{"code4"}))
+	assert.Equal(t, store.ErrNotFound, s.TwoFactor().UseRecoveryCode(context.Background(), user1.ID, "code2"))
+
+	// The recovery codes are removed along with the TOTP secret.
+	assert.NoError(t, s.TwoFactor().DeleteTOTP(context.Background(), user1.ID))
+	assert.Equal(t, store.ErrNotFound, s.TwoFactor().UseRecoveryCode(context.Background(), user1.ID, "code4"))
+
+	assert.NoError(t, s.TwoFactor().UseRecoveryCode(context.Background(), user2.ID, "code3"))
+}
+
+func testTwoFactorChallenge(t *testing.T, s store.Store) {
+	user := addUser(t, s, "username")
+
+	now := time.Now()
+
+	err := s.TwoFactor().CreateChallenge(context.Background(), "challenge1", store.LoginChallenge{
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(5 * time.Minute),
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	err = s.TwoFactor().CreateChallenge(context.Background(), "challenge1", store.LoginChallenge{UserID: user.ID, CreatedAt: now, ExpiresAt: now})
+	assert.Equal(t, store.ErrDuplicate, err)
+
+	c, err := s.TwoFactor().GetChallenge(context.Background(), "challenge1")
+	if assert.NoError(t, err) {
+		assert.NotZero(t, c.ID)
+		assert.Equal(t, user.ID, c.UserID)
+		assert.WithinDuration(t, now, c.CreatedAt, timeTolerance)
+		assert.WithinDuration(t, now.Add(5*time.Minute), c.ExpiresAt, timeTolerance)
+	}
+
+	_, err = s.TwoFactor().GetChallenge(context.Background(), "notexist")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	assert.NoError(t, s.TwoFactor().DeleteChallenge(context.Background(), "challenge1"))
+	assert.Equal(t, store.ErrNotFound, s.TwoFactor().DeleteChallenge(context.Background(), "challenge1"))
+
+	// The challenges are removed along with the user.
+	err = s.TwoFactor().CreateChallenge(context.Background(), "challenge2", store.LoginChallenge{UserID: user.ID, CreatedAt: now, ExpiresAt: now})
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.User().Delete(context.Background(), user.ID))
+
+	_, err = s.TwoFactor().GetChallenge(context.Background(), "challenge2")
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+func testAPIKey(t *testing.T, s store.Store) {
+	user1 := addUser(t, s, "username1")
+	user2 := addUser(t, s, "username2")
+
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+
+	keyID, err := s.APIKey().Create(context.Background(), "key1", store.APIKey{
+		UserID:    user1.ID,
+		Name:      "bot",
+		Prefix:    "sk_abc",
+		Scopes:    []string{"messages:read", "messages:write"},
+		CreatedAt: now,
+		ExpiresAt: &expiresAt,
+	}, 10)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	_, err = s.APIKey().Create(context.Background(), "key1", store.APIKey{UserID: user1.ID, Name: "duplicate", CreatedAt: now}, 10)
+	assert.Equal(t, store.ErrDuplicate, err)
+
+	_, err = s.APIKey().Create(context.Background(), "key2", store.APIKey{
+		UserID:    user1.ID,
+		Name:      "never expires",
+		Prefix:    "sk_def",
+		Scopes:    []string{"messages:read"},
+		CreatedAt: now.Add(time.Second),
+	}, 10)
+	assert.NoError(t, err)
+
+	_, err = s.APIKey().Create(context.Background(), "key3", store.APIKey{UserID: user2.ID, Name: "other", CreatedAt: now}, 10)
+	assert.NoError(t, err)
+
+	k, err := s.APIKey().Get(context.Background(), "key1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, keyID, k.ID)
+		assert.Equal(t, user1.ID, k.UserID)
+		assert.Equal(t, "bot", k.Name)
+		assert.Equal(t, "sk_abc", k.Prefix)
+		assert.Equal(t, []string{"messages:read", "messages:write"}, k.Scopes)
+		assert.WithinDuration(t, now, k.CreatedAt, timeTolerance)
+		if assert.NotNil(t, k.ExpiresAt) {
+			assert.WithinDuration(t, expiresAt, *k.ExpiresAt, timeTolerance)
+		}
+		assert.Nil(t, k.LastUsedAt)
+	}
+
+	_, err = s.APIKey().Get(context.Background(), "notexist")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	// Newest first.
+	keys, err := s.APIKey().List(context.Background(), user1.ID)
+	if assert.NoError(t, err) && assert.Len(t, keys, 2) {
+		assert.Equal(t, "never expires", keys[0].Name)
+		assert.Nil(t, keys[0].ExpiresAt)
+		assert.Equal(t, "bot", keys[1].Name)
+	}
+
+	assert.NoError(t, s.APIKey().Touch(context.Background(), k.ID, now.Add(time.Minute)))
+	assert.Equal(t, store.ErrNotFound, s.APIKey().Touch(context.Background(), 1000, now))
+
+	k, err = s.APIKey().Get(context.Background(), "key1")
+	if assert.NoError(t, err) && assert.NotNil(t, k.LastUsedAt) {
+		assert.WithinDuration(t, now.Add(time.Minute), *k.LastUsedAt, timeTolerance)
+	}
+
+	// A user cannot delete the key of another user.
+	assert.Equal(t, store.ErrNotFound, s.APIKey().Delete(context.Background(), user2.ID, k.ID))
+	assert.NoError(t, s.APIKey().Delete(context.Background(), user1.ID, k.ID))
+	assert.Equal(t, store.ErrNotFound, s.APIKey().Delete(context.Background(), user1.ID, k.ID))
+
+	_, err = s.APIKey().Get(context.Background(), "key1")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	// The keys are removed along with the user.
+	assert.NoError(t, s.User().Delete(context.Background(), user1.ID))
+
+	_, err = s.APIKey().Get(context.Background(), "key2")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	_, err = s.APIKey().Get(context.Background(), "key3")
+	assert.NoError(t, err)
+}
+
+func testAPIKeyLimit(t *testing.T, s store.Store) {
+	user1 := addUser(t, s, "username1")
+	user2 := addUser(t, s, "username2")
+
+	const limit = 5
+
+	// The concurrent creates cannot go over the limit together.
+	var wg sync.WaitGroup
+	errs := make([]error, 2*limit)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = s.APIKey().Create(context.Background(), fmt.Sprintf("key%d", i), store.APIKey{
+				UserID:    user1.ID,
+				Name:      "bot",
+				CreatedAt: time.Now(),
+			}, limit)
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		if err == nil {
+			created++
+		} else {
+			assert.Equal(t, store.ErrLimit, err)
+		}
+	}
+	assert.Equal(t, limit, created)
+
+	keys, err := s.APIKey().List(context.Background(), user1.ID)
+	if assert.NoError(t, err) {
+		assert.Len(t, keys, limit)
+	}
+
+	// The limit is per user.
+	_, err = s.APIKey().Create(context.Background(), "other", store.APIKey{UserID: user2.ID, Name: "bot", CreatedAt: time.Now()}, limit)
+	assert.NoError(t, err)
+
+	// Deleting a key makes room for another.
+	assert.NoError(t, s.APIKey().Delete(context.Background(), user1.ID, keys[0].ID))
+
+	_, err = s.APIKey().Create(context.Background(), "new", store.APIKey{UserID: user1.ID, Name: "bot", CreatedAt: time.Now()}, limit)
+	assert.NoError(t, err)
+}
+
+func testTokenNotFound(t *testing.T, s store.Store) {
+	_, err := s.Token().GetUserID(context.Background(), "token")
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+func testMessageCreate(t *testing.T, s store.Store) {
+	user1 := addUser(t, s, "username1")
+	user2 := addUser(t, s, "username2")
+	user3 := addUser(t, s, "username3")
+
+	sentAt := time.Now()
+
+	msgID, err := s.Message().Create(context.Background(), store.Message{
+		Content:      "message content",
+		SenderID:     user1.ID,
+		SentDateTime: sentAt,
+	}, []int64{user2.ID, user3.ID})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	// The message is delivered to every recipient, but not to the sender.
+
+	for _, user := range []*store.User{user2, user3} {
+		messages, err := s.Message().Get(context.Background(), user.ID)
+		if !assert.NoError(t, err) || !assert.Len(t, messages, 1, user.Username) {
+			t.FailNow()
+		}
+
+		msg := messages[0]
+		assert.Equal(t, msgID, msg.ID)
+		assert.Equal(t, "message content", msg.Content)
+		assert.Equal(t, user1.ID, msg.SenderID)
+		assert.Equal(t, "username1", msg.Sender)
+		assert.WithinDuration(t, sentAt, msg.SentDateTime, timeTolerance)
+		assert.WithinDuration(t, sentAt, msg.UpdatedDateTime, timeTolerance)
+
+		byID, err := s.Message().GetByID(context.Background(), msg.ID)
+		if assert.NoError(t, err) {
+			assert.Equal(t, msg.ID, byID.ID)
+			assert.Equal(t, msg.Content, byID.Content)
+			assert.Equal(t, msg.SenderID, byID.SenderID)
+		}
+	}
+
+	messages, err := s.Message().Get(context.Background(), user1.ID)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 0)
+
+	// A recipient cannot receive the same message twice.
+
+	_, err = s.Message().Create(context.Background(), store.Message{
+		Content:      "message content",
+		SenderID:     user1.ID,
+		SentDateTime: sentAt,
+	}, []int64{user2.ID, user2.ID})
+	assert.Equal(t, store.ErrDuplicate, err)
+}
+
+func testMessageNotFound(t *testing.T, s store.Store) {
+	user := addUser(t, s, "username")
+
+	_, err := s.Message().GetByID(context.Background(), 1)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	err = s.Message().Update(context.Background(), store.Message{
+		ID:              1,
+		Content:         "message content",
+		UpdatedDateTime: time.Now(),
+	}, []int64{user.ID})
+	assert.Equal(t, store.ErrNotFound, err)
+
+	err = s.Message().Delete(context.Background(), 1)
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+func testMessagePage(t *testing.T, s store.Store) {
+	user1 := addUser(t, s, "username1")
+	user2 := addUser(t, s, "username2")
+	user3 := addUser(t, s, "username3")
+
+	base := time.Now().Add(-time.Hour)
+
+	// message 2 and message 3 are sent at the same time, so they are ordered by ID.
+	sentAt := []time.Duration{0, time.Second, 2 * time.Second, 2 * time.Second, 3 * time.Second}
+
+	for i, d := range sentAt {
+		_, err := s.Message().Create(context.Background(), store.Message{
+			Content:      fmt.Sprintf("message %d", i),
+			SenderID:     user1.ID,
+			SentDateTime: base.Add(d),
+		}, []int64{user2.ID})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+
+	// Not received by user2.
+	addMessage(t, s, user1.ID, "message to user3", user3.ID)
+
+	want := []string{"message 4", "message 3", "message 2", "message 1", "message 0"}
+
+	var got []string
+	var after *store.Cursor
+
+	for page := 0; page < 3; page++ {
+		messages, err := s.Message().GetPage(context.Background(), user2.ID, after, 2)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		if page < 2 {
+			assert.Len(t, messages, 2)
+		} else {
+			assert.Len(t, messages, 1)
+		}
+
+		for _, msg := range messages {
+			got = append(got, msg.Content)
+		}
+
+		if len(messages) == 0 {
+			break
+		}
+
+		last := messages[len(messages)-1]
+		after = &store.Cursor{SentDateTime: last.SentDateTime, ID: last.ID}
+	}
+
+	assert.Equal(t, want, got)
+
+	messages, err := s.Message().GetPage(context.Background(), user2.ID, after, 2)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 0, "after the last page")
+}
+
+func testMessageSent(t *testing.T, s store.Store) {
+	user1 := addUser(t, s, "username1")
+	user2 := addUser(t, s, "username2")
+	user3 := addUser(t, s, "username3")
+
+	msg1 := addMessage(t, s, user1.ID, "message 1", user3.ID, user2.ID)
+	addMessage(t, s, user2.ID, "from user2", user1.ID)
+
+	_, err := s.Message().Create(context.Background(), store.Message{
+		Content:      "message 2",
+		SenderID:     user1.ID,
+		SentDateTime: msg1.SentDateTime.Add(time.Second),
+	}, []int64{user2.ID})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	messages, err := s.Message().GetSent(context.Background(), user1.ID, nil, 10)
+	if !assert.NoError(t, err) || !assert.Len(t, messages, 2) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, "message 2", messages[0].Content)
+	assert.Equal(t, []int64{user2.ID}, messages[0].Recipients)
+
+	assert.Equal(t, "message 1", messages[1].Content)
+	assert.Equal(t, "username1", messages[1].Sender)
+	assert.Equal(t, []int64{user2.ID, user3.ID}, messages[1].Recipients, "sorted recipients")
+
+	// The second page.
+
+	after := &store.Cursor{SentDateTime: messages[0].SentDateTime, ID: messages[0].ID}
+
+	messages, err = s.Message().GetSent(context.Background(), user1.ID, after, 1)
+	if assert.NoError(t, err) && assert.Len(t, messages, 1) {
+		assert.Equal(t, msg1.ID, messages[0].ID)
+	}
+
+	// The recipients reflect the update.
+
+	msg1.UpdatedDateTime = time.Now()
+
+	err = s.Message().Update(context.Background(), *msg1, []int64{user3.ID})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	messages, err = s.Message().GetSent(context.Background(), user1.ID, after, 1)
+	if assert.NoError(t, err) && assert.Len(t, messages, 1) {
+		assert.Equal(t, []int64{user3.ID}, messages[0].Recipients)
+	}
+
+	messages, err = s.Message().GetSent(context.Background(), user3.ID, nil, 10)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 0)
+}
+
+// testMessageSentManyRecipients checks that a long list of recipients is not cut, e.g. by the 1024 bytes limit of
+// MySQL's GROUP_CONCAT.
+func testMessageSentManyRecipients(t *testing.T, s store.Store) {
+	sender := addUser(t, s, "sender")
+
+	var recipients []int64
+	for i := 0; i < 300; i++ {
+		recipients = append(recipients, addUser(t, s, fmt.Sprintf("recipient%03d", i)).ID)
+	}
+
+	addMessage(t, s, sender.ID, "message 1", recipients...)
+	addMessage(t, s, sender.ID, "message 2", recipients[:2]...)
+
+	messages, err := s.Message().GetSent(context.Background(), sender.ID, nil, 10)
+	if !assert.NoError(t, err) || !assert.Len(t, messages, 2) {
+		t.FailNow()
+	}
+
+	// The messages may be sent at the same time, so tell them apart by content.
+	if messages[0].Content != "message 1" {
+		messages[0], messages[1] = messages[1], messages[0]
+	}
+
+	assert.Equal(t, recipients, messages[0].Recipients)
+	assert.Equal(t, recipients[:2], messages[1].Recipients)
+}
+
+func testMessageUpdate(t *testing.T, s store.Store) {
+	user1 := addUser(t, s, "username1")
+	user2 := addUser(t, s, "username2")
+	user3 := addUser(t, s, "username3")
+
+	msg := addMessage(t, s, user1.ID, "message content", user2.ID, user3.ID)
+
+	// Replace the recipients: user3 is removed, and user1 is added.
+
+	msg.Content = "updated message content"
+	msg.UpdatedDateTime = msg.SentDateTime.Add(time.Minute)
+
+	err := s.Message().Update(context.Background(), *msg, []int64{user2.ID, user1.ID})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	for _, user := range []*store.User{user1, user2} {
+		messages, err := s.Message().Get(context.Background(), user.ID)
+		if !assert.NoError(t, err) || !assert.Len(t, messages, 1, user.Username) {
+			t.FailNow()
+		}
+
+		assert.Equal(t, "updated message content", messages[0].Content)
+		assert.WithinDuration(t, msg.SentDateTime, messages[0].SentDateTime, timeTolerance)
+		assert.WithinDuration(t, msg.UpdatedDateTime, messages[0].UpdatedDateTime, timeTolerance)
+	}
+
+	messages, err := s.Message().Get(context.Background(), user3.ID)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 0, "removed recipient")
+
+	recipients, err := s.Message().GetRecipients(context.Background(), msg.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{user1.ID, user2.ID}, recipients)
+}
+
+func testMessageDelete(t *testing.T, s store.Store) {
+	user1 := addUser(t, s, "username1")
+	user2 := addUser(t, s, "username2")
+
+	msg1 := addMessage(t, s, user1.ID, "message 1", user2.ID)
+	msg2 := addMessage(t, s, user1.ID, "message 2", user2.ID)
+
+	if !assert.NoError(t, s.Message().Delete(context.Background(), msg1.ID)) {
+		t.FailNow()
+	}
+
+	_, err := s.Message().GetByID(context.Background(), msg1.ID)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	messages, err := s.Message().Get(context.Background(), user2.ID)
+	if assert.NoError(t, err) && assert.Len(t, messages, 1) {
+		assert.Equal(t, msg2.ID, messages[0].ID)
+	}
+
+	assert.Equal(t, store.ErrNotFound, s.Message().Delete(context.Background(), msg1.ID))
+
+	recipients, err := s.Message().GetRecipients(context.Background(), msg1.ID)
+	assert.NoError(t, err)
+	assert.Len(t, recipients, 0, "recipients of the deleted message")
+}
+
+func testOutbox(t *testing.T, s store.Store) {
+	user1 := addUser(t, s, "username1")
+	user2 := addUser(t, s, "username2")
+	user3 := addUser(t, s, "username3")
+
+	msg := addMessage(t, s, user1.ID, "message content", user2.ID, user3.ID)
+
+	msg.Content = "updated message content"
+	msg.UpdatedDateTime = msg.SentDateTime.Add(time.Minute)
+
+	if !assert.NoError(t, s.Message().Update(context.Background(), *msg, []int64{user2.ID})) {
+		t.FailNow()
+	}
+
+	if !assert.NoError(t, s.Message().Delete(context.Background(), msg.ID)) {
+		t.FailNow()
+	}
+
+	// The failed changes write no event.
+	assert.Equal(t, store.ErrNotFound, s.Message().Delete(context.Background(), msg.ID))
+
+	events, err := s.Outbox().GetPending(context.Background(), 10)
+	if !assert.NoError(t, err) || !assert.Len(t, events, 3) {
+		t.FailNow()
+	}
+
+	expected := []struct {
+		typ                  string
+		content              string
+		recipientIDs         []int64
+		previousRecipientIDs []int64
+	}{
+		{store.EventMessageCreated, "message content", []int64{user2.ID, user3.ID}, nil},
+		{store.EventMessageUpdated, "updated message content", []int64{user2.ID}, []int64{user2.ID, user3.ID}},
+		{store.EventMessageDeleted, "updated message content", []int64{user2.ID}, nil},
+	}
+
+	for i, e := range events {
+		assert.Equal(t, expected[i].typ, e.Type)
+		assert.Nil(t, e.DeliveredAt)
+
+		if i > 0 {
+			assert.Greater(t, e.ID, events[i-1].ID, "ordered by ID")
+		}
+
+		var payload store.MessageEvent
+		if !assert.NoError(t, json.Unmarshal(e.Payload, &payload), e.Type) || !assert.NotNil(t, payload.Message, e.Type) {
+			continue
+		}
+
+		assert.Equal(t, msg.ID, payload.Message.ID, e.Type)
+		assert.Equal(t, expected[i].content, payload.Message.Content, e.Type)
+		assert.Equal(t, user1.Username, payload.Message.Sender, e.Type)
+		assert.Equal(t, user1.ID, payload.SenderID, e.Type)
+		assert.Equal(t, expected[i].recipientIDs, payload.RecipientIDs, e.Type)
+		assert.Equal(t, expected[i].previousRecipientIDs, payload.PreviousRecipientIDs, e.Type)
+	}
+
+	limited, err := s.Outbox().GetPending(context.Background(), 2)
+	if assert.NoError(t, err) && assert.Len(t, limited, 2) {
+		assert.Equal(t, events[0].ID, limited[0].ID)
+	}
+
+	deliveredAt := time.Now()
+
+	for _, e := range events[:2] {
+		if !assert.NoError(t, s.Outbox().MarkDelivered(context.Background(), e.ID, deliveredAt)) {
+			t.FailNow()
+		}
+	}
+
+	pending, err := s.Outbox().GetPending(context.Background(), 10)
+	if assert.NoError(t, err) && assert.Len(t, pending, 1) {
+		assert.Equal(t, events[2].ID, pending[0].ID)
+	}
+
+	// Only the events delivered before the time are deleted.
+	deleted, err := s.Outbox().DeleteDelivered(context.Background(), deliveredAt.Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), deleted)
+
+	deleted, err = s.Outbox().DeleteDelivered(context.Background(), deliveredAt.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	assert.Equal(t, store.ErrNotFound, s.Outbox().MarkDelivered(context.Background(), events[0].ID, deliveredAt))
+
+	pending, err = s.Outbox().GetPending(context.Background(), 10)
+	if assert.NoError(t, err) && assert.Len(t, pending, 1) {
+		assert.Equal(t, events[2].ID, pending[0].ID)
+	}
+}
+
+func addUser(t *testing.T, s store.Store, username string) *store.User {
+	err := s.User().Create(context.Background(), username, "password_hash")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	user, err := s.User().GetByUsername(context.Background(), username)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return user
+}
+
+func addToken(t *testing.T, s store.Store, userID int64, token string, createdAt time.Time) {
+	err := s.Token().Create(context.Background(), token, store.Token{
+		UserID:     userID,
+		CreatedAt:  createdAt,
+		LastUsedAt: createdAt,
+		ExpiresAt:  createdAt.Add(time.Hour),
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+}
+
+func addRefreshToken(t *testing.T, s store.Store, session *store.Token, token string, createdAt time.Time) {
+	err := s.RefreshToken().Create(context.Background(), token, store.RefreshToken{
+		SessionID: session.ID,
+		UserID:    session.UserID,
+		CreatedAt: createdAt,
+		ExpiresAt: createdAt.Add(time.Hour),
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+}
+
+// addMessage creates a message, and returns it as seen by the first recipient.
+func addMessage(t *testing.T, s store.Store, senderID int64, content string, recipients ...int64) *store.Message {
+	_, err := s.Message().Create(context.Background(), store.Message{
+		Content:      content,
+		SenderID:     senderID,
+		SentDateTime: time.Now(),
+	}, recipients)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	messages, err := s.Message().Get(context.Background(), recipients[0])
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	for _, msg := range messages {
+		if msg.Content == content {
+			return msg
+		}
+	}
+
+	t.Fatalf("message %q not found", content)
+	return nil
+}
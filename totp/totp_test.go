@@ -0,0 +1,77 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The test vectors of RFC 6238 for SHA-1, truncated to 6 digits.
+func TestCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	tests := []struct {
+		time int64
+		want string
+	}{
+		{time: 59, want: "287082"},
+		{time: 1111111109, want: "081804"},
+		{time: 1111111111, want: "050471"},
+		{time: 1234567890, want: "005924"},
+		{time: 2000000000, want: "279037"},
+		{time: 20000000000, want: "353130"},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, Code(secret, Step(time.Unix(tc.time, 0))), tc.time)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret, err := NewSecret()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	now := time.Unix(1600000000, 0)
+	current := Step(now)
+
+	for _, i := range []int64{-1, 0, 1} {
+		step, ok := Verify(secret, Code(secret, current+i), now, 1)
+		assert.True(t, ok, i)
+		assert.Equal(t, current+i, step, i)
+	}
+
+	for _, i := range []int64{-2, 2} {
+		_, ok := Verify(secret, Code(secret, current+i), now, 1)
+		assert.False(t, ok, i)
+	}
+
+	code := Code(secret, current)
+
+	_, ok := Verify(secret, code[:3]+" "+code[3:], now, 0)
+	assert.True(t, ok, "code with a space")
+
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		_, ok := Verify(secret, code, now, 1)
+		assert.False(t, ok, code)
+	}
+}
+
+func TestURI(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	u, err := url.Parse(URI("Example", "user@example.com", secret))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/Example:user@example.com", u.Path)
+	assert.Equal(t, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", u.Query().Get("secret"))
+	assert.Equal(t, "Example", u.Query().Get("issuer"))
+	assert.Equal(t, "6", u.Query().Get("digits"))
+}